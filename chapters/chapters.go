@@ -0,0 +1,149 @@
+// Package chapters splits a stream into one chapter per category it played
+// and marks the chapters in its media playlist.
+package chapters
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When a category was first seen on a stream, e.g. a row of stream_segments.
+type Observation struct {
+	GameId      string
+	GameName    string
+	FirstSeenAt time.Time
+}
+
+type Chapter struct {
+	GameId   string
+	GameName string
+	// seconds since the start of the stream
+	StartOffsetSeconds float64
+}
+
+// Observations should be ordered by FirstSeenAt.
+// A new chapter starts whenever the category differs from the previous one, so title changes do not start chapters.
+// The first chapter always starts at the beginning of the stream, since the scraper only sees a stream after it went live.
+func FromObservations(startTime time.Time, observations []Observation) []Chapter {
+	chapters := []Chapter{}
+	for _, observation := range observations {
+		numChapters := len(chapters)
+		if numChapters != 0 && chapters[numChapters-1].GameId == observation.GameId {
+			continue
+		}
+		offset := 0.0
+		if numChapters != 0 {
+			offset = observation.FirstSeenAt.Sub(startTime).Seconds()
+		}
+		chapters = append(chapters, Chapter{GameId: observation.GameId, GameName: observation.GameName, StartOffsetSeconds: offset})
+	}
+	return chapters
+}
+
+// How chapters are marked in a media playlist.
+type Marker int
+
+const (
+	// An EXT-X-DATERANGE tag per chapter with the game in X-GAME-ID and X-GAME-NAME.
+	// Each one comes with an EXT-X-PROGRAM-DATE-TIME tag, since HLS requires one in any playlist with date ranges.
+	DateRange Marker = iota + 1
+	// Only an EXT-X-PROGRAM-DATE-TIME tag per chapter.
+	ProgramDateTime
+)
+
+var ErrUnknownMarker = errors.New("unknown chapter marker")
+
+func ParseMarker(s string) (Marker, error) {
+	switch s {
+	case "daterange":
+		return DateRange, nil
+	case "program-date-time":
+		return ProgramDateTime, nil
+	}
+	return 0, ErrUnknownMarker
+}
+
+type boundary struct {
+	// index of the line of the segment's #EXTINF tag
+	line   int
+	offset float64
+}
+
+// Returns the start of every segment in the playlist.
+func segmentBoundaries(lines []string) []boundary {
+	boundaries := []boundary{}
+	offset := 0.0
+	for i, line := range lines {
+		rest, ok := strings.CutPrefix(line, "#EXTINF:")
+		if !ok {
+			continue
+		}
+		boundaries = append(boundaries, boundary{line: i, offset: offset})
+		duration, _, _ := strings.Cut(rest, ",")
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(duration), 64)
+		if err == nil {
+			offset += seconds
+		}
+	}
+	return boundaries
+}
+
+func nearestBoundary(boundaries []boundary, offset float64) int {
+	nearest := 0
+	for i, boundary := range boundaries {
+		if abs(boundary.offset-offset) < abs(boundaries[nearest].offset-offset) {
+			nearest = i
+		}
+	}
+	return nearest
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Quoted strings in HLS tags cannot contain double quotes or line breaks.
+var quotedStringReplacer = strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ")
+
+// Inserts a marker before the segment whose start is nearest to the start of each chapter.
+// If several chapters are nearest to the same segment, the last one wins.
+func Mark(playlist []byte, startTime time.Time, chapters []Chapter, marker Marker) []byte {
+	lines := strings.Split(string(playlist), "\n")
+	boundaries := segmentBoundaries(lines)
+	if len(boundaries) == 0 {
+		return playlist
+	}
+	// chapter index by line index
+	chapterAt := map[int]int{}
+	for i, chapter := range chapters {
+		chapterAt[boundaries[nearestBoundary(boundaries, chapter.StartOffsetSeconds)].line] = i
+	}
+	offsetAt := map[int]float64{}
+	for _, boundary := range boundaries {
+		offsetAt[boundary.line] = boundary.offset
+	}
+	buf := bytes.Buffer{}
+	for i, line := range lines {
+		if index, ok := chapterAt[i]; ok {
+			chapter := chapters[index]
+			date := startTime.Add(time.Duration(offsetAt[i] * float64(time.Second))).UTC().Format("2006-01-02T15:04:05.000Z")
+			fmt.Fprintf(&buf, "#EXT-X-PROGRAM-DATE-TIME:%s\n", date)
+			if marker == DateRange {
+				fmt.Fprintf(&buf, "#EXT-X-DATERANGE:ID=\"chapter-%d\",CLASS=\"com.twitch-vods.chapter\",START-DATE=\"%s\",X-GAME-ID=\"%s\",X-GAME-NAME=\"%s\"\n",
+					index, date, quotedStringReplacer.Replace(chapter.GameId), quotedStringReplacer.Replace(chapter.GameName))
+			}
+		}
+		buf.WriteString(line)
+		if i != len(lines)-1 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,104 @@
+package chapters
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func assertEqual[T comparable](t testing.TB, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+var testStartTime = time.Date(2023, 5, 21, 8, 0, 0, 0, time.UTC)
+
+func TestFromObservations(t *testing.T) {
+	chapters := FromObservations(testStartTime, []Observation{
+		{GameId: "509658", GameName: "Just Chatting", FirstSeenAt: testStartTime.Add(time.Minute)},
+		// a title change
+		{GameId: "509658", GameName: "Just Chatting", FirstSeenAt: testStartTime.Add(2 * time.Minute)},
+		{GameId: "32982", GameName: "Grand Theft Auto V", FirstSeenAt: testStartTime.Add(time.Hour)},
+	})
+	assertEqual(t, len(chapters), 2)
+	assertEqual(t, chapters[0], Chapter{GameId: "509658", GameName: "Just Chatting", StartOffsetSeconds: 0})
+	assertEqual(t, chapters[1], Chapter{GameId: "32982", GameName: "Grand Theft Auto V", StartOffsetSeconds: 3600})
+	assertEqual(t, len(FromObservations(testStartTime, nil)), 0)
+}
+
+func TestParseMarker(t *testing.T) {
+	marker, err := ParseMarker("daterange")
+	assertEqual(t, err, nil)
+	assertEqual(t, marker, DateRange)
+	marker, err = ParseMarker("program-date-time")
+	assertEqual(t, err, nil)
+	assertEqual(t, marker, ProgramDateTime)
+	_, err = ParseMarker("cue")
+	assertEqual(t, err, ErrUnknownMarker)
+}
+
+const testPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:10.000,
+0.ts
+#EXTINF:10.000,
+1-unmuted.ts
+#EXTINF:4.500,
+2.ts
+#EXT-X-ENDLIST
+`
+
+var testChapters = []Chapter{
+	{GameId: "509658", GameName: "Just Chatting", StartOffsetSeconds: 0},
+	// nearest to the start of 2.ts
+	{GameId: "32982", GameName: `Grand "Theft" Auto V`, StartOffsetSeconds: 18},
+}
+
+func TestMarkDateRange(t *testing.T) {
+	got := string(Mark([]byte(testPlaylist), testStartTime, testChapters, DateRange))
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PROGRAM-DATE-TIME:2023-05-21T08:00:00.000Z
+#EXT-X-DATERANGE:ID="chapter-0",CLASS="com.twitch-vods.chapter",START-DATE="2023-05-21T08:00:00.000Z",X-GAME-ID="509658",X-GAME-NAME="Just Chatting"
+#EXTINF:10.000,
+0.ts
+#EXTINF:10.000,
+1-unmuted.ts
+#EXT-X-PROGRAM-DATE-TIME:2023-05-21T08:00:20.000Z
+#EXT-X-DATERANGE:ID="chapter-1",CLASS="com.twitch-vods.chapter",START-DATE="2023-05-21T08:00:20.000Z",X-GAME-ID="32982",X-GAME-NAME="Grand 'Theft' Auto V"
+#EXTINF:4.500,
+2.ts
+#EXT-X-ENDLIST
+`
+	assertEqual(t, got, want)
+}
+
+func TestMarkProgramDateTime(t *testing.T) {
+	got := string(Mark([]byte(testPlaylist), testStartTime, testChapters, ProgramDateTime))
+	assertEqual(t, strings.Count(got, "#EXT-X-PROGRAM-DATE-TIME:"), 2)
+	assertEqual(t, strings.Contains(got, "#EXT-X-DATERANGE"), false)
+	assertEqual(t, strings.Contains(got, "#EXT-X-PROGRAM-DATE-TIME:2023-05-21T08:00:20.000Z\n#EXTINF:4.500,\n2.ts"), true)
+}
+
+func TestMarkSameSegmentKeepsLastChapter(t *testing.T) {
+	chapters := []Chapter{
+		{GameId: "509658", GameName: "Just Chatting", StartOffsetSeconds: 0},
+		{GameId: "32982", GameName: "Grand Theft Auto V", StartOffsetSeconds: 1},
+	}
+	got := string(Mark([]byte(testPlaylist), testStartTime, chapters, DateRange))
+	assertEqual(t, strings.Count(got, "#EXT-X-DATERANGE"), 1)
+	assertEqual(t, strings.Contains(got, `X-GAME-ID="32982"`), true)
+}
+
+func TestMarkWithoutSegments(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-ENDLIST\n"
+	assertEqual(t, string(Mark([]byte(playlist), testStartTime, testChapters, DateRange)), playlist)
+}
@@ -0,0 +1,149 @@
+// Package clip cuts a media playlist down to the segments covering a time range.
+package clip
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidRange = errors.New("clip range must satisfy 0 <= start < end")
+	ErrEmptyClip    = errors.New("no segments in clip range")
+)
+
+type segment struct {
+	// the #EXTINF tag and the tags before it
+	tags     []string
+	uri      string
+	start    float64
+	duration float64
+}
+
+type playlist struct {
+	header   []string
+	segments []segment
+}
+
+func parseDuration(extinf string) float64 {
+	duration, _, _ := strings.Cut(strings.TrimPrefix(extinf, "#EXTINF:"), ",")
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(duration), 64)
+	if err != nil {
+		return 0
+	}
+	return seconds
+}
+
+// Tags before the first #EXTINF are treated as playlist tags. Tags between segments belong to the next segment.
+// Lines after the last segment (e.g. #EXT-X-ENDLIST) are dropped.
+func parse(m3u8 string) playlist {
+	result := playlist{}
+	pending := []string{}
+	inSegment := false
+	offset := 0.0
+	for _, line := range strings.Split(strings.TrimRight(m3u8, "\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			if len(result.segments) == 0 {
+				result.header = pending
+				pending = []string{}
+			}
+			pending = append(pending, line)
+			inSegment = true
+		case inSegment && line != "" && !strings.HasPrefix(line, "#"):
+			duration := parseDuration(pending[len(pending)-1])
+			result.segments = append(result.segments, segment{tags: pending, uri: line, start: offset, duration: duration})
+			offset += duration
+			pending = []string{}
+			inSegment = false
+		default:
+			pending = append(pending, line)
+		}
+	}
+	if len(result.segments) == 0 {
+		result.header = pending
+	}
+	return result
+}
+
+func headerValue(line string, tag string) (int64, bool) {
+	rest, ok := strings.CutPrefix(line, tag)
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(rest), 10, 64)
+	return value, err == nil
+}
+
+func isDiscontinuity(segment segment) bool {
+	for _, tag := range segment.tags {
+		if tag == "#EXT-X-DISCONTINUITY" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the media playlist with only the segments that overlap [start, end), in seconds since the start of the playlist.
+// The target duration, media sequence and discontinuity sequence are rewritten to match the remaining segments,
+// and the clip always ends with #EXT-X-ENDLIST.
+func Clip(m3u8 []byte, start, end float64) ([]byte, error) {
+	if start < 0 || end <= start || math.IsNaN(start) || math.IsNaN(end) {
+		return nil, ErrInvalidRange
+	}
+	parsed := parse(string(m3u8))
+	first, last := -1, -1
+	for i, segment := range parsed.segments {
+		if segment.start < end && segment.start+segment.duration > start {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first == -1 {
+		return nil, ErrEmptyClip
+	}
+	kept := parsed.segments[first : last+1]
+	targetDuration := 0.0
+	for _, segment := range kept {
+		targetDuration = math.Max(targetDuration, math.Ceil(segment.duration))
+	}
+	droppedDiscontinuities := int64(0)
+	for _, segment := range parsed.segments[:first] {
+		if isDiscontinuity(segment) {
+			droppedDiscontinuities++
+		}
+	}
+	lines := []string{}
+	hasMediaSequence := false
+	hasDiscontinuitySequence := false
+	for _, line := range parsed.header {
+		if _, ok := headerValue(line, "#EXT-X-TARGETDURATION:"); ok {
+			line = fmt.Sprint("#EXT-X-TARGETDURATION:", int64(targetDuration))
+		} else if sequence, ok := headerValue(line, "#EXT-X-MEDIA-SEQUENCE:"); ok {
+			line = fmt.Sprint("#EXT-X-MEDIA-SEQUENCE:", sequence+int64(first))
+			hasMediaSequence = true
+		} else if sequence, ok := headerValue(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"); ok {
+			line = fmt.Sprint("#EXT-X-DISCONTINUITY-SEQUENCE:", sequence+droppedDiscontinuities)
+			hasDiscontinuitySequence = true
+		}
+		lines = append(lines, line)
+	}
+	// Both default to 0 when missing.
+	if !hasMediaSequence && first != 0 {
+		lines = append(lines, fmt.Sprint("#EXT-X-MEDIA-SEQUENCE:", first))
+	}
+	if !hasDiscontinuitySequence && droppedDiscontinuities != 0 {
+		lines = append(lines, fmt.Sprint("#EXT-X-DISCONTINUITY-SEQUENCE:", droppedDiscontinuities))
+	}
+	for _, segment := range kept {
+		lines = append(lines, segment.tags...)
+		lines = append(lines, segment.uri)
+	}
+	lines = append(lines, "#EXT-X-ENDLIST")
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
@@ -0,0 +1,91 @@
+package clip
+
+import (
+	"testing"
+)
+
+func assertEqual[T comparable](t testing.TB, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+const testPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:12
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MEDIA-SEQUENCE:5
+#EXTINF:12.000,
+0.ts
+#EXTINF:10.000,
+1-unmuted.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:10.000,
+2.ts
+#EXTINF:4.500,
+3.ts
+#EXT-X-ENDLIST
+`
+
+func TestClip(t *testing.T) {
+	got, err := Clip([]byte(testPlaylist), 33, 40)
+	assertEqual(t, err, nil)
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:5
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MEDIA-SEQUENCE:8
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXTINF:4.500,
+3.ts
+#EXT-X-ENDLIST
+`
+	assertEqual(t, string(got), want)
+}
+
+func TestClipKeepsSegmentTags(t *testing.T) {
+	got, err := Clip([]byte(testPlaylist), 12, 22)
+	assertEqual(t, err, nil)
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MEDIA-SEQUENCE:6
+#EXTINF:10.000,
+1-unmuted.ts
+#EXT-X-ENDLIST
+`
+	assertEqual(t, string(got), want)
+	got, err = Clip([]byte(testPlaylist), 20, 24)
+	assertEqual(t, err, nil)
+	want = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MEDIA-SEQUENCE:6
+#EXTINF:10.000,
+1-unmuted.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:10.000,
+2.ts
+#EXT-X-ENDLIST
+`
+	assertEqual(t, string(got), want)
+}
+
+func TestClipWithoutMediaSequence(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.000,\n0.ts\n#EXTINF:10.000,\n1.ts\n"
+	got, err := Clip([]byte(playlist), 15, 16)
+	assertEqual(t, err, nil)
+	assertEqual(t, string(got), "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:10.000,\n1.ts\n#EXT-X-ENDLIST\n")
+}
+
+func TestClipErrors(t *testing.T) {
+	_, err := Clip([]byte(testPlaylist), 10, 10)
+	assertEqual(t, err, ErrInvalidRange)
+	_, err = Clip([]byte(testPlaylist), -1, 10)
+	assertEqual(t, err, ErrInvalidRange)
+	_, err = Clip([]byte(testPlaylist), 100, 200)
+	assertEqual(t, err, ErrEmptyClip)
+}
@@ -8,18 +8,34 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/auoie/twitch-vods/chapters"
+	"github.com/auoie/twitch-vods/clip"
+	"github.com/auoie/twitch-vods/logging"
+	"github.com/auoie/twitch-vods/pagination"
+	"github.com/auoie/twitch-vods/renditions"
 	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/timewindow"
+	"github.com/auoie/twitch-vods/viewerseries"
+	"github.com/auoie/twitch-vods/vodstore"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/julienschmidt/httprouter"
 	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var ErrParse = errors.New("must contain @")
@@ -38,75 +54,224 @@ func bongHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	w.Write([]byte("bong"))
 }
 
-// It would be nice if SQLC created methods to access struct field like Genqlient
-func resultsGetPopularLiveStreams(ctx context.Context, w http.ResponseWriter, p httprouter.Params, queries *sqlvods.Queries) ([]*sqlvods.GetPopularLiveStreamsRow, error, bool) {
-	results, err := queries.GetPopularLiveStreams(ctx, sqlvods.GetPopularLiveStreamsParams{
-		Public: sql.NullBool{Bool: p.ByName("pub-status") == "public", Valid: true},
-		Limit:  50,
-	})
-	return results, err, false
+// Decodes ?cursor= for lists ordered by (max_views, id) DESC. The response has been written if done is true.
+func parseViewsCursor(w http.ResponseWriter, cursor string) (pagination.ViewsCursor, bool) {
+	decoded, err := pagination.DecodeViews(cursor)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return pagination.ViewsCursor{}, true
+	}
+	return decoded, false
 }
-func linkGetPopularLiveStreams(stream *sqlvods.GetPopularLiveStreamsRow) string {
-	return fmt.Sprint("/m3u8/", stream.StreamID, "/", stream.StartTime.Unix(), "/index.m3u8")
+
+var errInvalidFilter = errors.New("invalid filter")
+
+func parseNullBool(value string) (sql.NullBool, error) {
+	if value == "" {
+		return sql.NullBool{}, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return sql.NullBool{}, errInvalidFilter
+	}
+	return sql.NullBool{Bool: parsed, Valid: true}, nil
+}
+
+// Durations are in seconds, like hls_duration_seconds.
+func parseNullSeconds(value string) (sql.NullFloat64, error) {
+	if value == "" {
+		return sql.NullFloat64{}, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed < 0 {
+		return sql.NullFloat64{}, errInvalidFilter
+	}
+	return sql.NullFloat64{Float64: parsed, Valid: true}, nil
+}
+
+func parseNullInt64(value string) (sql.NullInt64, error) {
+	if value == "" {
+		return sql.NullInt64{}, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 0 {
+		return sql.NullInt64{}, errInvalidFilter
+	}
+	return sql.NullInt64{Int64: parsed, Valid: true}, nil
+}
+
+func parseNullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// Parses the filters of /streams. Missing filters match every stream.
+func parseSearchFilters(query url.Values) (vodstore.SearchStreamsParams, error) {
+	params := vodstore.SearchStreamsParams{
+		LanguageAtStart: parseNullString(query.Get("language")),
+		GameID:          parseNullString(query.Get("game_id")),
+	}
+	var err error
+	if params.Public, err = parseNullBool(query.Get("public")); err != nil {
+		return params, err
+	}
+	if params.IsMatureAtStart, err = parseNullBool(query.Get("mature")); err != nil {
+		return params, err
+	}
+	if params.MinDurationSeconds, err = parseNullSeconds(query.Get("min_duration")); err != nil {
+		return params, err
+	}
+	if params.MaxDurationSeconds, err = parseNullSeconds(query.Get("max_duration")); err != nil {
+		return params, err
+	}
+	if params.MinViews, err = parseNullInt64(query.Get("min_views")); err != nil {
+		return params, err
+	}
+	return params, nil
 }
 
-func resultsGetPopularLiveStreamsByLanguage(ctx context.Context, w http.ResponseWriter, p httprouter.Params, queries *sqlvods.Queries) ([]*sqlvods.GetPopularLiveStreamsByLanguageRow, error, bool) {
-	language, err := parseParam(p.ByName("language"))
+// Serves /streams and the older list routes, which are aliases of it with filters in the path:
+// /all/:pub-status, /language/:language/all/:pub-status and /category/:game-id/all/:pub-status.
+func resultsSearchStreams(ctx context.Context, w http.ResponseWriter, p httprouter.Params, store vodstore.Store, page listPage) ([]*vodstore.SearchStreamsRow, error, bool) {
+	params, err := parseSearchFilters(page.query)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return nil, nil, true
 	}
-	results, err := queries.GetPopularLiveStreamsByLanguage(ctx, sqlvods.GetPopularLiveStreamsByLanguageParams{
-		LanguageAtStart: language,
-		Public:          sql.NullBool{Bool: p.ByName("pub-status") == "public", Valid: true},
-		Limit:           50,
-	})
+	if pubStatus := p.ByName("pub-status"); pubStatus != "" {
+		params.Public = sql.NullBool{Bool: pubStatus == "public", Valid: true}
+	}
+	if p.ByName("language") != "" {
+		language, err := parseParam(p.ByName("language"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, nil, true
+		}
+		params.LanguageAtStart = sql.NullString{String: language, Valid: true}
+	}
+	if p.ByName("game-id") != "" {
+		categoryId, err := parseParam(p.ByName("game-id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, nil, true
+		}
+		params.GameID = sql.NullString{String: categoryId, Valid: true}
+	}
+	cursor, done := parseViewsCursor(w, page.cursor)
+	if done {
+		return nil, nil, true
+	}
+	params.Since = page.window.Since
+	params.Until = page.window.Until
+	params.CursorMaxViews = cursor.MaxViews
+	params.CursorID = cursor.ID
+	params.Limit = page.limit
+	results, err := store.SearchStreams(ctx, params)
 	return results, err, false
 }
-func linkGetPopularLiveStreamsByLanguage(stream *sqlvods.GetPopularLiveStreamsByLanguageRow) string {
+func cursorSearchStreams(stream *vodstore.SearchStreamsRow) string {
+	return pagination.ViewsCursor{MaxViews: stream.MaxViews, ID: stream.ID}.Encode()
+}
+func linkSearchStreams(stream *vodstore.SearchStreamsRow) string {
+	if !stream.BytesFound.Bool || stream.HlsGone {
+		return ""
+	}
 	return fmt.Sprint("/m3u8/", stream.StreamID, "/", stream.StartTime.Unix(), "/index.m3u8")
 }
 
-func resultsGetPopularLiveStreamsByGameId(ctx context.Context, w http.ResponseWriter, p httprouter.Params, queries *sqlvods.Queries) ([]*sqlvods.GetPopularLiveStreamsByGameIdRow, error, bool) {
-	categoryId, err := parseParam(p.ByName("game-id"))
+func resultsGetLatestStreamsFromStreamerLogin(ctx context.Context, w http.ResponseWriter, p httprouter.Params, store vodstore.Store, page listPage) ([]*sqlvods.GetLatestStreamsFromStreamerLoginRow, error, bool) {
+	name, err := parseParam(p.ByName("streamer"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, nil, true
+	}
+	cursor, err := pagination.DecodeTime(page.cursor)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return nil, nil, true
 	}
-	results, err := queries.GetPopularLiveStreamsByGameId(ctx, sqlvods.GetPopularLiveStreamsByGameIdParams{
-		GameIDAtStart: categoryId,
-		Public:        sql.NullBool{Bool: p.ByName("pub-status") == "public", Valid: true},
-		Limit:         50,
+	// Streams before the cursor and the end of the window are both before the earlier of the two.
+	if page.window.Until.Before(cursor.StartTime) {
+		cursor.StartTime = page.window.Until
+	}
+	results, err := store.GetLatestStreamsFromStreamerLogin(ctx, sqlvods.GetLatestStreamsFromStreamerLoginParams{
+		StreamerLoginAtStart: name,
+		Since:                page.window.Since,
+		CursorStartTime:      cursor.StartTime,
+		Limit:                page.limit,
 	})
 	return results, err, false
 }
-func linkGetPopularLiveStreamsByGameId(stream *sqlvods.GetPopularLiveStreamsByGameIdRow) string {
+func cursorGetLatestStreamsFromStreamerLogin(stream *sqlvods.GetLatestStreamsFromStreamerLoginRow) string {
+	return pagination.TimeCursor{StartTime: stream.StartTime}.Encode()
+}
+func linkGetLatestStreamsFromStreamerLogin(stream *sqlvods.GetLatestStreamsFromStreamerLoginRow) string {
+	if !stream.BytesFound.Bool || stream.HlsGone {
+		return ""
+	}
 	return fmt.Sprint("/m3u8/", stream.StreamID, "/", stream.StartTime.Unix(), "/index.m3u8")
 }
 
-func resultsGetLatestStreamsFromStreamerLogin(ctx context.Context, w http.ResponseWriter, p httprouter.Params, queries *sqlvods.Queries) ([]*sqlvods.GetLatestStreamsFromStreamerLoginRow, error, bool) {
-	name, err := parseParam(p.ByName("streamer"))
-	if err != nil {
+// Titles are at most 140 characters, so longer queries can't match well.
+const maxTitleQueryLength = 140
+
+// Ranked results have no keyset to start the next page after, so there is only one page of the ?limit= best matches.
+func resultsGetMatchingTitles(ctx context.Context, w http.ResponseWriter, p httprouter.Params, store vodstore.Store, page listPage) ([]*sqlvods.GetMatchingTitlesRow, error, bool) {
+	query := page.query.Get("q")
+	if strings.TrimSpace(query) == "" || utf8.RuneCountInString(query) > maxTitleQueryLength || page.cursor != "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return nil, nil, true
 	}
-	results, err := queries.GetLatestStreamsFromStreamerLogin(ctx, sqlvods.GetLatestStreamsFromStreamerLoginParams{
-		StreamerLoginAtStart: name,
-		Limit:                50,
+	results, err := store.GetMatchingTitles(ctx, sqlvods.GetMatchingTitlesParams{
+		Query: query,
+		Since: page.window.Since,
+		Until: page.window.Until,
+		Limit: page.limit,
 	})
 	return results, err, false
 }
-func linkGetLatestStreamsFromStreamerLogin(stream *sqlvods.GetLatestStreamsFromStreamerLoginRow) string {
+func cursorGetMatchingTitles(stream *sqlvods.GetMatchingTitlesRow) string {
+	return ""
+}
+func linkGetMatchingTitles(stream *sqlvods.GetMatchingTitlesRow) string {
+	if !stream.BytesFound.Bool || stream.HlsGone {
+		return ""
+	}
 	return fmt.Sprint("/m3u8/", stream.StreamID, "/", stream.StartTime.Unix(), "/index.m3u8")
 }
 
+// The page requested with ?cursor= and ?limit=, and the window requested with ?since=, ?until= and ?window=.
+type listPage struct {
+	// the whole query string, for lists with more filters
+	query url.Values
+	// empty for the first page
+	cursor string
+	// One more than the page size, so that the handler can tell whether there is a next page.
+	limit  int32
+	window timewindow.Window
+}
+
+// Serves a page of at most ?limit= streams (see ./pagination) starting after ?cursor=.
+// The response has the cursor of the next page, which is empty on the last page.
+// Only streams that started in the window (see ./timewindow) are listed, so the next page must be requested with the same window.
 func makeListHandler[T any](
 	ctx context.Context,
-	queries *sqlvods.Queries,
-	getResults func(context.Context, http.ResponseWriter, httprouter.Params, *sqlvods.Queries) ([]T, error, bool),
-	getLink func(T) string) httprouter.Handle {
+	store vodstore.Store,
+	getResults func(context.Context, http.ResponseWriter, httprouter.Params, vodstore.Store, listPage) ([]T, error, bool),
+	getLink func(T) string,
+	getCursor func(T) string) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		results, err, done := getResults(ctx, w, p, queries)
+		limit, err := pagination.ParseLimit(r.URL.Query().Get("limit"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		window, err := timewindow.Parse(query.Get("since"), query.Get("until"), query.Get("window"), time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		results, err, done := getResults(ctx, w, p, store, listPage{query: query, cursor: query.Get("cursor"), limit: limit + 1, window: window})
 		if done {
 			return
 		}
@@ -114,14 +279,18 @@ func makeListHandler[T any](
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		streamResults := []TStreamResult[T]{}
+		page := TStreamPage[T]{Results: []TStreamResult[T]{}}
+		if len(results) > int(limit) {
+			results = results[:limit]
+			page.Next = getCursor(results[limit-1])
+		}
 		for _, stream := range results {
-			streamResults = append(streamResults, TStreamResult[T]{
+			page.Results = append(page.Results, TStreamResult[T]{
 				Metadata: stream,
 				Link:     getLink(stream),
 			})
 		}
-		bytes, err := json.Marshal(streamResults)
+		bytes, err := json.Marshal(page)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -132,71 +301,359 @@ func makeListHandler[T any](
 	}
 }
 
-func makeM3U8Handler(ctx context.Context, queries *sqlvods.Queries) httprouter.Handle {
+func getChapters(ctx context.Context, store vodstore.Store, streamid string, startTime time.Time) ([]chapters.Chapter, error) {
+	segments, err := store.GetStreamSegments(ctx, sqlvods.GetStreamSegmentsParams{
+		StreamID:  streamid,
+		StartTime: startTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	observations := []chapters.Observation{}
+	for _, segment := range segments {
+		observations = append(observations, chapters.Observation{
+			GameId:      segment.GameID,
+			GameName:    segment.GameName,
+			FirstSeenAt: segment.FirstSeenAt,
+		})
+	}
+	return chapters.FromObservations(startTime, observations), nil
+}
+
+// Parses :streamid and :unix. The response has been written if done is true.
+func parseStream(w http.ResponseWriter, p httprouter.Params) (streamid string, startTime time.Time, done bool) {
+	streamid = p.ByName("streamid")
+	if streamid == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return "", time.Time{}, true
+	}
+	unix := p.ByName("unix")
+	if unix == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return "", time.Time{}, true
+	}
+	unix_int, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return "", time.Time{}, true
+	}
+	return streamid, time.Unix(unix_int, 0).UTC(), false
+}
+
+// Loads the stored playlist. The response has been written if done is true.
+func getStoredPlaylist(ctx context.Context, w http.ResponseWriter, store vodstore.Store, streamid string, startTime time.Time) (db_bytes []byte, done bool) {
+	streams, err := store.GetStreamGzippedBytes(ctx, sqlvods.GetStreamGzippedBytesParams{
+		StreamID:  streamid,
+		StartTime: startTime,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, true
+	}
+	if len(streams) == 0 || streams[0] == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, true
+	}
+	return streams[0], false
+}
+
+// Returns the renditions found for the stream, which are empty if they were never probed.
+func getRenditions(ctx context.Context, w http.ResponseWriter, store vodstore.Store, streamid string, startTime time.Time) (found []string, done bool) {
+	results, err := store.GetStreamRenditions(ctx, sqlvods.GetStreamRenditionsParams{
+		StreamID:  streamid,
+		StartTime: startTime,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, true
+	}
+	if len(results) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, true
+	}
+	return results[0], false
+}
+
+// Parses ?chapters=daterange or ?chapters=program-date-time. The marker is 0 if it is missing.
+func parseMarker(w http.ResponseWriter, r *http.Request) (marker chapters.Marker, done bool) {
+	if !r.URL.Query().Has("chapters") {
+		return 0, false
+	}
+	marker, err := chapters.ParseMarker(r.URL.Query().Get("chapters"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return 0, true
+	}
+	return marker, false
+}
+
+// Older rows are gzipped, newer rows are zstd compressed.
+func isGzipped(db_bytes []byte) bool {
+	return len(db_bytes) >= 2 && db_bytes[0] == 0x1f && db_bytes[1] == 0x8b
+}
+
+func decompressPlaylist(db_bytes []byte) ([]byte, error) {
+	if isGzipped(db_bytes) {
+		return gunzip(db_bytes)
+	}
+	decompressor, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+	return decompressor.DecodeAll(db_bytes, nil)
+}
+
+func writeGzippedPlaylist(w http.ResponseWriter, db_bytes []byte) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(db_bytes)))
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Write(db_bytes)
+}
+
+func writePlaylist(w http.ResponseWriter, m3u8_bytes []byte) {
+	gzip_buf := bytes.Buffer{}
+	compressor := gzip.NewWriter(&gzip_buf)
+	_, err := compressor.Write(m3u8_bytes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = compressor.Close()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeGzippedPlaylist(w, gzip_buf.Bytes())
+}
+
+func serveMediaPlaylist(ctx context.Context, w http.ResponseWriter, store vodstore.Store, streamid string, startTime time.Time, rendition string, marker chapters.Marker) {
+	db_bytes, done := getStoredPlaylist(ctx, w, store, streamid, startTime)
+	if done {
+		return
+	}
+	if rendition == renditions.Source && marker == 0 && isGzipped(db_bytes) {
+		writeGzippedPlaylist(w, db_bytes)
+		return
+	}
+	m3u8_bytes, err := decompressPlaylist(db_bytes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m3u8_bytes = renditions.MediaPlaylist(m3u8_bytes, rendition)
+	if marker != 0 {
+		streamChapters, err := getChapters(ctx, store, streamid, startTime)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		m3u8_bytes = chapters.Mark(m3u8_bytes, startTime, streamChapters, marker)
+	}
+	writePlaylist(w, m3u8_bytes)
+}
+
+// Serves a master playlist if the renditions of the stream were probed, and the source media playlist otherwise.
+// Adds chapter markers to the media playlists if ?chapters=daterange or ?chapters=program-date-time is set.
+func makeM3U8Handler(ctx context.Context, store vodstore.Store) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		streamid := p.ByName("streamid")
-		if streamid == "" {
+		marker, done := parseMarker(w, r)
+		if done {
+			return
+		}
+		streamid, startTime, done := parseStream(w, p)
+		if done {
+			return
+		}
+		found, done := getRenditions(ctx, w, store, streamid, startTime)
+		if done {
+			return
+		}
+		if len(found) == 0 {
+			serveMediaPlaylist(ctx, w, store, streamid, startTime, renditions.Source, marker)
+			return
+		}
+		query := ""
+		if r.URL.RawQuery != "" {
+			query = "?" + r.URL.RawQuery
+		}
+		writePlaylist(w, renditions.MasterPlaylist(found, func(name string) string {
+			return fmt.Sprint("renditions/", name, "/index.m3u8", query)
+		}))
+	}
+}
+
+// Serves the media playlist of one rendition. Only the source exists if the renditions were never probed.
+func makeRenditionHandler(ctx context.Context, store vodstore.Store) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		marker, done := parseMarker(w, r)
+		if done {
+			return
+		}
+		streamid, startTime, done := parseStream(w, p)
+		if done {
+			return
+		}
+		rendition := p.ByName("rendition")
+		if _, ok := renditions.Get(rendition); !ok {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		unix := p.ByName("unix")
-		if unix == "" {
+		found, done := getRenditions(ctx, w, store, streamid, startTime)
+		if done {
+			return
+		}
+		if len(found) == 0 {
+			found = []string{renditions.Source}
+		}
+		if !slices.Contains(found, rendition) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		serveMediaPlaylist(ctx, w, store, streamid, startTime, rendition, marker)
+	}
+}
+
+// Serves the audio_only rendition. Responds 404 if it was not found, including when the renditions were never probed.
+func makeAudioHandler(ctx context.Context, store vodstore.Store) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		marker, done := parseMarker(w, r)
+		if done {
+			return
+		}
+		streamid, startTime, done := parseStream(w, p)
+		if done {
+			return
+		}
+		found, done := getRenditions(ctx, w, store, streamid, startTime)
+		if done {
+			return
+		}
+		rendition, ok := renditions.ForAudio(found)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		serveMediaPlaylist(ctx, w, store, streamid, startTime, rendition, marker)
+	}
+}
+
+// Serves the segments overlapping [start, end), given in seconds since the start of the stream.
+func makeClipHandler(ctx context.Context, store vodstore.Store) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start, err := strconv.ParseFloat(r.URL.Query().Get("start"), 64)
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		unix_int, err := strconv.ParseInt(unix, 10, 64)
+		end, err := strconv.ParseFloat(r.URL.Query().Get("end"), 64)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		streams, err := queries.GetStreamGzippedBytes(ctx, sqlvods.GetStreamGzippedBytesParams{
-			StreamID:  streamid,
-			StartTime: time.Unix(unix_int, 0).UTC(),
-		})
+		streamid, startTime, done := parseStream(w, p)
+		if done {
+			return
+		}
+		db_bytes, done := getStoredPlaylist(ctx, w, store, streamid, startTime)
+		if done {
+			return
+		}
+		m3u8_bytes, err := decompressPlaylist(db_bytes)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if len(streams) == 0 {
+		clipped, err := clip.Clip(m3u8_bytes, start, end)
+		if errors.Is(err, clip.ErrInvalidRange) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, clip.ErrEmptyClip) {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		db_bytes := streams[0]
-		if db_bytes == nil {
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writePlaylist(w, clipped)
+	}
+}
+
+func gunzip(compressed []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Returns the chapters of a stream as JSON, one per category it played.
+func makeChaptersHandler(ctx context.Context, store vodstore.Store) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		streamid := p.ByName("streamid")
+		unix, err := strconv.ParseInt(p.ByName("unix"), 10, 64)
+		if streamid == "" || err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		streamChapters, err := getChapters(ctx, store, streamid, time.Unix(unix, 0).UTC())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(streamChapters) == 0 {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		decompressor, err := zstd.NewReader(nil)
+		bytes, err := json.Marshal(streamChapters)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		m3u8_bytes, err := decompressor.DecodeAll(db_bytes, nil)
-		defer decompressor.Close()
+		w.Header().Set("Content-Length", strconv.Itoa(len(bytes)))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	}
+}
+
+// Returns the viewer counts, games and titles of a stream over time as JSON.
+func makeViewerSeriesHandler(ctx context.Context, store vodstore.Store) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		streamid := p.ByName("streamid")
+		unix, err := strconv.ParseInt(p.ByName("unix"), 10, 64)
+		if streamid == "" || err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		results, err := store.GetStreamViewerSeries(ctx, sqlvods.GetStreamViewerSeriesParams{
+			StreamID:  streamid,
+			StartTime: time.Unix(unix, 0).UTC(),
+		})
 		if err != nil {
-			w.Header().Set("Content-Length", strconv.Itoa(len(db_bytes)))
-			w.Header().Set("Content-Type", "application/x-mpegURL")
-			w.Header().Set("Content-Encoding", "gzip")
-			w.Write(db_bytes)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(results) == 0 || results[0] == nil {
+			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		gzip_buf := bytes.Buffer{}
-		compressor := gzip.NewWriter(&gzip_buf)
-		_, err = compressor.Write(m3u8_bytes)
+		series, err := viewerseries.Decode(results[0])
 		if err != nil {
+			slog.Error("failed to decode viewer series", "stream_id", streamid, "start_time", unix, "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		err = compressor.Close()
+		bytes, err := json.Marshal(series)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		gzip_bytes := gzip_buf.Bytes()
-		w.Header().Set("Content-Length", strconv.Itoa(len(gzip_bytes)))
-		w.Header().Set("Content-Type", "application/x-mpegURL")
-		w.Header().Set("Content-Encoding", "gzip")
-		w.Write(gzip_bytes)
+		w.Header().Set("Content-Length", strconv.Itoa(len(bytes)))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
 	}
 }
 
@@ -227,23 +684,21 @@ func makeLanguagesListHandler(languagesLock *LockValue[[]*sqlvods.GetLanguagesRo
 		w.Write(bytes)
 	}
 }
-func swap[T any](vals []T, i, j int) {
-	if i != j {
-		vals[i], vals[j] = vals[j], vals[i]
-	}
-}
 
-func makeSearchHandler(ctx context.Context, regexCheck *regexp.Regexp, queries *sqlvods.Queries) httprouter.Handle {
+func makeSearchHandler(ctx context.Context, regexCheck *regexp.Regexp, store vodstore.Store) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		streamer := p.ByName("streamer")
 		if !regexCheck.MatchString(streamer) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		results, err := queries.GetMatchingStreamers(ctx, sqlvods.GetMatchingStreamersParams{
-			Limit:                  20,
-			StreamerLoginAtStart:   streamer,
-			StreamerLoginAtStart_2: fmt.Sprint("%", streamer, "%")})
+		// The exact match comes first, then the logins ranked by trigram similarity.
+		// Logins that only contain the query, e.g. 3 letters from the middle, are matched with ILIKE.
+		results, err := store.GetMatchingStreamers(ctx, sqlvods.GetMatchingStreamersParams{
+			Query:   streamer,
+			Pattern: fmt.Sprint("%", strings.ReplaceAll(streamer, "_", `\_`), "%"),
+			Limit:   20,
+		})
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -251,12 +706,6 @@ func makeSearchHandler(ctx context.Context, regexCheck *regexp.Regexp, queries *
 		if results == nil {
 			results = []*sqlvods.GetMatchingStreamersRow{}
 		}
-		for i := 0; i < len(results); i++ {
-			if streamer == results[i].StreamerLoginAtStart {
-				swap(results, 0, i)
-				break
-			}
-		}
 		bytes, err := json.Marshal(results)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -269,10 +718,17 @@ func makeSearchHandler(ctx context.Context, regexCheck *regexp.Regexp, queries *
 }
 
 type TStreamResult[T any] struct {
+	// empty if the VOD was deleted from its domain
 	Link     string
 	Metadata T
 }
 
+type TStreamPage[T any] struct {
+	Results []TStreamResult[T]
+	// cursor of the next page, empty on the last page
+	Next string
+}
+
 type CustomHandler struct {
 	router    *httprouter.Router
 	clientUrl string
@@ -300,46 +756,81 @@ func (lv *LockValue[T]) Set(value T) {
 	lv.value = value
 }
 
+// Registers the routes of the API, which serve from store and the categories and languages that main refreshes every hour.
+func newRouter(
+	ctx context.Context,
+	store vodstore.Store,
+	categoriesLock *LockValue[[]*sqlvods.GetPopularCategoriesRow],
+	languagesLock *LockValue[[]*sqlvods.GetLanguagesRow],
+	twitchUsernameRegex *regexp.Regexp) *httprouter.Router {
+	router := httprouter.New()
+	// Every route except /metrics is instrumented.
+	get := func(route string, handle httprouter.Handle) {
+		router.GET(route, instrument(route, handle))
+	}
+	// pub-status: either public or private
+	get("/", okHandler)
+	get("/bing", bongHandler)
+	searchStreamsHandler := makeListHandler(ctx, store, resultsSearchStreams, linkSearchStreams, cursorSearchStreams)
+	get("/streams", searchStreamsHandler)
+	get("/all/:pub-status", searchStreamsHandler)
+	get("/language/:language/all/:pub-status", searchStreamsHandler)
+	get("/category/:game-id/all/:pub-status", searchStreamsHandler)
+	get("/channels/:streamer", makeListHandler(ctx, store, resultsGetLatestStreamsFromStreamerLogin, linkGetLatestStreamsFromStreamerLogin, cursorGetLatestStreamsFromStreamerLogin))
+	get("/categories", makeCategoriesListHandler(categoriesLock))
+	get("/languages", makeLanguagesListHandler(languagesLock))
+	get("/titles/search", makeListHandler(ctx, store, resultsGetMatchingTitles, linkGetMatchingTitles, cursorGetMatchingTitles))
+	get("/search/:streamer", makeSearchHandler(ctx, twitchUsernameRegex, store))
+	get("/m3u8/:streamid/:unix/index.m3u8", makeM3U8Handler(ctx, store))
+	get("/m3u8/:streamid/:unix/clip.m3u8", makeClipHandler(ctx, store))
+	get("/m3u8/:streamid/:unix/audio.m3u8", makeAudioHandler(ctx, store))
+	get("/m3u8/:streamid/:unix/renditions/:rendition/index.m3u8", makeRenditionHandler(ctx, store))
+	get("/viewers/:streamid/:unix", makeViewerSeriesHandler(ctx, store))
+	get("/chapters/:streamid/:unix", makeChaptersHandler(ctx, store))
+	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
+	return router
+}
+
 func main() {
+	err := logging.SetDefaultFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 	ctx := context.Background()
 	// init app
 	port, ok := os.LookupEnv("PORT")
 	if !ok {
-		log.Fatal("PORT is missing to listen on")
+		logging.Fatal("PORT is missing to listen on")
 	}
 	databaseUrl, ok := os.LookupEnv("DATABASE_URL")
 	if !ok {
-		log.Fatal("DATABASE_URL is missing for db connection string")
+		logging.Fatal("DATABASE_URL is missing for db connection string")
 	}
 	clientUrl, ok := os.LookupEnv("CLIENT_URL")
 	if !ok {
-		log.Fatal("CLIENT_URL is missing for CORS")
+		logging.Fatal("CLIENT_URL is missing for CORS")
 	}
 	conn, err := pgxpool.Connect(ctx, databaseUrl)
 	if err != nil {
-		log.Println(fmt.Sprint("failed to connect to ", databaseUrl, ": ", err))
-		log.Fatal(err)
+		logging.Fatal("failed to connect to database", "error", err)
 	}
 	defer conn.Close()
 	err = conn.Ping(ctx)
 	if err != nil {
-		log.Println(fmt.Sprint("failed to ping ", databaseUrl, ": ", err))
-		log.Fatal(err)
+		logging.Fatal("failed to ping database", "error", err)
 	}
-	queries := sqlvods.New(conn)
-	router := httprouter.New()
-	handler := &CustomHandler{router: router, clientUrl: clientUrl}
+	store := vodstore.NewPostgres(conn)
 
 	// should use rabbitmq or apache kafka instead of polling every hour
 	categoriesLock := &LockValue[[]*sqlvods.GetPopularCategoriesRow]{}
 	setPopularCategories := func() {
-		log.Println("Fetching categories")
-		categories, err := queries.GetPopularCategories(ctx, 200)
+		slog.Debug("fetching categories")
+		categories, err := store.GetPopularCategories(ctx, 200)
 		if err == nil {
 			categoriesLock.Set(categories)
-			log.Println("Set categories")
+			slog.Info("set categories", "count", len(categories))
 		} else {
-			log.Println("Failed to set categories")
+			slog.Error("failed to set categories", "error", err)
 		}
 	}
 	go func(ctx context.Context) {
@@ -356,13 +847,13 @@ func main() {
 	}(ctx)
 	languagesLock := &LockValue[[]*sqlvods.GetLanguagesRow]{}
 	setLanguages := func() {
-		log.Println("Fetching languages")
-		languages, err := queries.GetLanguages(ctx)
+		slog.Debug("fetching languages")
+		languages, err := store.GetLanguages(ctx)
 		if err == nil {
 			languagesLock.Set(languages)
-			log.Println("Set languages")
+			slog.Info("set languages", "count", len(languages))
 		} else {
-			log.Println("Failed to set languages")
+			slog.Error("failed to set languages", "error", err)
 		}
 	}
 	go func(ctx context.Context) {
@@ -379,21 +870,13 @@ func main() {
 	}(ctx)
 	twitchUsernameRegex, err := regexp.Compile("^[a-zA-Z0-9_]{1,50}$")
 	if err != nil {
-		log.Fatal(fmt.Sprint("Failed to compile regex: ", err))
+		logging.Fatal("failed to compile regex", "error", err)
 	}
 
-	// pub-status: either public or private
-	router.GET("/", okHandler)
-	router.GET("/bing", bongHandler)
-	router.GET("/all/:pub-status", makeListHandler(ctx, queries, resultsGetPopularLiveStreams, linkGetPopularLiveStreams))
-	router.GET("/language/:language/all/:pub-status", makeListHandler(ctx, queries, resultsGetPopularLiveStreamsByLanguage, linkGetPopularLiveStreamsByLanguage))
-	router.GET("/category/:game-id/all/:pub-status", makeListHandler(ctx, queries, resultsGetPopularLiveStreamsByGameId, linkGetPopularLiveStreamsByGameId))
-	router.GET("/channels/:streamer", makeListHandler(ctx, queries, resultsGetLatestStreamsFromStreamerLogin, linkGetLatestStreamsFromStreamerLogin))
-	router.GET("/categories", makeCategoriesListHandler(categoriesLock))
-	router.GET("/languages", makeLanguagesListHandler(languagesLock))
-	router.GET("/search/:streamer", makeSearchHandler(ctx, twitchUsernameRegex, queries))
-	router.GET("/m3u8/:streamid/:unix/index.m3u8", makeM3U8Handler(ctx, queries))
-	log.Println(fmt.Sprint("Serving on port :", port))
-
-	http.ListenAndServe(fmt.Sprint(":", port), handler)
+	router := newRouter(ctx, store, categoriesLock, languagesLock, twitchUsernameRegex)
+	handler := &CustomHandler{router: router, clientUrl: clientUrl}
+	slog.Info("serving API", "port", port)
+
+	err = http.ListenAndServe(fmt.Sprint(":", port), handler)
+	logging.Fatal("API server failed", "error", err)
 }
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/auoie/twitch-vods/pagination"
+	"github.com/auoie/twitch-vods/renditions"
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/julienschmidt/httprouter"
+)
+
+func assertEqual[T comparable](t testing.TB, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+func assertNoError(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testStream struct {
+	streamId  string
+	login     string
+	title     string
+	language  string
+	startTime time.Time
+	views     int64
+	public    bool
+	// the probed renditions, nil if they were never probed
+	renditions []string
+}
+
+// Stores the streams like the scraper does after polling them and fetching their .m3u8.
+func makeTestStore(t testing.TB, streams ...testStream) *vodstore.Memory {
+	t.Helper()
+	ctx := context.Background()
+	store := vodstore.NewMemory()
+	params := sqlvods.UpsertManyStreamsParams{}
+	streamerParams := sqlvods.UpsertManyStreamersParams{}
+	for _, stream := range streams {
+		lastUpdated := stream.startTime.Add(time.Hour)
+		params.LastUpdatedAtArr = append(params.LastUpdatedAtArr, lastUpdated)
+		params.MaxViewsArr = append(params.MaxViewsArr, stream.views)
+		params.StartTimeArr = append(params.StartTimeArr, stream.startTime)
+		params.StreamerIDArr = append(params.StreamerIDArr, "id-"+stream.login)
+		params.StreamIDArr = append(params.StreamIDArr, stream.streamId)
+		params.StreamerLoginAtStartArr = append(params.StreamerLoginAtStartArr, stream.login)
+		params.GameNameAtStartArr = append(params.GameNameAtStartArr, "Just Chatting")
+		params.LanguageAtStartArr = append(params.LanguageAtStartArr, stream.language)
+		params.TitleAtStartArr = append(params.TitleAtStartArr, stream.title)
+		params.GameIDAtStartArr = append(params.GameIDAtStartArr, "509658")
+		params.IsMatureAtStartArr = append(params.IsMatureAtStartArr, false)
+		params.LastUpdatedMinusStartTimeSecondsArr = append(params.LastUpdatedMinusStartTimeSecondsArr, time.Hour.Seconds())
+		streamerParams.StreamerIDArr = append(streamerParams.StreamerIDArr, "id-"+stream.login)
+		streamerParams.StartTimeArr = append(streamerParams.StartTimeArr, stream.startTime)
+		streamerParams.StreamerLoginAtStartArr = append(streamerParams.StreamerLoginAtStartArr, stream.login)
+	}
+	assertNoError(t, store.UpsertManyStreams(ctx, params))
+	assertNoError(t, store.UpsertManyStreamers(ctx, streamerParams))
+	gzipped := bytes.Buffer{}
+	writer := gzip.NewWriter(&gzipped)
+	_, err := writer.Write([]byte("#EXTM3U\n#EXTINF:10.000,\nhttps://example.com/abc/chunked/0.ts\n#EXT-X-ENDLIST\n"))
+	assertNoError(t, err)
+	assertNoError(t, writer.Close())
+	for _, stream := range streams {
+		assertNoError(t, store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+			StreamID:     stream.streamId,
+			StartTime:    stream.startTime,
+			GzippedBytes: gzipped.Bytes(),
+			BytesFound:   sql.NullBool{Bool: true, Valid: true},
+			Public:       sql.NullBool{Bool: stream.public, Valid: true},
+			Renditions:   stream.renditions,
+		}))
+	}
+	return store
+}
+
+func makeTestRouter(store vodstore.Store) *httprouter.Router {
+	return newRouter(
+		context.Background(),
+		store,
+		&LockValue[[]*sqlvods.GetPopularCategoriesRow]{},
+		&LockValue[[]*sqlvods.GetLanguagesRow]{},
+		regexp.MustCompile("^[a-zA-Z0-9_]{1,50}$"))
+}
+
+// Returns the status code of a GET of target and decodes the body into result if it is 200.
+func get(t testing.TB, router *httprouter.Router, target string, result any) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	if recorder.Code == http.StatusOK && result != nil {
+		assertNoError(t, json.Unmarshal(recorder.Body.Bytes(), result))
+	}
+	return recorder.Code
+}
+
+func getStreamIds(page TStreamPage[vodstore.SearchStreamsRow]) string {
+	ids := ""
+	for _, result := range page.Results {
+		ids += result.Metadata.StreamID + " "
+	}
+	return ids
+}
+
+func TestListHandlerPages(t *testing.T) {
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	router := makeTestRouter(makeTestStore(t,
+		testStream{streamId: "1", login: "a", startTime: start, views: 10, public: true},
+		testStream{streamId: "2", login: "b", startTime: start, views: 30, public: true},
+		testStream{streamId: "3", login: "c", startTime: start, views: 20, public: true},
+		testStream{streamId: "4", login: "d", startTime: start, views: 40, public: false},
+	))
+	// The handler fetches one more stream than ?limit= to tell that there is a next page.
+	var page TStreamPage[vodstore.SearchStreamsRow]
+	assertEqual(t, get(t, router, "/all/public?limit=2", &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "2 3 ")
+	assertEqual(t, page.Results[0].Link, fmt.Sprint("/m3u8/2/", start.Unix(), "/index.m3u8"))
+	assertEqual(t, page.Next != "", true)
+	next := page.Next
+	page = TStreamPage[vodstore.SearchStreamsRow]{}
+	assertEqual(t, get(t, router, "/all/public?limit=2&cursor="+url.QueryEscape(next), &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "1 ")
+	assertEqual(t, page.Next, "")
+	// A page that holds exactly the remaining streams is the last one.
+	page = TStreamPage[vodstore.SearchStreamsRow]{}
+	assertEqual(t, get(t, router, "/all/public?limit=3", &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "2 3 1 ")
+	assertEqual(t, page.Next, "")
+	// /streams without filters lists public and private streams.
+	page = TStreamPage[vodstore.SearchStreamsRow]{}
+	assertEqual(t, get(t, router, "/streams", &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "4 2 3 1 ")
+}
+
+func TestListHandlerLinksOnlyFoundRecordings(t *testing.T) {
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := makeTestStore(t,
+		testStream{streamId: "old", login: "a", startTime: start, views: 10, public: true},
+		testStream{streamId: "new", login: "a", startTime: start.Add(time.Hour), views: 20, public: true},
+	)
+	numCleared, err := store.ClearOldRecordings(context.Background(), sqlvods.ClearOldRecordingsParams{StartTime: start.Add(time.Minute), Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, numCleared, int64(1))
+	router := makeTestRouter(store)
+	var page TStreamPage[vodstore.SearchStreamsRow]
+	assertEqual(t, get(t, router, "/all/public", &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "new old ")
+	assertEqual(t, page.Results[0].Link, fmt.Sprint("/m3u8/new/", start.Add(time.Hour).Unix(), "/index.m3u8"))
+	assertEqual(t, page.Results[1].Link, "")
+	assertEqual(t, get(t, router, "/m3u8/old/"+fmt.Sprint(start.Unix())+"/index.m3u8", nil), http.StatusNotFound)
+	var channelPage TStreamPage[sqlvods.GetLatestStreamsFromStreamerLoginRow]
+	assertEqual(t, get(t, router, "/channels/@a", &channelPage), http.StatusOK)
+	assertEqual(t, len(channelPage.Results), 2)
+	assertEqual(t, channelPage.Results[1].Metadata.StreamID, "old")
+	assertEqual(t, channelPage.Results[1].Link, "")
+}
+
+func TestListHandlerRejectsBadRequests(t *testing.T) {
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	router := makeTestRouter(makeTestStore(t,
+		testStream{streamId: "1", login: "a", title: "speedrun", startTime: start, views: 10, public: true},
+	))
+	viewsCursor := url.QueryEscape(pagination.ViewsCursor{MaxViews: 10}.Encode())
+	timeCursor := url.QueryEscape(pagination.TimeCursor{StartTime: start}.Encode())
+	for _, target := range []string{
+		"/streams?cursor=garbage",
+		"/all/public?cursor=garbage",
+		// Cursors of one kind of list can't page another.
+		"/streams?cursor=" + timeCursor,
+		"/channels/@a?cursor=" + viewsCursor,
+		"/streams?limit=0",
+		"/streams?limit=abc",
+		"/streams?since=yesterday",
+		"/streams?since=200&until=100",
+		"/streams?window=7d&since=100",
+		"/channels/@a?window=1y",
+		"/streams?public=maybe",
+		"/streams?min_duration=long",
+		"/streams?min_views=-",
+		// Path parameters start with @.
+		"/channels/a",
+		"/language/en/all/public",
+		"/titles/search?q=",
+		"/titles/search?q=speedrun&cursor=" + viewsCursor,
+	} {
+		assertEqual(t, get(t, router, target, nil), http.StatusBadRequest)
+	}
+}
+
+func TestListHandlerWindow(t *testing.T) {
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	router := makeTestRouter(makeTestStore(t,
+		testStream{streamId: "old", login: "a", language: "en", startTime: start, views: 1000, public: true},
+		testStream{streamId: "yesterday", login: "a", language: "de", startTime: start.Add(24 * time.Hour), views: 100, public: true},
+		testStream{streamId: "today", login: "a", language: "en", startTime: start.Add(48 * time.Hour), views: 10, public: true},
+	))
+	since := fmt.Sprint(start.Add(24 * time.Hour).Unix())
+	until := fmt.Sprint(start.Add(48 * time.Hour).Unix())
+	var page TStreamPage[vodstore.SearchStreamsRow]
+	assertEqual(t, get(t, router, "/all/public?since="+since, &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "yesterday today ")
+	page = TStreamPage[vodstore.SearchStreamsRow]{}
+	assertEqual(t, get(t, router, "/streams?since="+since+"&until="+until, &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "yesterday ")
+	page = TStreamPage[vodstore.SearchStreamsRow]{}
+	assertEqual(t, get(t, router, "/language/@en/all/public?until="+until, &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "old ")
+	page = TStreamPage[vodstore.SearchStreamsRow]{}
+	assertEqual(t, get(t, router, "/streams?language=en&min_views=20", &page), http.StatusOK)
+	assertEqual(t, getStreamIds(page), "old ")
+
+	// The channel list pages by start time, newest first, within the window.
+	var channelPage TStreamPage[sqlvods.GetLatestStreamsFromStreamerLoginRow]
+	assertEqual(t, get(t, router, "/channels/@a?limit=1&until="+until, &channelPage), http.StatusOK)
+	assertEqual(t, len(channelPage.Results), 1)
+	assertEqual(t, channelPage.Results[0].Metadata.StreamID, "yesterday")
+	next := channelPage.Next
+	assertEqual(t, next != "", true)
+	channelPage = TStreamPage[sqlvods.GetLatestStreamsFromStreamerLoginRow]{}
+	assertEqual(t, get(t, router, "/channels/@a?limit=1&until="+until+"&cursor="+url.QueryEscape(next), &channelPage), http.StatusOK)
+	assertEqual(t, len(channelPage.Results), 1)
+	assertEqual(t, channelPage.Results[0].Metadata.StreamID, "old")
+	assertEqual(t, channelPage.Next, "")
+}
+
+func TestSearchRoutes(t *testing.T) {
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	router := makeTestRouter(makeTestStore(t,
+		testStream{streamId: "1", login: "runner", title: "speedrun any%", startTime: start, views: 10, public: true},
+		testStream{streamId: "2", login: "titles", title: "cooking", startTime: start, views: 20, public: true},
+	))
+	var titlePage TStreamPage[sqlvods.GetMatchingTitlesRow]
+	assertEqual(t, get(t, router, "/titles/search?q=speedrun", &titlePage), http.StatusOK)
+	assertEqual(t, len(titlePage.Results), 1)
+	assertEqual(t, titlePage.Results[0].Metadata.StreamID, "1")
+	assertEqual(t, titlePage.Next, "")
+	titlePage = TStreamPage[sqlvods.GetMatchingTitlesRow]{}
+	assertEqual(t, get(t, router, "/titles/search?q=speedrun&window=today", &titlePage), http.StatusOK)
+	assertEqual(t, len(titlePage.Results), 0)
+	// /search/:streamer only searches streamers, whatever the query string.
+	var streamers []sqlvods.GetMatchingStreamersRow
+	assertEqual(t, get(t, router, "/search/titles?q=speedrun", &streamers), http.StatusOK)
+	assertEqual(t, len(streamers), 1)
+	assertEqual(t, streamers[0].StreamerLoginAtStart, "titles")
+	streamers = nil
+	assertEqual(t, get(t, router, "/search/runner?q=speedrun", &streamers), http.StatusOK)
+	assertEqual(t, len(streamers), 1)
+	assertEqual(t, streamers[0].StreamerLoginAtStart, "runner")
+	assertEqual(t, get(t, router, "/search/not-a-login", nil), http.StatusBadRequest)
+}
+
+func TestAudioHandler(t *testing.T) {
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	router := makeTestRouter(makeTestStore(t,
+		testStream{streamId: "1", login: "a", startTime: start, renditions: []string{renditions.Source, "360p30", renditions.AudioOnly}},
+		testStream{streamId: "2", login: "b", startTime: start, renditions: []string{renditions.Source, "360p30"}},
+		testStream{streamId: "3", login: "c", startTime: start},
+	))
+	unix := fmt.Sprint(start.Unix())
+	assertEqual(t, get(t, router, "/m3u8/1/"+unix+"/audio.m3u8", nil), http.StatusOK)
+	// Video renditions are not served in place of a missing audio_only rendition.
+	assertEqual(t, get(t, router, "/m3u8/2/"+unix+"/audio.m3u8", nil), http.StatusNotFound)
+	assertEqual(t, get(t, router, "/m3u8/3/"+unix+"/audio.m3u8", nil), http.StatusNotFound)
+}
@@ -3,28 +3,60 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/auoie/twitch-vods/logging"
 	"github.com/auoie/twitch-vods/scraper"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	err := logging.SetDefaultFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 	databaseUrl, ok := os.LookupEnv("DATABASE_URL")
 	if !ok {
-		log.Fatal("DATABASE_URL is missing for db connection string")
+		logging.Fatal("DATABASE_URL is missing for db connection string")
 	}
 	clientId, ok := os.LookupEnv("CLIENT_ID")
 	if !ok {
-		log.Fatal("CLIENT_ID is missing for twitch helix API")
+		logging.Fatal("CLIENT_ID is missing for twitch helix API")
 	}
 	clientSecret, ok := os.LookupEnv("CLIENT_SECRET")
 	if !ok {
-		log.Fatal("CLIENT_SECRET is missing for twitch helix API")
+		logging.Fatal("CLIENT_SECRET is missing for twitch helix API")
+	}
+	checkpointPath, ok := os.LookupEnv("CHECKPOINT_PATH")
+	if !ok {
+		slog.Warn("CHECKPOINT_PATH is missing, so queues will not survive restarts")
+	}
+	adminAddr, ok := os.LookupEnv("ADMIN_ADDR")
+	if !ok {
+		slog.Info("ADMIN_ADDR is missing, so the admin API is disabled")
+	}
+	metricsAddr, ok := os.LookupEnv("METRICS_ADDR")
+	if ok {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			slog.Info("serving metrics", "addr", metricsAddr)
+			slog.Error("metrics server failed", "error", http.ListenAndServe(metricsAddr, mux))
+		}()
+	} else {
+		slog.Info("METRICS_ADDR is missing, so metrics are not served")
 	}
-	log.Println("running scraper forever")
+	// docker stop sends SIGTERM and kills the container 10 seconds later, so DrainTimeout should be shorter than that.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	slog.Info("running scraper forever")
 	scraper.RunScraperForever(
-		context.Background(),
+		ctx,
 		24*time.Hour*7,
 		databaseUrl,
 		2.0,
@@ -41,9 +73,27 @@ func main() {
 			MinViewerCountToObserve:    5,
 			MinViewerCountToRecord:     10,
 			NumStreamsPerRequest:       100,
-			OldVodsDelete:              time.Hour * 24 * 14,
+			OldVodsDelete:              time.Hour * 24 * 30,
+			OldRecordingsDelete:        time.Hour * 24 * 14,
+			ViewerSampleInterval:       5 * time.Minute,
+			RetentionInterval:          10 * time.Minute,
+			RetentionBatchSize:         1000,
+			VerifyInterval:             10 * time.Minute,
+			VerifyBatchSize:            100,
+			ReverifyAfter:              24 * time.Hour,
+			VerifyGoneAfterChecks:      3,
+			VerifyGoneCheckAfter:       6 * time.Hour,
+			ReverifyGoneAfter:          7 * 24 * time.Hour,
 			ClientId:                   clientId,
 			ClientSecret:               clientSecret,
+			CheckpointPath:             checkpointPath,
+			CheckpointInterval:         time.Minute,
+			DrainTimeout:               8 * time.Second,
+			HlsRetryBaseDelay:          10 * time.Minute,
+			HlsRetryMaxAge:             48 * time.Hour,
+			HlsRetrySweepInterval:      30 * time.Minute,
+			HlsRetrySweepLimit:         1000,
+			AdminAddr:                  adminAddr,
 		},
 	)
 }
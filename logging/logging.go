@@ -0,0 +1,54 @@
+// Package logging configures the log/slog default logger for the binaries in ./cmd.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Makes a logger that writes records at level or above to w.
+// level is one of debug, info, warn and error. format is json or text.
+func NewLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	err := slogLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: slogLevel}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// Makes the default logger from LOG_LEVEL (default info) and LOG_FORMAT (default json).
+// It writes to stderr. The log package is redirected to it, so libraries that use log are captured too.
+func SetDefaultFromEnv() error {
+	level, ok := os.LookupEnv("LOG_LEVEL")
+	if !ok {
+		level = "info"
+	}
+	format, ok := os.LookupEnv("LOG_FORMAT")
+	if !ok {
+		format = "json"
+	}
+	logger, err := NewLogger(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// Logs msg at the error level and exits, like log.Fatal.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
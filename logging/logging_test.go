@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerJson(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := NewLogger(buf, "warn", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("filtered", "stream_id", "s1")
+	logger.Warn("kept", "stream_id", "s2")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %v lines want 1: %q", len(lines), buf.String())
+	}
+	record := map[string]any{}
+	err = json.Unmarshal([]byte(lines[0]), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record["msg"] != "kept" || record["stream_id"] != "s2" || record["level"] != slog.LevelWarn.String() {
+		t.Fatalf("unexpected record %v", record)
+	}
+}
+
+func TestNewLoggerRejectsBadConfig(t *testing.T) {
+	for _, config := range [][2]string{{"loud", "json"}, {"info", "xml"}} {
+		_, err := NewLogger(&bytes.Buffer{}, config[0], config[1])
+		if err == nil {
+			t.Fatalf("expected level %q and format %q to be rejected", config[0], config[1])
+		}
+	}
+	_, err := NewLogger(&bytes.Buffer{}, "DEBUG", "Text")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
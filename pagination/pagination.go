@@ -0,0 +1,126 @@
+// Package pagination encodes the opaque cursors and parses the page sizes of the list endpoints.
+// The lists use keyset pagination (see "Pagination in Postgres" in NOTES.md): a cursor holds the sort key of
+// the last row of a page, and the next page starts after it.
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("invalid limit")
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 100
+)
+
+// The sort key of lists ordered by (max_views, id) DESC.
+type ViewsCursor struct {
+	MaxViews int64
+	ID       uuid.UUID
+}
+
+// Every row is after it in the sort order, so it is the cursor of the first page.
+var FirstViewsCursor = ViewsCursor{MaxViews: math.MaxInt64, ID: uuid.Max}
+
+// The sort key of lists ordered by start_time DESC.
+type TimeCursor struct {
+	StartTime time.Time
+}
+
+// Every row is after it in the sort order, so it is the cursor of the first page.
+var FirstTimeCursor = TimeCursor{StartTime: time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)}
+
+// The version prefixes let the encoding change without old cursors being misread.
+const (
+	viewsPrefix = "v1:"
+	timePrefix  = "t1:"
+)
+
+func encode(payload string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(payload))
+}
+
+func decode(cursor string, prefix string) (string, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	rest, ok := strings.CutPrefix(string(payload), prefix)
+	if !ok {
+		return "", ErrInvalidCursor
+	}
+	return rest, nil
+}
+
+func (cursor ViewsCursor) Encode() string {
+	return encode(fmt.Sprint(viewsPrefix, cursor.MaxViews, ":", cursor.ID))
+}
+
+// Returns FirstViewsCursor if cursor is empty.
+func DecodeViews(cursor string) (ViewsCursor, error) {
+	if cursor == "" {
+		return FirstViewsCursor, nil
+	}
+	rest, err := decode(cursor, viewsPrefix)
+	if err != nil {
+		return ViewsCursor{}, err
+	}
+	maxViewsString, idString, ok := strings.Cut(rest, ":")
+	if !ok {
+		return ViewsCursor{}, ErrInvalidCursor
+	}
+	maxViews, err := strconv.ParseInt(maxViewsString, 10, 64)
+	if err != nil {
+		return ViewsCursor{}, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		return ViewsCursor{}, ErrInvalidCursor
+	}
+	return ViewsCursor{MaxViews: maxViews, ID: id}, nil
+}
+
+// The start times are stored with millisecond precision, so that is all the cursor keeps.
+func (cursor TimeCursor) Encode() string {
+	return encode(fmt.Sprint(timePrefix, cursor.StartTime.UnixMilli()))
+}
+
+// Returns FirstTimeCursor if cursor is empty.
+func DecodeTime(cursor string) (TimeCursor, error) {
+	if cursor == "" {
+		return FirstTimeCursor, nil
+	}
+	rest, err := decode(cursor, timePrefix)
+	if err != nil {
+		return TimeCursor{}, err
+	}
+	unixMilli, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		return TimeCursor{}, ErrInvalidCursor
+	}
+	return TimeCursor{StartTime: time.UnixMilli(unixMilli).UTC()}, nil
+}
+
+// Parses a page size between 1 and MaxLimit. Returns DefaultLimit if limit is empty.
+func ParseLimit(limit string) (int32, error) {
+	if limit == "" {
+		return DefaultLimit, nil
+	}
+	value, err := strconv.ParseInt(limit, 10, 32)
+	if err != nil || value < 1 || value > MaxLimit {
+		return 0, ErrInvalidLimit
+	}
+	return int32(value), nil
+}
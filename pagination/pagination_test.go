@@ -0,0 +1,62 @@
+package pagination
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func assertEqual[T comparable](t testing.TB, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+func TestViewsCursor(t *testing.T) {
+	cursor := ViewsCursor{MaxViews: 1234, ID: uuid.MustParse("8f0e9f4e-2c4d-4bb5-9a57-5d2e1b7f3c10")}
+	decoded, err := DecodeViews(cursor.Encode())
+	assertEqual(t, err, nil)
+	assertEqual(t, decoded, cursor)
+	decoded, err = DecodeViews("")
+	assertEqual(t, err, nil)
+	assertEqual(t, decoded, FirstViewsCursor)
+}
+
+func TestTimeCursor(t *testing.T) {
+	cursor := TimeCursor{StartTime: time.Date(2023, 5, 21, 8, 0, 0, 123000000, time.UTC)}
+	decoded, err := DecodeTime(cursor.Encode())
+	assertEqual(t, err, nil)
+	assertEqual(t, decoded, cursor)
+	decoded, err = DecodeTime("")
+	assertEqual(t, err, nil)
+	assertEqual(t, decoded, FirstTimeCursor)
+}
+
+func TestDecodeInvalidCursors(t *testing.T) {
+	timeCursor := TimeCursor{StartTime: time.Unix(1684656000, 0)}.Encode()
+	_, err := DecodeViews(timeCursor)
+	assertEqual(t, err, ErrInvalidCursor)
+	_, err = DecodeTime(ViewsCursor{MaxViews: 1, ID: uuid.Max}.Encode())
+	assertEqual(t, err, ErrInvalidCursor)
+	for _, cursor := range []string{"not base64!", encode("v1:abc:" + uuid.Max.String()), encode("v1:10"), encode("v1:10:not-a-uuid"), encode("t1:soon")} {
+		_, err = DecodeViews(cursor)
+		assertEqual(t, err, ErrInvalidCursor)
+		_, err = DecodeTime(cursor)
+		assertEqual(t, err, ErrInvalidCursor)
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("")
+	assertEqual(t, err, nil)
+	assertEqual(t, limit, int32(DefaultLimit))
+	limit, err = ParseLimit("20")
+	assertEqual(t, err, nil)
+	assertEqual(t, limit, int32(20))
+	for _, invalid := range []string{"0", "-1", "101", "ten", "99999999999"} {
+		_, err = ParseLimit(invalid)
+		assertEqual(t, err, ErrInvalidLimit)
+	}
+}
@@ -0,0 +1,99 @@
+// Package renditions lists the HLS renditions Twitch keeps next to the source playlist of a VOD
+// and builds the master and media playlists served for them.
+package renditions
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type Rendition struct {
+	// directory of the rendition next to the source, e.g. .../chunked/index-dvr.m3u8 and .../360p30/index-dvr.m3u8
+	Name string
+	// Nominal bits per second. The media playlists don't say, and players only use it to pick a variant.
+	Bandwidth int
+	// empty if unknown
+	Resolution string
+	// empty if unknown
+	Codecs string
+}
+
+const (
+	Source    = "chunked"
+	AudioOnly = "audio_only"
+)
+
+// Ordered from highest to lowest bandwidth, which is the order of the variants in master playlists.
+var All = []Rendition{
+	{Name: Source, Bandwidth: 6000000},
+	{Name: "720p60", Bandwidth: 3400000, Resolution: "1280x720"},
+	{Name: "480p30", Bandwidth: 1400000, Resolution: "852x480"},
+	{Name: "360p30", Bandwidth: 630000, Resolution: "640x360"},
+	{Name: "160p30", Bandwidth: 230000, Resolution: "284x160"},
+	{Name: AudioOnly, Bandwidth: 160000, Codecs: "mp4a.40.2"},
+}
+
+func Get(name string) (Rendition, bool) {
+	for _, rendition := range All {
+		if rendition.Name == name {
+			return rendition, true
+		}
+	}
+	return Rendition{}, false
+}
+
+// Returns the URL of the rendition's playlist given the URL of the source playlist.
+func Url(sourceUrl string, name string) string {
+	return strings.Replace(sourceUrl, "/"+Source+"/", "/"+name+"/", 1)
+}
+
+// Returns audio_only if it was found. The other renditions are video,
+// so there is nothing to listen to without downloading video if it is missing.
+func ForAudio(found []string) (string, bool) {
+	if slices.Contains(found, AudioOnly) {
+		return AudioOnly, true
+	}
+	return "", false
+}
+
+// Twitch cuts every rendition into the same segments as the source,
+// so the media playlist of a rendition is the source playlist with the segment URIs moved to the rendition's directory.
+// The source playlist must have explicit segment URIs.
+func MediaPlaylist(source []byte, name string) []byte {
+	if name == Source {
+		return source
+	}
+	lines := strings.Split(string(source), "\n")
+	for i, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines[i] = Url(line, name)
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Returns a master playlist with a variant for each known rendition in names.
+// uri returns the URI of the media playlist of a rendition.
+func MasterPlaylist(names []string, uri func(name string) string) []byte {
+	found := map[string]bool{}
+	for _, name := range names {
+		found[name] = true
+	}
+	builder := strings.Builder{}
+	builder.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, rendition := range All {
+		if !found[rendition.Name] {
+			continue
+		}
+		fmt.Fprint(&builder, "#EXT-X-STREAM-INF:BANDWIDTH=", rendition.Bandwidth)
+		if rendition.Resolution != "" {
+			fmt.Fprint(&builder, ",RESOLUTION=", rendition.Resolution)
+		}
+		if rendition.Codecs != "" {
+			fmt.Fprintf(&builder, ",CODECS=\"%s\"", rendition.Codecs)
+		}
+		fmt.Fprint(&builder, "\n", uri(rendition.Name), "\n")
+	}
+	return []byte(builder.String())
+}
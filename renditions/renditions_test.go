@@ -0,0 +1,76 @@
+package renditions
+
+import (
+	"testing"
+)
+
+func assertEqual[T comparable](t testing.TB, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+func TestUrl(t *testing.T) {
+	source := "https://d1m7jfoe9zdc1j.cloudfront.net/abc_streamer_1_2/chunked/index-dvr.m3u8"
+	assertEqual(t, Url(source, "360p30"), "https://d1m7jfoe9zdc1j.cloudfront.net/abc_streamer_1_2/360p30/index-dvr.m3u8")
+	assertEqual(t, Url(source, Source), source)
+}
+
+func TestGet(t *testing.T) {
+	rendition, ok := Get(AudioOnly)
+	assertEqual(t, ok, true)
+	assertEqual(t, rendition.Codecs, "mp4a.40.2")
+	_, ok = Get("1080p60")
+	assertEqual(t, ok, false)
+}
+
+func TestForAudio(t *testing.T) {
+	rendition, ok := ForAudio([]string{Source, "360p30", AudioOnly})
+	assertEqual(t, rendition, AudioOnly)
+	assertEqual(t, ok, true)
+	// Video renditions are not served as audio.
+	_, ok = ForAudio([]string{Source, "720p60", "360p30"})
+	assertEqual(t, ok, false)
+	_, ok = ForAudio([]string{Source})
+	assertEqual(t, ok, false)
+	_, ok = ForAudio(nil)
+	assertEqual(t, ok, false)
+}
+
+func TestMediaPlaylist(t *testing.T) {
+	source := `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10.000,
+https://example.com/abc/chunked/0.ts
+#EXTINF:10.000,
+https://example.com/abc/chunked/1-muted.ts
+#EXT-X-ENDLIST
+`
+	want := `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10.000,
+https://example.com/abc/160p30/0.ts
+#EXTINF:10.000,
+https://example.com/abc/160p30/1-muted.ts
+#EXT-X-ENDLIST
+`
+	assertEqual(t, string(MediaPlaylist([]byte(source), "160p30")), want)
+	assertEqual(t, string(MediaPlaylist([]byte(source), Source)), source)
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	got := MasterPlaylist([]string{AudioOnly, "360p30", Source, "unknown"}, func(name string) string {
+		return "renditions/" + name + "/index.m3u8"
+	})
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-STREAM-INF:BANDWIDTH=6000000
+renditions/chunked/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=630000,RESOLUTION=640x360
+renditions/360p30/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=160000,CODECS="mp4a.40.2"
+renditions/audio_only/index.m3u8
+`
+	assertEqual(t, string(got), want)
+}
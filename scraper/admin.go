@@ -0,0 +1,238 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/julienschmidt/httprouter"
+	"github.com/monitor1379/yagods/maps/treemap"
+)
+
+type queueSnapshot struct {
+	Size int
+	// The VODs at the front of the queue, i.e. the next ones to be evicted, handed out or retried.
+	Top []LiveVod
+}
+
+type adminQueuesResponse struct {
+	Paused bool
+	Live   queueSnapshot
+	Wait   queueSnapshot
+	Old    queueSnapshot
+	// nil if VODs whose .m3u8 was not found are not retried
+	Retry *queueSnapshot
+}
+
+// The queues are owned by their goroutines, so the admin server asks the owners for snapshots.
+// Each owner fills in its part of response and closes done.
+type adminQueuesRequest struct {
+	limit    int
+	response *adminQueuesResponse
+	done     chan struct{}
+}
+
+func getQueueSnapshot[K comparable](tree *treemap.Map[K, *LiveVod], limit int) queueSnapshot {
+	snapshot := queueSnapshot{Size: tree.Size(), Top: []LiveVod{}}
+	iterator := tree.Iterator()
+	for len(snapshot.Top) < limit && iterator.Next() {
+		snapshot.Top = append(snapshot.Top, iterator.Value().clone())
+	}
+	return snapshot
+}
+
+// Channels to the goroutines that own the queues and to the HLS worker pool.
+// A nil channel means its owner isn't running, e.g. there is no retry queue if retries are disabled.
+type adminChannels struct {
+	// served by fetchTwitchHelixForever
+	liveAndWaitVodsCh chan *adminQueuesRequest
+	// served by processOldVodJobs
+	oldVodsCh chan *adminQueuesRequest
+	// served by processRetryVods
+	retryVodsCh chan *adminQueuesRequest
+	// Sending true pauses polling Twitch Helix and sending false resumes it.
+	pausedCh chan bool
+	// VODs sent here are fetched by the next free HLS worker.
+	oldVodJobsCh chan *LiveVod
+}
+
+func makeAdminChannels(retryVods bool) *adminChannels {
+	channels := &adminChannels{
+		liveAndWaitVodsCh: make(chan *adminQueuesRequest),
+		oldVodsCh:         make(chan *adminQueuesRequest),
+		pausedCh:          make(chan bool),
+	}
+	if retryVods {
+		channels.retryVodsCh = make(chan *adminQueuesRequest)
+	}
+	return channels
+}
+
+type adminServer struct {
+	// Requests to the queue owners and workers give up once ctx is done.
+	ctx                 context.Context
+	channels            *adminChannels
+	store               vodstore.Store
+	sqlRequestTimeLimit time.Duration
+}
+
+var errAdminUnavailable = errors.New("scraper stopped or request was canceled")
+
+// Sends value on ch unless the scraper stops or the admin request is canceled first.
+func adminSend[T any](ctx context.Context, r *http.Request, ch chan T, value T) error {
+	select {
+	case <-ctx.Done():
+		return errAdminUnavailable
+	case <-r.Context().Done():
+		return errAdminUnavailable
+	case ch <- value:
+		return nil
+	}
+}
+
+func (admin *adminServer) askQueueOwner(r *http.Request, ch chan *adminQueuesRequest, request *adminQueuesRequest) error {
+	request.done = make(chan struct{})
+	err := adminSend(admin.ctx, r, ch, request)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-admin.ctx.Done():
+		return errAdminUnavailable
+	case <-r.Context().Done():
+		return errAdminUnavailable
+	case <-request.done:
+		return nil
+	}
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(bytes)))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+// GET /queues?limit=10 returns the sizes of the queues and the first limit VODs in each.
+func (admin *adminServer) handleQueues(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	limit := 10
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsedLimit, err := strconv.Atoi(limitParam)
+		if err != nil || parsedLimit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = parsedLimit
+	}
+	response := &adminQueuesResponse{}
+	owners := []chan *adminQueuesRequest{admin.channels.liveAndWaitVodsCh, admin.channels.oldVodsCh}
+	if admin.channels.retryVodsCh != nil {
+		owners = append(owners, admin.channels.retryVodsCh)
+	}
+	for _, owner := range owners {
+		err := admin.askQueueOwner(r, owner, &adminQueuesRequest{limit: limit, response: response})
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	writeJson(w, response)
+}
+
+// POST /refetch/:streamid/:unix hands the VOD to the next free HLS worker, even if its .m3u8 was already found.
+// If the .m3u8 was found before and the refetch fails, the stored recording is kept.
+// It responds once a worker has taken the job, not when the fetch is finished.
+func (admin *adminServer) handleRefetch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	streamId := p.ByName("streamid")
+	unix, err := strconv.ParseInt(p.ByName("unix"), 10, 64)
+	if streamId == "" || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	requestCtx, requestCancel := context.WithTimeout(r.Context(), admin.sqlRequestTimeLimit)
+	streams, err := admin.store.GetStream(requestCtx, sqlvods.GetStreamParams{
+		StreamID:  streamId,
+		StartTime: time.Unix(unix, 0).UTC(),
+	})
+	requestCancel()
+	if err != nil {
+		slog.Error("admin failed to get stream to refetch", "stream_id", streamId, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(streams) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	stream := streams[0]
+	vod := &LiveVod{
+		StreamerId:           stream.StreamerID,
+		StreamId:             stream.StreamID,
+		StartTimeUnix:        stream.StartTime.UTC().Unix(),
+		StreamerLoginAtStart: stream.StreamerLoginAtStart,
+		GameIdAtStart:        stream.GameIDAtStart,
+		MaxViews:             int(stream.MaxViews),
+		LastUpdatedUnix:      stream.LastUpdatedAt.UTC().Unix(),
+		LastInteractionUnix:  stream.LastUpdatedAt.UTC().Unix(),
+		HlsFetchAttempts:     int(stream.HlsFetchAttempts),
+		LastHlsFetchUnix:     stream.RecordingFetchedAt.Time.UTC().Unix(),
+	}
+	err = adminSend(admin.ctx, r, admin.channels.oldVodJobsCh, vod)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	slog.Info("admin forced refetch", "vod", vod)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (admin *adminServer) makePauseHandler(paused bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		err := adminSend(admin.ctx, r, admin.channels.pausedCh, paused)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		slog.Info("admin set polling paused", "paused", paused)
+	}
+}
+
+func (admin *adminServer) router() *httprouter.Router {
+	router := httprouter.New()
+	router.GET("/queues", admin.handleQueues)
+	router.POST("/refetch/:streamid/:unix", admin.handleRefetch)
+	router.POST("/pause", admin.makePauseHandler(true))
+	router.POST("/resume", admin.makePauseHandler(false))
+	return router
+}
+
+// Serves the admin API on addr until ctx is done.
+// The admin API is optional, so if addr can't be listened on, the error is logged and the scraper keeps running.
+func serveAdmin(admin *adminServer, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("failed to listen on admin address", "addr", addr, "error", err)
+		return
+	}
+	server := &http.Server{Handler: admin.router()}
+	go func() {
+		<-admin.ctx.Done()
+		server.Close()
+	}()
+	slog.Info("serving admin API", "addr", listener.Addr().String())
+	err = server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("admin server failed", "error", err)
+	}
+}
@@ -0,0 +1,168 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/nicklaw5/helix"
+)
+
+type adminHarness struct {
+	server       *httptest.Server
+	channels     *adminChannels
+	store        *vodstore.Memory
+	oldVodJobsCh chan *LiveVod
+	cancel       context.CancelFunc
+}
+
+// Serves the admin API with no queue owners. Tests start the owners they need with the harness's channels.
+func startAdmin(t *testing.T) *adminHarness {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	harness := &adminHarness{
+		channels:     makeAdminChannels(false),
+		store:        vodstore.NewMemory(),
+		oldVodJobsCh: make(chan *LiveVod),
+		cancel:       cancel,
+	}
+	harness.channels.oldVodJobsCh = harness.oldVodJobsCh
+	admin := &adminServer{
+		ctx:                 ctx,
+		channels:            harness.channels,
+		store:               harness.store,
+		sqlRequestTimeLimit: time.Second,
+	}
+	harness.server = httptest.NewServer(admin.router())
+	t.Cleanup(harness.server.Close)
+	t.Cleanup(cancel)
+	return harness
+}
+
+func (harness *adminHarness) post(t *testing.T, path string) int {
+	t.Helper()
+	resp, err := http.Post(harness.server.URL+path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (harness *adminHarness) getQueues(t *testing.T, query string) (int, *adminQueuesResponse) {
+	t.Helper()
+	resp, err := http.Get(harness.server.URL + "/queues" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	response := &adminQueuesResponse{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, response
+}
+
+func startOwners(t *testing.T, harness *adminHarness, source *fakeStreamSource, oldVodQueue *oldVodsPriorityQueue) *fetchHarness {
+	t.Helper()
+	fetcher := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.adminQueuesCh = harness.channels.liveAndWaitVodsCh
+		params.pausedCh = harness.channels.pausedCh
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	go func() {
+		processOldVodJobs(processOldVodJobsParams{
+			ctx:                 ctx,
+			initialOldVodQueue:  oldVodQueue,
+			oldVodsCh:           make(chan []*LiveVod),
+			oldVodJobsCh:        make(chan *LiveVod),
+			maxOldVodsQueueSize: 10,
+			adminQueuesCh:       harness.channels.oldVodsCh,
+		})
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-exited
+	})
+	return fetcher
+}
+
+func TestAdminQueues(t *testing.T) {
+	harness := startAdmin(t)
+	oldVodQueue := CreateNewOldVodQueue()
+	oldVodQueue.Put(makeOldVod("o1", 10, 5))
+	oldVodQueue.Put(makeOldVod("o2", 10, 50))
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+	)
+	fetcher := startOwners(t, harness, source, oldVodQueue)
+	eventually(t, func() bool { return len(fetcher.source.requestedCursors()) >= 2 })
+
+	status, response := harness.getQueues(t, "?limit=1")
+	assertEqual(t, status, http.StatusOK)
+	assertEqual(t, response.Paused, false)
+	assertEqual(t, response.Live.Size, 1)
+	assertEqual(t, response.Live.Top[0].StreamId, "s1")
+	assertEqual(t, response.Wait.Size, 0)
+	assertEqual(t, len(response.Wait.Top), 0)
+	assertEqual(t, response.Old.Size, 2)
+	assertEqual(t, len(response.Old.Top), 1)
+	assertEqual(t, response.Old.Top[0].StreamId, "o1")
+	assertEqual(t, response.Retry == nil, true)
+
+	status, _ = harness.getQueues(t, "?limit=-1")
+	assertEqual(t, status, http.StatusBadRequest)
+}
+
+func TestAdminPausesAndResumesPolling(t *testing.T) {
+	harness := startAdmin(t)
+	fetcher := startOwners(t, harness, newFakeStreamSource(), CreateNewOldVodQueue())
+	eventually(t, func() bool { return len(fetcher.source.requestedCursors()) >= 1 })
+
+	assertEqual(t, harness.post(t, "/pause"), http.StatusOK)
+	_, response := harness.getQueues(t, "")
+	assertEqual(t, response.Paused, true)
+	numRequests := len(fetcher.source.requestedCursors())
+	time.Sleep(50 * time.Millisecond)
+	assertEqual(t, len(fetcher.source.requestedCursors()), numRequests)
+
+	assertEqual(t, harness.post(t, "/resume"), http.StatusOK)
+	eventually(t, func() bool { return len(fetcher.source.requestedCursors()) > numRequests })
+}
+
+func TestAdminRefetch(t *testing.T) {
+	harness := startAdmin(t)
+	vod := makeFinishedTestVod()
+	stream := makeTestStream(vod.StreamId, vod.StreamerId, vod.MaxViews, testStartTime)
+	stream.UserLogin = vod.StreamerLoginAtStart
+	err := harness.store.UpsertManyStreams(context.Background(), twitchGqlResponseUpsertStreamsParams([]*helix.Stream{&stream}, testStartTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := make(chan *LiveVod, 1)
+	go func() {
+		jobs <- <-harness.oldVodJobsCh
+	}()
+	assertEqual(t, harness.post(t, "/refetch/"+vod.StreamId+"/1668123456"), http.StatusNotFound)
+	assertEqual(t, harness.post(t, "/refetch/"+vod.StreamId+"/notunix"), http.StatusBadRequest)
+	assertEqual(t, harness.post(t, "/refetch/"+vod.StreamId+"/"+strconv.FormatInt(vod.StartTimeUnix, 10)), http.StatusAccepted)
+	job := <-jobs
+	assertEqual(t, job.StreamId, vod.StreamId)
+	assertEqual(t, job.StreamerLoginAtStart, vod.StreamerLoginAtStart)
+	assertEqual(t, job.StartTimeUnix, vod.StartTimeUnix)
+	assertEqual(t, job.MaxViews, vod.MaxViews)
+
+	harness.cancel()
+	assertEqual(t, harness.post(t, "/refetch/"+vod.StreamId+"/"+strconv.FormatInt(vod.StartTimeUnix, 10)), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,163 @@
+package scraper
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// queueCheckpoint is the in-memory queue state that survives a scraper restart.
+// The VODs are stored by value with their LastUpdatedUnix and LastInteractionUnix, so the queues are restored exactly.
+type queueCheckpoint struct {
+	CreatedAtUnix int64
+	LiveVods      []LiveVod
+	WaitVods      []LiveVod
+	OldVods       []LiveVod
+	RetryVods     []LiveVod
+}
+
+// The queues are owned by different goroutines.
+// Each owner saves a copy of its queues, and every save rewrites the file with the latest copy of all of them.
+type queueCheckpointer struct {
+	path       string
+	interval   time.Duration
+	mu         sync.Mutex
+	checkpoint queueCheckpoint
+}
+
+func newQueueCheckpointer(path string, interval time.Duration, liveVodQueue *liveVodsPriorityQueue, waitVodQueue *waitVodsPriorityQueue, oldVodQueue *oldVodsPriorityQueue, retryVodQueue *retryVodsPriorityQueue) *queueCheckpointer {
+	return &queueCheckpointer{
+		path:     path,
+		interval: interval,
+		checkpoint: queueCheckpoint{
+			LiveVods:  copyVods(liveVodQueue.lastUpdatedToVod.Values()),
+			WaitVods:  copyVods(waitVodQueue.lastInteractionToVod.Values()),
+			OldVods:   copyVods(oldVodQueue.tree.Values()),
+			RetryVods: copyVods(retryVodQueue.nextAttemptToVod.Values()),
+		},
+	}
+}
+
+func copyVods(vods []*LiveVod) []LiveVod {
+	result := make([]LiveVod, 0, len(vods))
+	for _, vod := range vods {
+		result = append(result, vod.clone())
+	}
+	return result
+}
+
+// Called by the goroutine that owns the live and wait queues.
+func (checkpointer *queueCheckpointer) saveLiveAndWaitVods(liveVodQueue *liveVodsPriorityQueue, waitVodQueue *waitVodsPriorityQueue) {
+	liveVods := copyVods(liveVodQueue.lastUpdatedToVod.Values())
+	waitVods := copyVods(waitVodQueue.lastInteractionToVod.Values())
+	checkpointer.mu.Lock()
+	defer checkpointer.mu.Unlock()
+	checkpointer.checkpoint.LiveVods = liveVods
+	checkpointer.checkpoint.WaitVods = waitVods
+	checkpointer.write()
+}
+
+// Called by the goroutine that owns the old queue.
+func (checkpointer *queueCheckpointer) saveOldVods(oldVodQueue *oldVodsPriorityQueue) {
+	oldVods := copyVods(oldVodQueue.tree.Values())
+	checkpointer.mu.Lock()
+	defer checkpointer.mu.Unlock()
+	checkpointer.checkpoint.OldVods = oldVods
+	checkpointer.write()
+}
+
+// Called by the goroutine that owns the retry queue.
+func (checkpointer *queueCheckpointer) saveRetryVods(retryVodQueue *retryVodsPriorityQueue) {
+	retryVods := copyVods(retryVodQueue.nextAttemptToVod.Values())
+	checkpointer.mu.Lock()
+	defer checkpointer.mu.Unlock()
+	checkpointer.checkpoint.RetryVods = retryVods
+	checkpointer.write()
+}
+
+// Must hold checkpointer.mu.
+func (checkpointer *queueCheckpointer) write() {
+	checkpointer.checkpoint.CreatedAtUnix = time.Now().UTC().Unix()
+	err := writeQueueCheckpoint(checkpointer.path, &checkpointer.checkpoint)
+	if err != nil {
+		slog.Error("writing queue checkpoint failed", "path", checkpointer.path, "error", err)
+	}
+}
+
+// The checkpoint is written to a temporary file and renamed, so a crash mid-write never leaves a truncated checkpoint.
+func writeQueueCheckpoint(path string, checkpoint *queueCheckpoint) error {
+	bytes, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(bytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func readQueueCheckpoint(path string) (*queueCheckpoint, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &queueCheckpoint{}
+	err = json.Unmarshal(bytes, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (checkpoint *queueCheckpoint) liveVodQueue() *liveVodsPriorityQueue {
+	liveVodQueue := CreateNewLiveVodsPriorityQueue()
+	for i := range checkpoint.LiveVods {
+		vod := checkpoint.LiveVods[i]
+		liveVodQueue.UpsertLiveVod(&vod)
+	}
+	return liveVodQueue
+}
+
+func (checkpoint *queueCheckpoint) waitVodQueue() *waitVodsPriorityQueue {
+	waitVodQueue := CreateNewWaitVodsPriorityQueue()
+	for i := range checkpoint.WaitVods {
+		vod := checkpoint.WaitVods[i]
+		waitVodQueue.Put(&vod)
+	}
+	return waitVodQueue
+}
+
+func (checkpoint *queueCheckpoint) oldVodQueue() *oldVodsPriorityQueue {
+	oldVodQueue := CreateNewOldVodQueue()
+	for i := range checkpoint.OldVods {
+		vod := checkpoint.OldVods[i]
+		oldVodQueue.Put(&vod)
+	}
+	return oldVodQueue
+}
+
+// The next attempt times are recomputed from each VOD's HlsFetchAttempts and LastHlsFetchUnix.
+func (checkpoint *queueCheckpoint) retryVodQueue(retryBaseDelay time.Duration) *retryVodsPriorityQueue {
+	retryVodQueue := CreateNewRetryVodsPriorityQueue()
+	for i := range checkpoint.RetryVods {
+		vod := checkpoint.RetryVods[i]
+		retryVodQueue.Put(&vod, vod.getNextHlsFetchUnix(retryBaseDelay))
+	}
+	return retryVodQueue
+}
@@ -0,0 +1,146 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/nicklaw5/helix"
+)
+
+func vodsString(vods []*LiveVod) string {
+	values := []LiveVod{}
+	for _, vod := range vods {
+		values = append(values, *vod)
+	}
+	return fmt.Sprint(values)
+}
+
+func TestQueueCheckpointRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queues.json")
+	liveVodQueue := CreateNewLiveVodsPriorityQueue()
+	liveVodQueue.UpsertLiveVod(makeLiveVod("a", 100, 10, 200))
+	liveVodQueue.UpsertLiveVod(makeLiveVod("b", 100, 20, 300))
+	waitVodQueue := CreateNewWaitVodsPriorityQueue()
+	waitVod := makeWaitVod("w1", 50, 250)
+	waitVod.LastUpdatedUnix = 150
+	waitVodQueue.Put(waitVod)
+	oldVodQueue := CreateNewOldVodQueue()
+	oldVodQueue.Put(makeOldVod("o1", 10, 5))
+	oldVodQueue.Put(makeOldVod("o2", 10, 50))
+	retryVodQueue := CreateNewRetryVodsPriorityQueue()
+	retryVod := makeOldVod("r1", 10, 5)
+	retryVod.HlsFetchAttempts = 3
+	retryVod.LastHlsFetchUnix = 1000
+	retryVodQueue.Put(retryVod, retryVod.getNextHlsFetchUnix(time.Minute))
+
+	checkpointer := newQueueCheckpointer(path, time.Minute, CreateNewLiveVodsPriorityQueue(), CreateNewWaitVodsPriorityQueue(), CreateNewOldVodQueue(), CreateNewRetryVodsPriorityQueue())
+	checkpointer.saveLiveAndWaitVods(liveVodQueue, waitVodQueue)
+	checkpointer.saveOldVods(oldVodQueue)
+	checkpointer.saveRetryVods(retryVodQueue)
+
+	checkpoint, err := readQueueCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, vodsString(checkpoint.liveVodQueue().lastUpdatedToVod.Values()), vodsString(liveVodQueue.lastUpdatedToVod.Values()))
+	assertEqual(t, vodsString(checkpoint.waitVodQueue().lastInteractionToVod.Values()), vodsString(waitVodQueue.lastInteractionToVod.Values()))
+	assertEqual(t, vodsString(checkpoint.oldVodQueue().tree.Values()), vodsString(oldVodQueue.tree.Values()))
+	restoredRetryVod, nextAttemptUnix, err := checkpoint.retryVodQueue(time.Minute).GetEarliest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, *restoredRetryVod, *retryVod)
+	assertEqual(t, nextAttemptUnix, int64(1000+4*60))
+	checkLiveVodsInvariants(t, checkpoint.liveVodQueue())
+	checkWaitVodsInvariants(t, checkpoint.waitVodQueue())
+}
+
+func TestQueueCheckpointerKeepsOtherOwnersQueues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queues.json")
+	oldVodQueue := CreateNewOldVodQueue()
+	oldVodQueue.Put(makeOldVod("o1", 10, 5))
+	checkpointer := newQueueCheckpointer(path, time.Minute, CreateNewLiveVodsPriorityQueue(), CreateNewWaitVodsPriorityQueue(), oldVodQueue, CreateNewRetryVodsPriorityQueue())
+	liveVodQueue := CreateNewLiveVodsPriorityQueue()
+	liveVodQueue.UpsertLiveVod(makeLiveVod("a", 100, 10, 200))
+	checkpointer.saveLiveAndWaitVods(liveVodQueue, CreateNewWaitVodsPriorityQueue())
+	checkpoint, err := readQueueCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(checkpoint.LiveVods), 1)
+	assertEqual(t, len(checkpoint.OldVods), 1)
+	assertEqual(t, checkpoint.OldVods[0].StreamId, "o1")
+}
+
+func TestFetchTwitchHelixForeverWritesFinalCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queues.json")
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.checkpointer = newQueueCheckpointer(path, time.Hour, CreateNewLiveVodsPriorityQueue(), params.initialWaitVodQueue, CreateNewOldVodQueue(), CreateNewRetryVodsPriorityQueue())
+	})
+	eventually(t, func() bool { return len(source.requestedCursors()) >= 2 })
+	harness.stop()
+	checkpoint, err := readQueueCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(checkpoint.LiveVods), 1)
+	assertEqual(t, checkpoint.LiveVods[0].StreamId, "s1")
+	assertEqual(t, checkpoint.LiveVods[0].MaxViews, 100)
+}
+
+func TestGetInitialQueuesPrefersCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queues.json")
+	params := RunScraperParams{
+		LiveVodEvictionThreshold: time.Hour,
+		WaitVodEvictionThreshold: time.Hour,
+		CheckpointPath:           path,
+	}
+	store := vodstore.NewMemory()
+
+	queues, err := getInitialQueues(context.Background(), store, 2.0, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, queues.liveVodQueue.Size(), 0)
+	assertEqual(t, queues.waitVodQueue.Size(), 0)
+
+	checkpoint := &queueCheckpoint{
+		LiveVods: []LiveVod{*makeLiveVod("a", 100, 10, 200)},
+		WaitVods: []LiveVod{*makeWaitVod("w1", 50, 250)},
+		OldVods:  []LiveVod{*makeOldVod("o1", 10, 5)},
+	}
+	err = writeQueueCheckpoint(path, checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queues, err = getInitialQueues(context.Background(), store, 2.0, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, queues.liveVodQueue.Size(), 1)
+	assertEqual(t, queues.waitVodQueue.Size(), 1)
+	assertEqual(t, queues.oldVodQueue.Size(), 1)
+	waitVod, err := queues.waitVodQueue.GetStalestStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, waitVod.LastInteractionUnix, int64(250))
+
+	err = os.WriteFile(path, []byte("not json"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queues, err = getInitialQueues(context.Background(), store, 2.0, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, queues.liveVodQueue.Size(), 0)
+}
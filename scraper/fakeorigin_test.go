@@ -0,0 +1,151 @@
+package scraper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/auoie/goVods/vods"
+)
+
+type fakeOriginBehavior int
+
+const (
+	fakeOriginNotFound fakeOriginBehavior = iota
+	fakeOriginForbidden
+	fakeOriginServe
+	// Never respond. This is what the CloudFront hosts did during the 16 minute timeout incidents in NOTES.md.
+	fakeOriginStall
+)
+
+type fakeOriginRoute struct {
+	pathPrefix string
+	behavior   fakeOriginBehavior
+	body       string
+}
+
+// fakeOrigin is a local stand-in for a CloudFront VOD domain.
+// Playlists are registered for the exact paths that goVods derives from a VideoData,
+// so the tests exercise the same path building as production.
+type fakeOrigin struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	routes   []fakeOriginRoute
+	fallback fakeOriginBehavior
+	delay    time.Duration
+	requests []string
+	closed   chan struct{}
+}
+
+func newFakeOrigin(t *testing.T) *fakeOrigin {
+	t.Helper()
+	origin := &fakeOrigin{fallback: fakeOriginNotFound, closed: make(chan struct{})}
+	origin.server = httptest.NewServer(http.HandlerFunc(origin.handle))
+	t.Cleanup(func() {
+		close(origin.closed)
+		origin.server.Close()
+	})
+	return origin
+}
+
+// The domain in the format of vods.DOMAINS.
+func (origin *fakeOrigin) domain() string {
+	return origin.server.URL + "/"
+}
+
+func (origin *fakeOrigin) handle(w http.ResponseWriter, r *http.Request) {
+	origin.mu.Lock()
+	origin.requests = append(origin.requests, r.URL.Path)
+	behavior := origin.fallback
+	body := ""
+	for _, route := range origin.routes {
+		if strings.HasPrefix(r.URL.Path, route.pathPrefix) {
+			behavior = route.behavior
+			body = route.body
+			break
+		}
+	}
+	delay := origin.delay
+	origin.mu.Unlock()
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+	switch behavior {
+	case fakeOriginServe:
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Write([]byte(body))
+	case fakeOriginForbidden:
+		w.WriteHeader(http.StatusForbidden)
+	case fakeOriginStall:
+		select {
+		case <-r.Context().Done():
+		case <-origin.closed:
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Registers a behavior for the path of videoData on this origin.
+// toUnix picks between the unix time path and the non-unix path.
+func (origin *fakeOrigin) setVideo(videoData *vods.VideoData, toUnix bool, behavior fakeOriginBehavior, body string) {
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	for _, dwp := range videoData.GetDomainWithPathsList([]string{origin.domain()}, 1, toUnix) {
+		for _, path := range dwp.Paths {
+			origin.routes = append(origin.routes, fakeOriginRoute{pathPrefix: "/" + path + "/", behavior: behavior, body: body})
+		}
+	}
+}
+
+// Registers a behavior for the files under name in the directory of videoData, e.g. a rendition or the storyboards.
+// It takes precedence over setVideo.
+func (origin *fakeOrigin) setFile(videoData *vods.VideoData, toUnix bool, name string, behavior fakeOriginBehavior, body string) {
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	for _, dwp := range videoData.GetDomainWithPathsList([]string{origin.domain()}, 1, toUnix) {
+		for _, path := range dwp.Paths {
+			route := fakeOriginRoute{pathPrefix: "/" + path + "/" + name, behavior: behavior, body: body}
+			origin.routes = append([]fakeOriginRoute{route}, origin.routes...)
+		}
+	}
+}
+
+// Sets the behavior for every path that has not been registered.
+func (origin *fakeOrigin) setFallback(behavior fakeOriginBehavior) {
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	origin.fallback = behavior
+}
+
+// Every response is sent after this delay, like a slow origin.
+func (origin *fakeOrigin) setDelay(delay time.Duration) {
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	origin.delay = delay
+}
+
+func (origin *fakeOrigin) numRequests() int {
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	return len(origin.requests)
+}
+
+const fakeMediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:10.000,
+0.ts
+#EXTINF:10.000,
+1-unmuted.ts
+#EXTINF:4.500,
+2.ts
+#EXT-X-ENDLIST
+`
@@ -0,0 +1,136 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nicklaw5/helix"
+)
+
+// One scripted response to GetStreams.
+type fakeStreamsPage struct {
+	streams []helix.Stream
+	cursor  string
+	err     error
+}
+
+// fakeStreamSource replays recorded GetStreams pages in order.
+// Once the script runs out, it keeps returning an empty page, which makes the scraper reset its cursor.
+type fakeStreamSource struct {
+	mu             sync.Mutex
+	pages          []fakeStreamsPage
+	videosByUserId map[string][]helix.Video
+	videosErr      error
+	// If it is set, GetVideos waits for ctx like a request to Twitch that doesn't answer.
+	videosStall     bool
+	usersById       map[string]helix.User
+	gamesById       map[string]helix.Game
+	streamsRequests []helix.StreamsParams
+	videosRequests  int
+	tokenResets     int
+}
+
+func newFakeStreamSource(pages ...fakeStreamsPage) *fakeStreamSource {
+	return &fakeStreamSource{
+		pages:          pages,
+		videosByUserId: map[string][]helix.Video{},
+		usersById:      map[string]helix.User{},
+		gamesById:      map[string]helix.Game{},
+	}
+}
+
+func (source *fakeStreamSource) GetStreams(ctx context.Context, params *helix.StreamsParams) (*helix.StreamsResponse, error) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	source.streamsRequests = append(source.streamsRequests, *params)
+	if len(source.pages) == 0 {
+		return &helix.StreamsResponse{}, nil
+	}
+	page := source.pages[0]
+	source.pages = source.pages[1:]
+	if page.err != nil {
+		return nil, page.err
+	}
+	response := &helix.StreamsResponse{}
+	response.Data.Streams = page.streams
+	response.Data.Pagination.Cursor = page.cursor
+	return response, nil
+}
+
+func (source *fakeStreamSource) GetVideos(ctx context.Context, params *helix.VideosParams) (*helix.VideosResponse, error) {
+	source.mu.Lock()
+	source.videosRequests++
+	stall := source.videosStall
+	source.mu.Unlock()
+	if stall {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if source.videosErr != nil {
+		return nil, source.videosErr
+	}
+	response := &helix.VideosResponse{}
+	response.Data.Videos = source.videosByUserId[params.UserID]
+	return response, nil
+}
+
+func (source *fakeStreamSource) GetUsers(ctx context.Context, params *helix.UsersParams) (*helix.UsersResponse, error) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	response := &helix.UsersResponse{}
+	for _, id := range params.IDs {
+		user, ok := source.usersById[id]
+		if ok {
+			response.Data.Users = append(response.Data.Users, user)
+		}
+	}
+	return response, nil
+}
+
+func (source *fakeStreamSource) GetGames(ctx context.Context, params *helix.GamesParams) (*helix.GamesResponse, error) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	response := &helix.GamesResponse{}
+	for _, id := range params.IDs {
+		game, ok := source.gamesById[id]
+		if ok {
+			response.Data.Games = append(response.Data.Games, game)
+		}
+	}
+	return response, nil
+}
+
+func (source *fakeStreamSource) ResetAppAccessToken(ctx context.Context) error {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	source.tokenResets++
+	return nil
+}
+
+// Returns the After cursor of every GetStreams request so far.
+func (source *fakeStreamSource) requestedCursors() []string {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	cursors := []string{}
+	for _, params := range source.streamsRequests {
+		cursors = append(cursors, params.After)
+	}
+	return cursors
+}
+
+func (source *fakeStreamSource) getVideosRequests() int {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	return source.videosRequests
+}
+
+func (source *fakeStreamSource) getTokenResets() int {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	return source.tokenResets
+}
+
+var errFakeHelix = errors.New("fake helix error")
@@ -2,10 +2,10 @@ package scraper
 
 import (
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/auoie/twitch-vods/viewerseries"
 	"github.com/monitor1379/yagods/maps/treemap"
 	"github.com/monitor1379/yagods/utils"
 )
@@ -81,6 +81,20 @@ func (vods *liveVodsPriorityQueue) UpsertVod(data VodDataPoint) (*LiveVod, error
 	return vods.UpsertLiveVod(liveVod)
 }
 
+// Records the viewer count, game and title of node in the viewer series of its VOD.
+// It does nothing if the VOD of node is not in the queue.
+func (vods *liveVodsPriorityQueue) ObserveViewers(data VodDataPoint, interval time.Duration) {
+	node := data.Node
+	vod, ok := vods.streamerIdToVod[node.UserID]
+	if !ok || vod.StreamId != node.ID || vod.StartTimeUnix != node.StartedAt.UTC().Unix() {
+		return
+	}
+	if vod.ViewerSeries == nil {
+		vod.ViewerSeries = &viewerseries.Series{}
+	}
+	vod.ViewerSeries.Observe(data.ResponseReturnedTimeUnix, node.ViewerCount, node.GameID, node.GameName, node.Title, interval)
+}
+
 // Parameters are the information for the VOD.
 // Returns nil error iff new VOD evicts an older VOD.
 // In the above case, the returned VOD will be the evicted VOD.
@@ -96,7 +110,7 @@ func (vods *liveVodsPriorityQueue) UpsertLiveVod(liveVod *LiveVod) (*LiveVod, er
 		return nil, errors.New("VOD is new")
 	} else if curVod.StartTimeUnix != startTimeUnix {
 		// This is a new stream and streamer has a stream in the queue
-		log.Println(fmt.Sprint("curVod.StartTime and startTime: ", time.Unix(curVod.StartTimeUnix, 0).UTC(), " and ", time.Unix(startTimeUnix, 0).UTC()))
+		slog.Debug("streamer has a new stream", "queue", "live", "streamer_id", streamerId, "old_start_time", curVod.StartTimeUnix, "new_start_time", startTimeUnix)
 		vods.RemoveVod(curVod)
 		vods.lastUpdatedToVod.Put(liveVod.getLiveVodsKey(), liveVod)
 		vods.streamerIdToVod[streamerId] = liveVod
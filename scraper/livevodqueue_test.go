@@ -0,0 +1,259 @@
+package scraper
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/nicklaw5/helix"
+)
+
+func makeLiveVod(streamerId string, startTimeUnix int64, maxViews int, lastUpdatedUnix int64) *LiveVod {
+	return &LiveVod{
+		StreamerId:           streamerId,
+		StreamId:             fmt.Sprint(streamerId, "-", startTimeUnix),
+		StartTimeUnix:        startTimeUnix,
+		StreamerLoginAtStart: "login" + streamerId,
+		MaxViews:             maxViews,
+		LastUpdatedUnix:      lastUpdatedUnix,
+		LastInteractionUnix:  lastUpdatedUnix,
+	}
+}
+
+// Checks that the map and the tree describe the same set of VODs.
+// If a VOD's fields change while it is in the tree, its key can no longer be found and this fails.
+func checkLiveVodsInvariants(t testing.TB, vods *liveVodsPriorityQueue) {
+	t.Helper()
+	if len(vods.streamerIdToVod) != vods.lastUpdatedToVod.Size() {
+		t.Fatalf("map has %v VODs but tree has %v", len(vods.streamerIdToVod), vods.lastUpdatedToVod.Size())
+	}
+	for streamerId, vod := range vods.streamerIdToVod {
+		if vod.StreamerId != streamerId {
+			t.Fatalf("VOD of streamer %v is stored under streamer %v", vod.StreamerId, streamerId)
+		}
+		treeVod, ok := vods.lastUpdatedToVod.Get(vod.getLiveVodsKey())
+		if !ok || treeVod != vod {
+			t.Fatalf("VOD %v is in the map but not under its key in the tree", *vod)
+		}
+	}
+	var prev *liveVodKey
+	vods.lastUpdatedToVod.Each(func(key *liveVodKey, vod *LiveVod) {
+		if *key != *vod.getLiveVodsKey() {
+			t.Fatalf("tree key %v does not match VOD %v", *key, *vod)
+		}
+		if prev != nil && (prev.lastUpdatedUnix > key.lastUpdatedUnix || prev.lastUpdatedUnix == key.lastUpdatedUnix && prev.streamId >= key.streamId) {
+			t.Fatalf("tree keys %v and %v are out of order", *prev, *key)
+		}
+		prev = key
+	})
+}
+
+func TestLiveVodsPriorityQueueUpsertLiveVod(t *testing.T) {
+	testCases := []struct {
+		name            string
+		existing        []*LiveVod
+		upsert          *LiveVod
+		wantEvicted     bool
+		wantSize        int
+		wantMaxViews    int
+		wantLastUpdated int64
+	}{
+		{
+			name:            "new streamer",
+			upsert:          makeLiveVod("a", 100, 10, 200),
+			wantSize:        1,
+			wantMaxViews:    10,
+			wantLastUpdated: 200,
+		},
+		{
+			name:            "same stream keeps max views",
+			existing:        []*LiveVod{makeLiveVod("a", 100, 50, 200)},
+			upsert:          makeLiveVod("a", 100, 10, 300),
+			wantSize:        1,
+			wantMaxViews:    50,
+			wantLastUpdated: 300,
+		},
+		{
+			name:            "same stream raises max views",
+			existing:        []*LiveVod{makeLiveVod("a", 100, 10, 200)},
+			upsert:          makeLiveVod("a", 100, 50, 300),
+			wantSize:        1,
+			wantMaxViews:    50,
+			wantLastUpdated: 300,
+		},
+		{
+			name:            "restarted stream evicts the old stream",
+			existing:        []*LiveVod{makeLiveVod("a", 100, 50, 200), makeLiveVod("b", 100, 50, 200)},
+			upsert:          makeLiveVod("a", 250, 10, 300),
+			wantEvicted:     true,
+			wantSize:        2,
+			wantMaxViews:    10,
+			wantLastUpdated: 300,
+		},
+		{
+			name:            "other streamers are untouched",
+			existing:        []*LiveVod{makeLiveVod("b", 100, 50, 200)},
+			upsert:          makeLiveVod("a", 100, 10, 200),
+			wantSize:        2,
+			wantMaxViews:    10,
+			wantLastUpdated: 200,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			vods := CreateNewLiveVodsPriorityQueue()
+			for _, vod := range testCase.existing {
+				vods.UpsertLiveVod(vod)
+			}
+			evicted, err := vods.UpsertLiveVod(testCase.upsert)
+			checkLiveVodsInvariants(t, vods)
+			assertEqual(t, err == nil, testCase.wantEvicted)
+			if testCase.wantEvicted {
+				assertEqual(t, evicted, testCase.existing[0])
+			}
+			assertEqual(t, vods.Size(), testCase.wantSize)
+			vod := vods.streamerIdToVod[testCase.upsert.StreamerId]
+			assertEqual(t, vod.StartTimeUnix, testCase.upsert.StartTimeUnix)
+			assertEqual(t, vod.MaxViews, testCase.wantMaxViews)
+			assertEqual(t, vod.LastUpdatedUnix, testCase.wantLastUpdated)
+		})
+	}
+}
+
+func TestLiveVodsPriorityQueueUpsertVod(t *testing.T) {
+	vods := CreateNewLiveVodsPriorityQueue()
+	node := &helix.Stream{ID: "s1", UserID: "a", UserLogin: "loga", GameID: "g", ViewerCount: 30, StartedAt: testStartTime}
+	_, err := vods.UpsertVod(VodDataPoint{Node: node, ResponseReturnedTimeUnix: testStartTime.Unix() + 60})
+	if err == nil {
+		t.Fatal("new VOD should not evict anything")
+	}
+	checkLiveVodsInvariants(t, vods)
+	vod, err := vods.GetStalestStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, *vod, LiveVod{
+		StreamerId:           "a",
+		StreamId:             "s1",
+		StartTimeUnix:        testStartTime.Unix(),
+		StreamerLoginAtStart: "loga",
+		GameIdAtStart:        "g",
+		MaxViews:             30,
+		LastUpdatedUnix:      testStartTime.Unix() + 60,
+		LastInteractionUnix:  testStartTime.Unix() + 60,
+	})
+}
+
+func TestLiveVodsPriorityQueueGetStalestStream(t *testing.T) {
+	vods := CreateNewLiveVodsPriorityQueue()
+	_, err := vods.GetStalestStream()
+	if err == nil {
+		t.Fatal("empty queue should return an error")
+	}
+	vods.UpsertLiveVod(makeLiveVod("a", 100, 10, 300))
+	vods.UpsertLiveVod(makeLiveVod("b", 100, 10, 200))
+	vods.UpsertLiveVod(makeLiveVod("c", 100, 10, 400))
+	// Updating b makes a the stalest.
+	vods.UpsertLiveVod(makeLiveVod("b", 100, 10, 500))
+	checkLiveVodsInvariants(t, vods)
+	order := []string{}
+	for vods.Size() > 0 {
+		vod, err := vods.GetStalestStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, vod.StreamerId)
+		vods.RemoveVod(vod)
+		checkLiveVodsInvariants(t, vods)
+	}
+	assertEqual(t, fmt.Sprint(order), "[a c b]")
+}
+
+// The state of one streamer in the model of liveVodsPriorityQueue.
+type liveVodModel struct {
+	startTimeUnix   int64
+	maxViews        int
+	lastUpdatedUnix int64
+}
+
+// Applies one operation to both the queue and a plain map model, then compares them.
+// kind, streamer, startTime and value are arbitrary and are reduced to a small domain so that collisions are common.
+func applyLiveVodsOp(t testing.TB, vods *liveVodsPriorityQueue, model map[string]liveVodModel, kind, streamer, startTime byte, value int) {
+	t.Helper()
+	streamerId := fmt.Sprint(streamer % 8)
+	startTimeUnix := int64(startTime % 4)
+	switch kind % 4 {
+	case 0, 1:
+		upserted := makeLiveVod(streamerId, startTimeUnix, value, int64(value%64))
+		evicted, err := vods.UpsertLiveVod(upserted)
+		cur, ok := model[streamerId]
+		if ok && cur.startTimeUnix != startTimeUnix {
+			if err != nil || evicted.StartTimeUnix != cur.startTimeUnix {
+				t.Fatalf("expected stream %v of %v to be evicted, got %v %v", cur.startTimeUnix, streamerId, evicted, err)
+			}
+		} else if err == nil {
+			t.Fatalf("unexpected eviction of %v", *evicted)
+		}
+		if ok && cur.startTimeUnix == startTimeUnix {
+			model[streamerId] = liveVodModel{startTimeUnix, getMax(cur.maxViews, value), upserted.LastUpdatedUnix}
+		} else {
+			model[streamerId] = liveVodModel{startTimeUnix, value, upserted.LastUpdatedUnix}
+		}
+	case 2:
+		vod, err := vods.GetStalestStream()
+		if len(model) == 0 {
+			if err == nil {
+				t.Fatal("empty queue returned a VOD")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, other := range model {
+			if other.lastUpdatedUnix < vod.LastUpdatedUnix {
+				t.Fatalf("stalest VOD was updated at %v but another was updated at %v", vod.LastUpdatedUnix, other.lastUpdatedUnix)
+			}
+		}
+		vods.RemoveVod(vod)
+		delete(model, vod.StreamerId)
+	case 3:
+		vod, ok := vods.streamerIdToVod[streamerId]
+		if ok {
+			vods.RemoveVod(vod)
+		}
+		delete(model, streamerId)
+	}
+	checkLiveVodsInvariants(t, vods)
+	assertEqual(t, vods.Size(), len(model))
+	for streamerId, want := range model {
+		vod := vods.streamerIdToVod[streamerId]
+		got := liveVodModel{vod.StartTimeUnix, vod.MaxViews, vod.LastUpdatedUnix}
+		if got != want {
+			t.Fatalf("streamer %v has %v want %v", streamerId, got, want)
+		}
+	}
+}
+
+func TestLiveVodsPriorityQueueRandomOperations(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		random := rand.New(rand.NewSource(seed))
+		vods := CreateNewLiveVodsPriorityQueue()
+		model := map[string]liveVodModel{}
+		for i := 0; i < 500; i++ {
+			applyLiveVodsOp(t, vods, model, byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256)), random.Intn(1000))
+		}
+	}
+}
+
+func FuzzLiveVodsPriorityQueue(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 10, 0, 1, 1, 20, 2, 0, 0, 0, 3, 1, 0, 0})
+	f.Add([]byte{0, 1, 0, 10, 1, 1, 0, 5, 0, 2, 0, 7, 2, 0, 0, 0, 2, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		vods := CreateNewLiveVodsPriorityQueue()
+		model := map[string]liveVodModel{}
+		for i := 0; i+4 <= len(ops); i += 4 {
+			applyLiveVodsOp(t, vods, model, ops[i], ops[i+1], ops[i+2], int(ops[i+3]))
+		}
+	})
+}
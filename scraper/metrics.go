@@ -0,0 +1,228 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/nicklaw5/helix"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The metrics are registered with the default Prometheus registry, so promhttp.Handler() serves them.
+var (
+	helixRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "helix_request_duration_seconds",
+		Help:      "Latency of Twitch Helix requests by request type.",
+	}, []string{"request"})
+	helixRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "helix_request_errors_total",
+		Help:      "Twitch Helix requests that returned an error or an error status code by request type.",
+	}, []string{"request"})
+	cursorResets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "cursor_resets_total",
+		Help:      "Resets of the Twitch Helix streams cursor by reason.",
+	}, []string{"reason"})
+	queueSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "queue_size",
+		Help:      "Number of VODs in the live, wait, old and retry queues.",
+	}, []string{"queue"})
+	vodsEvicted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "vods_evicted_total",
+		Help:      "VODs evicted from the live queue for being stale, from the wait queue for being finished and from the old queue for being over its size limit.",
+	}, []string{"queue"})
+	streamRestarts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "stream_restarts_total",
+		Help:      "Streams that were observed to restart with a new stream ID.",
+	})
+	hlsFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "hls_fetches_total",
+		Help:      "Finished .m3u8 searches by whether the .m3u8 was found.",
+	}, []string{"result"})
+	hlsFound = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "hls_found_total",
+		Help:      "Found .m3u8 files by domain and by path variant (unix, minus_one or non_unix).",
+	}, []string{"domain", "variant"})
+	hlsRenditionsFound = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "hls_renditions_found_total",
+		Help:      "Renditions found next to the source .m3u8, not counting the source.",
+	}, []string{"rendition"})
+	seekPreviewsFound = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "seek_previews_found_total",
+		Help:      "Thumbnails and storyboards found next to the source .m3u8.",
+	}, []string{"file"})
+	hlsCompressedBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "hls_compressed_bytes",
+		Help:      "Size of the compressed media playlists.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 2, 12),
+	})
+	retentionRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "retention_rows_total",
+		Help:      "Rows deleted or cleared by the retention job by target (streams, streamers or recordings).",
+	}, []string{"target"})
+	hlsVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "hls_verifications_total",
+		Help:      "Stored .m3u8 files fetched again by the verifier by result (unchanged, changed, remuted, missing, gone or failed).",
+	}, []string{"result"})
+	dbWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "twitch_vods",
+		Subsystem: "scraper",
+		Name:      "db_write_duration_seconds",
+		Help:      "Latency of database writes by query, including failed writes.",
+	}, []string{"query"})
+)
+
+// Records the latency and errors of every Twitch Helix request.
+type instrumentedStreamSource struct {
+	StreamSource
+}
+
+func observeHelixRequest(request string, start time.Time, err error, statusCode func() int) {
+	helixRequestDuration.WithLabelValues(request).Observe(time.Since(start).Seconds())
+	if err != nil || statusCode() >= 400 {
+		helixRequestErrors.WithLabelValues(request).Inc()
+	}
+}
+
+func (source *instrumentedStreamSource) GetStreams(ctx context.Context, params *helix.StreamsParams) (*helix.StreamsResponse, error) {
+	start := time.Now()
+	response, err := source.StreamSource.GetStreams(ctx, params)
+	observeHelixRequest("streams", start, err, func() int { return response.StatusCode })
+	return response, err
+}
+
+func (source *instrumentedStreamSource) GetVideos(ctx context.Context, params *helix.VideosParams) (*helix.VideosResponse, error) {
+	start := time.Now()
+	response, err := source.StreamSource.GetVideos(ctx, params)
+	observeHelixRequest("videos", start, err, func() int { return response.StatusCode })
+	return response, err
+}
+
+func (source *instrumentedStreamSource) GetUsers(ctx context.Context, params *helix.UsersParams) (*helix.UsersResponse, error) {
+	start := time.Now()
+	response, err := source.StreamSource.GetUsers(ctx, params)
+	observeHelixRequest("users", start, err, func() int { return response.StatusCode })
+	return response, err
+}
+
+func (source *instrumentedStreamSource) GetGames(ctx context.Context, params *helix.GamesParams) (*helix.GamesResponse, error) {
+	start := time.Now()
+	response, err := source.StreamSource.GetGames(ctx, params)
+	observeHelixRequest("games", start, err, func() int { return response.StatusCode })
+	return response, err
+}
+
+func (source *instrumentedStreamSource) ResetAppAccessToken(ctx context.Context) error {
+	start := time.Now()
+	err := source.StreamSource.ResetAppAccessToken(ctx)
+	observeHelixRequest("app_access_token", start, err, func() int { return 0 })
+	return err
+}
+
+// Records the latency of the writes the scraper makes. Reads are passed through.
+type instrumentedStore struct {
+	vodstore.Store
+}
+
+func observeDbWrite(query string, start time.Time) {
+	dbWriteDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
+
+func (store instrumentedStore) DeleteOldStreams(ctx context.Context, arg sqlvods.DeleteOldStreamsParams) (int64, error) {
+	defer observeDbWrite("delete_old_streams", time.Now())
+	return store.Store.DeleteOldStreams(ctx, arg)
+}
+
+func (store instrumentedStore) DeleteOldStreamers(ctx context.Context, arg sqlvods.DeleteOldStreamersParams) (int64, error) {
+	defer observeDbWrite("delete_old_streamers", time.Now())
+	return store.Store.DeleteOldStreamers(ctx, arg)
+}
+
+func (store instrumentedStore) ClearOldRecordings(ctx context.Context, arg sqlvods.ClearOldRecordingsParams) (int64, error) {
+	defer observeDbWrite("clear_old_recordings", time.Now())
+	return store.Store.ClearOldRecordings(ctx, arg)
+}
+
+func (store instrumentedStore) UpsertManyStreams(ctx context.Context, arg sqlvods.UpsertManyStreamsParams) error {
+	defer observeDbWrite("upsert_many_streams", time.Now())
+	return store.Store.UpsertManyStreams(ctx, arg)
+}
+
+func (store instrumentedStore) UpsertManyStreamSegments(ctx context.Context, arg sqlvods.UpsertManyStreamSegmentsParams) error {
+	defer observeDbWrite("upsert_many_stream_segments", time.Now())
+	return store.Store.UpsertManyStreamSegments(ctx, arg)
+}
+
+func (store instrumentedStore) UpsertManyStreamers(ctx context.Context, arg sqlvods.UpsertManyStreamersParams) error {
+	defer observeDbWrite("upsert_many_streamers", time.Now())
+	return store.Store.UpsertManyStreamers(ctx, arg)
+}
+
+func (store instrumentedStore) WritePolledStreams(ctx context.Context, arg vodstore.WritePolledStreamsParams) error {
+	defer observeDbWrite("write_polled_streams", time.Now())
+	return store.Store.WritePolledStreams(ctx, arg)
+}
+
+func (store instrumentedStore) UpdateRecording(ctx context.Context, arg sqlvods.UpdateRecordingParams) error {
+	defer observeDbWrite("update_recording", time.Now())
+	return store.Store.UpdateRecording(ctx, arg)
+}
+
+func (store instrumentedStore) UpdateRecordingNotFound(ctx context.Context, arg sqlvods.UpdateRecordingNotFoundParams) ([]sql.NullBool, error) {
+	defer observeDbWrite("update_recording_not_found", time.Now())
+	return store.Store.UpdateRecordingNotFound(ctx, arg)
+}
+
+func (store instrumentedStore) UpdateVerification(ctx context.Context, arg sqlvods.UpdateVerificationParams) error {
+	defer observeDbWrite("update_verification", time.Now())
+	return store.Store.UpdateVerification(ctx, arg)
+}
+
+func (store instrumentedStore) UpdateVerificationFailed(ctx context.Context, arg sqlvods.UpdateVerificationFailedParams) error {
+	defer observeDbWrite("update_verification_failed", time.Now())
+	return store.Store.UpdateVerificationFailed(ctx, arg)
+}
+
+func (store instrumentedStore) UpdateVerificationMissing(ctx context.Context, arg sqlvods.UpdateVerificationMissingParams) ([]bool, error) {
+	defer observeDbWrite("update_verification_missing", time.Now())
+	return store.Store.UpdateVerificationMissing(ctx, arg)
+}
+
+func (store instrumentedStore) UpdateVerifiedRecording(ctx context.Context, arg sqlvods.UpdateVerifiedRecordingParams) error {
+	defer observeDbWrite("update_verified_recording", time.Now())
+	return store.Store.UpdateVerifiedRecording(ctx, arg)
+}
+
+func (store instrumentedStore) UpdateStreamer(ctx context.Context, arg sqlvods.UpdateStreamerParams) error {
+	defer observeDbWrite("update_streamer", time.Now())
+	return store.Store.UpdateStreamer(ctx, arg)
+}
@@ -0,0 +1,82 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/auoie/goVods/vods"
+	"github.com/nicklaw5/helix"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGetValidDwpCountsVariants(t *testing.T) {
+	videoData := makeTestVideoData()
+	minusOne := &vods.VideoData{StreamerName: videoData.StreamerName, VideoId: videoData.VideoId, Time: videoData.Time.Add(-time.Second)}
+	testCases := []struct {
+		variant  string
+		register func(origin *fakeOrigin)
+	}{
+		{variant: "unix", register: func(origin *fakeOrigin) {
+			origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+		}},
+		{variant: "minus_one", register: func(origin *fakeOrigin) {
+			origin.setVideo(minusOne, true, fakeOriginServe, fakeMediaPlaylist)
+		}},
+		{variant: "non_unix", register: func(origin *fakeOrigin) {
+			origin.setVideo(videoData, false, fakeOriginServe, fakeMediaPlaylist)
+		}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.variant, func(t *testing.T) {
+			// Every fake origin has its own domain, so its counters start at zero.
+			origin := newFakeOrigin(t)
+			testCase.register(origin)
+			_, err := getValidDwp(context.Background(), videoData, []string{origin.domain()}, makeRobustHttpClient(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, variant := range []string{"unix", "minus_one", "non_unix"} {
+				want := 0.0
+				if variant == testCase.variant {
+					want = 1.0
+				}
+				assertEqual(t, testutil.ToFloat64(hlsFound.WithLabelValues(origin.domain(), variant)), want)
+			}
+		})
+	}
+}
+
+func TestFetchTwitchHelixForeverCountsCursorResets(t *testing.T) {
+	noNextPage := cursorResets.WithLabelValues("no_next_page")
+	before := testutil.ToFloat64(noNextPage)
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: ""},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {})
+	eventually(t, func() bool { return len(source.requestedCursors()) >= 2 })
+	harness.stop()
+	if testutil.ToFloat64(noNextPage) < before+1 {
+		t.Fatal("expected the cursor reset to be counted")
+	}
+}
+
+func TestInstrumentedStreamSourceCountsErrors(t *testing.T) {
+	streamsErrors := helixRequestErrors.WithLabelValues("streams")
+	before := testutil.ToFloat64(streamsErrors)
+	source := &instrumentedStreamSource{StreamSource: newFakeStreamSource(
+		fakeStreamsPage{err: errors.New("fake helix error")},
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+	)}
+	_, err := source.GetStreams(context.Background(), &helix.StreamsParams{})
+	if err == nil {
+		t.Fatal("expected the scripted error")
+	}
+	assertEqual(t, testutil.ToFloat64(streamsErrors), before+1)
+	_, err = source.GetStreams(context.Background(), &helix.StreamsParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, testutil.ToFloat64(streamsErrors), before+1)
+}
@@ -24,7 +24,7 @@ func (vod *LiveVod) getOldVodKey() *oldVodKey {
 func CreateNewOldVodQueue() *oldVodsPriorityQueue {
 	return &oldVodsPriorityQueue{
 		tree: treemap.NewWith[*oldVodKey, *LiveVod](func(a, b *oldVodKey) int {
-			dif := a.maxViews - b.maxViews
+			dif := utils.NumberComparator(a.maxViews, b.maxViews)
 			if dif != 0 {
 				return dif
 			}
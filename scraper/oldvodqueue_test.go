@@ -0,0 +1,151 @@
+package scraper
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func makeOldVod(streamId string, startTimeUnix int64, maxViews int) *LiveVod {
+	return &LiveVod{StreamerId: "streamer-" + streamId, StreamId: streamId, StartTimeUnix: startTimeUnix, MaxViews: maxViews}
+}
+
+func checkOldVodsInvariants(t testing.TB, vods *oldVodsPriorityQueue) {
+	t.Helper()
+	var prev *LiveVod
+	vods.tree.Each(func(key *oldVodKey, vod *LiveVod) {
+		if *key != *vod.getOldVodKey() {
+			t.Fatalf("tree key %v does not match VOD %v", *key, *vod)
+		}
+		if prev != nil && prev.MaxViews > vod.MaxViews {
+			t.Fatalf("VOD with %v views is ordered before VOD with %v views", prev.MaxViews, vod.MaxViews)
+		}
+		prev = vod
+	})
+}
+
+func TestOldVodsPriorityQueue(t *testing.T) {
+	testCases := []struct {
+		name     string
+		puts     []*LiveVod
+		wantPops []string
+	}{
+		{
+			name:     "empty",
+			wantPops: []string{},
+		},
+		{
+			name:     "pops lowest view count first",
+			puts:     []*LiveVod{makeOldVod("a", 1, 30), makeOldVod("b", 1, 10), makeOldVod("c", 1, 20)},
+			wantPops: []string{"b", "c", "a"},
+		},
+		{
+			name:     "ties are broken by stream id then start time",
+			puts:     []*LiveVod{makeOldVod("b", 1, 10), makeOldVod("a", 2, 10), makeOldVod("a", 1, 10)},
+			wantPops: []string{"a", "a", "b"},
+		},
+		{
+			name:     "identical keys are stored once",
+			puts:     []*LiveVod{makeOldVod("a", 1, 10), makeOldVod("a", 1, 10)},
+			wantPops: []string{"a"},
+		},
+		{
+			name:     "extreme view counts",
+			puts:     []*LiveVod{makeOldVod("max", 1, math.MaxInt), makeOldVod("min", 1, math.MinInt), makeOldVod("zero", 1, 0)},
+			wantPops: []string{"min", "zero", "max"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			vods := CreateNewOldVodQueue()
+			for _, vod := range testCase.puts {
+				vods.Put(vod)
+			}
+			checkOldVodsInvariants(t, vods)
+			assertEqual(t, vods.Size(), len(testCase.wantPops))
+			pops := []string{}
+			for {
+				lowest, err := vods.GetLowViewCount()
+				popped, popErr := vods.PopLowViewCount()
+				assertEqual(t, err == nil, popErr == nil)
+				if err != nil {
+					break
+				}
+				assertEqual(t, lowest, popped)
+				pops = append(pops, popped.StreamId)
+				checkOldVodsInvariants(t, vods)
+			}
+			assertEqual(t, fmt.Sprint(pops), fmt.Sprint(testCase.wantPops))
+		})
+	}
+}
+
+// Applies one operation to both the queue and a sorted slice model, then compares them.
+func applyOldVodsOp(t testing.TB, vods *oldVodsPriorityQueue, model map[oldVodKey]bool, kind, stream, startTime byte, maxViews int) {
+	t.Helper()
+	switch kind % 2 {
+	case 0:
+		vod := makeOldVod(fmt.Sprint(stream%8), int64(startTime%4), maxViews)
+		vods.Put(vod)
+		model[*vod.getOldVodKey()] = true
+	case 1:
+		vod, err := vods.PopLowViewCount()
+		if len(model) == 0 {
+			if err == nil {
+				t.Fatal("empty queue returned a VOD")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key := range model {
+			if key.maxViews < vod.MaxViews {
+				t.Fatalf("popped VOD with %v views but another has %v views", vod.MaxViews, key.maxViews)
+			}
+		}
+		delete(model, *vod.getOldVodKey())
+	}
+	checkOldVodsInvariants(t, vods)
+	assertEqual(t, vods.Size(), len(model))
+	want := []int{}
+	for key := range model {
+		want = append(want, key.maxViews)
+	}
+	sort.Ints(want)
+	got := []int{}
+	vods.tree.Each(func(key *oldVodKey, vod *LiveVod) {
+		got = append(got, vod.MaxViews)
+	})
+	assertEqual(t, fmt.Sprint(got), fmt.Sprint(want))
+}
+
+func TestOldVodsPriorityQueueRandomOperations(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		random := rand.New(rand.NewSource(seed))
+		vods := CreateNewOldVodQueue()
+		model := map[oldVodKey]bool{}
+		for i := 0; i < 500; i++ {
+			applyOldVodsOp(t, vods, model, byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256)), random.Intn(16))
+		}
+	}
+}
+
+func FuzzOldVodsPriorityQueue(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 0, 1, 1, 1, 1}, int64(10))
+	f.Add([]byte{0, 1, 0, 0, 0, 2, 0, 1, 1, 0, 0, 0}, int64(math.MaxInt64))
+	f.Fuzz(func(t *testing.T, ops []byte, views int64) {
+		vods := CreateNewOldVodQueue()
+		model := map[oldVodKey]bool{}
+		for i := 0; i+4 <= len(ops); i += 4 {
+			// Spread view counts across the whole int range so comparator overflow is exercised.
+			maxViews := int(views) >> (ops[i+3] % 64)
+			if ops[i+3]&64 != 0 {
+				maxViews = -maxViews
+			}
+			applyOldVodsOp(t, vods, model, ops[i], ops[i+1], ops[i+2], maxViews)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/auoie/goVods/vods"
+	"github.com/auoie/twitch-vods/renditions"
+)
+
+// The files Twitch keeps next to the playlists of a VOD for browsing and seeking.
+type seekPreviews struct {
+	thumbnailUrl sql.NullString
+	// storyboard JSON describing the sprite sheets
+	infoUrl    sql.NullString
+	spriteUrls []string
+}
+
+// The sprite sheets of one quality in the storyboard JSON.
+type storyboard struct {
+	Images []string `json:"images"`
+}
+
+// The storyboard JSON is a few kilobytes, so anything larger is not one.
+const maxStoryboardBytes = 1 << 20
+
+// Returns the URL of a file in the directory of the VOD, which holds the directories of the renditions.
+func vodFileUrl(dwp *vods.DomainWithPath, name string) string {
+	return strings.TrimSuffix(dwp.GetIndexDvrUrl(), renditions.Source+"/index-dvr.m3u8") + name
+}
+
+// Reports whether a HEAD request for url succeeds.
+func existsAt(ctx context.Context, url string, client *http.Client) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	return response.StatusCode == http.StatusOK, nil
+}
+
+func getStoryboards(ctx context.Context, url string, client *http.Client) ([]storyboard, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	storyboards := []storyboard{}
+	err = json.NewDecoder(io.LimitReader(response.Body, maxStoryboardBytes)).Decode(&storyboards)
+	if err != nil {
+		return nil, err
+	}
+	return storyboards, nil
+}
+
+// The thumbnail is at thumb/thumb0.jpg. The storyboards are at storyboards/<video id>-info.json
+// and list sprite sheets in the same directory, so they can only be found once the stream was archived as a public video.
+// Missing files are left out of the result.
+func getSeekPreviews(ctx context.Context, dwp *vods.DomainWithPath, videoId string, client *http.Client) seekPreviews {
+	previews := seekPreviews{}
+	thumbnailUrl := vodFileUrl(dwp, "thumb/thumb0.jpg")
+	found, err := existsAt(ctx, thumbnailUrl, client)
+	if err != nil {
+		slog.Debug("probing thumbnail failed", "url", thumbnailUrl, "error", err)
+	}
+	if found {
+		seekPreviewsFound.WithLabelValues("thumbnail").Inc()
+		previews.thumbnailUrl = sql.NullString{String: thumbnailUrl, Valid: true}
+	}
+	if videoId == "" {
+		return previews
+	}
+	infoUrl := vodFileUrl(dwp, "storyboards/"+videoId+"-info.json")
+	storyboards, err := getStoryboards(ctx, infoUrl, client)
+	if err != nil {
+		slog.Debug("getting storyboards failed", "url", infoUrl, "error", err)
+		return previews
+	}
+	if storyboards == nil {
+		return previews
+	}
+	seekPreviewsFound.WithLabelValues("storyboards").Inc()
+	previews.infoUrl = sql.NullString{String: infoUrl, Valid: true}
+	for _, storyboard := range storyboards {
+		for _, image := range storyboard.Images {
+			previews.spriteUrls = append(previews.spriteUrls, vodFileUrl(dwp, "storyboards/"+image))
+		}
+	}
+	return previews
+}
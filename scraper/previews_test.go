@@ -0,0 +1,57 @@
+package scraper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/auoie/goVods/vods"
+)
+
+const fakeStoryboards = `[
+{"count":2,"width":220,"rows":1,"images":["v1-low-0.jpg"],"interval":10,"cols":2,"height":124,"quality":"low"},
+{"count":2,"width":220,"rows":1,"images":["v1-high-0.jpg","v1-high-1.jpg"],"interval":10,"cols":1,"height":124,"quality":"high"}
+]`
+
+func getTestDwp(origin *fakeOrigin, videoData *vods.VideoData) *vods.DomainWithPath {
+	dwps := videoData.GetDomainWithPathsList([]string{origin.domain()}, 1, true)
+	return &vods.DomainWithPath{Domain: dwps[0].Domain, Path: dwps[0].Paths[0]}
+}
+
+func TestGetSeekPreviews(t *testing.T) {
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	origin.setFile(videoData, true, "storyboards/v1-info.json", fakeOriginServe, fakeStoryboards)
+	dwp := getTestDwp(origin, videoData)
+	client := makeRobustHttpClient(time.Second)
+
+	previews := getSeekPreviews(context.Background(), dwp, "v1", client)
+	base := strings.TrimSuffix(dwp.GetIndexDvrUrl(), "chunked/index-dvr.m3u8")
+	assertEqual(t, previews.thumbnailUrl.String, base+"thumb/thumb0.jpg")
+	assertEqual(t, previews.infoUrl.String, base+"storyboards/v1-info.json")
+	assertEqual(t, strings.Join(previews.spriteUrls, ","), strings.Join([]string{
+		base + "storyboards/v1-low-0.jpg",
+		base + "storyboards/v1-high-0.jpg",
+		base + "storyboards/v1-high-1.jpg",
+	}, ","))
+
+	// The storyboards can't be found without the id of the archived video.
+	previews = getSeekPreviews(context.Background(), dwp, "", client)
+	assertEqual(t, previews.thumbnailUrl.Valid, true)
+	assertEqual(t, previews.infoUrl.Valid, false)
+	assertEqual(t, len(previews.spriteUrls), 0)
+}
+
+func TestGetSeekPreviewsMissingFiles(t *testing.T) {
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	origin.setFile(videoData, true, "thumb/", fakeOriginNotFound, "")
+	origin.setFile(videoData, true, "storyboards/", fakeOriginForbidden, "")
+	previews := getSeekPreviews(context.Background(), getTestDwp(origin, videoData), "v1", makeRobustHttpClient(time.Second))
+	assertEqual(t, previews.thumbnailUrl.Valid, false)
+	assertEqual(t, previews.infoUrl.Valid, false)
+	assertEqual(t, len(previews.spriteUrls), 0)
+}
@@ -0,0 +1,109 @@
+package scraper
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/vodstore"
+)
+
+// A kind of row the retention job deletes or clears.
+type retentionTarget struct {
+	// name used in logs and metrics
+	name string
+	// Rows for streams that started longer ago than this are deleted or cleared. If zero, they are kept forever.
+	maxAge time.Duration
+	// Deletes or clears at most limit rows that started before startTime and returns how many it changed.
+	runBatch func(ctx context.Context, startTime time.Time, limit int32) (int64, error)
+}
+
+func makeRetentionTargets(store vodstore.Store, params RunScraperParams) []retentionTarget {
+	oldStreamersDelete := params.OldStreamersDelete
+	if oldStreamersDelete == 0 {
+		oldStreamersDelete = params.OldVodsDelete
+	}
+	// Streams are deleted before recordings are cleared, so recordings aren't cleared in rows that are about to be deleted.
+	return []retentionTarget{
+		{
+			name:   "streams",
+			maxAge: params.OldVodsDelete,
+			runBatch: func(ctx context.Context, startTime time.Time, limit int32) (int64, error) {
+				return store.DeleteOldStreams(ctx, sqlvods.DeleteOldStreamsParams{StartTime: startTime, Limit: limit})
+			},
+		},
+		{
+			name:   "streamers",
+			maxAge: oldStreamersDelete,
+			runBatch: func(ctx context.Context, startTime time.Time, limit int32) (int64, error) {
+				return store.DeleteOldStreamers(ctx, sqlvods.DeleteOldStreamersParams{StartTime: startTime, Limit: limit})
+			},
+		},
+		{
+			name:   "recordings",
+			maxAge: params.OldRecordingsDelete,
+			runBatch: func(ctx context.Context, startTime time.Time, limit int32) (int64, error) {
+				return store.ClearOldRecordings(ctx, sqlvods.ClearOldRecordingsParams{StartTime: startTime, Limit: limit})
+			},
+		},
+	}
+}
+
+type runRetentionParams struct {
+	// Retention runs until ctx is done.
+	ctx                 context.Context
+	targets             []retentionTarget
+	interval            time.Duration
+	batchSize           int
+	sqlRequestTimeLimit time.Duration
+}
+
+// Runs every batch for target until a batch changes fewer than batchSize rows.
+// Each batch is its own statement, so no statement holds its locks for long.
+func runRetentionTarget(params runRetentionParams, target retentionTarget) {
+	startTime := time.Now().UTC().Add(-target.maxAge)
+	total := int64(0)
+	for params.ctx.Err() == nil {
+		requestCtx, requestCancel := context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
+		numRows, err := target.runBatch(requestCtx, startTime, int32(params.batchSize))
+		requestCancel()
+		if err != nil {
+			if params.ctx.Err() == nil {
+				slog.Error("retention batch failed", "target", target.name, "error", err)
+			}
+			break
+		}
+		total += numRows
+		retentionRows.WithLabelValues(target.name).Add(float64(numRows))
+		if numRows < int64(params.batchSize) {
+			break
+		}
+	}
+	if total > 0 {
+		slog.Info("retention finished", "target", target.name, "num_rows", total, "start_time", startTime)
+	}
+}
+
+func runRetentionTargets(params runRetentionParams) {
+	for _, target := range params.targets {
+		if target.maxAge > 0 {
+			runRetentionTarget(params, target)
+		}
+	}
+}
+
+// Deletes old streams and streamers and clears old recordings once on startup and then every interval.
+// This is separate from polling Twitch Helix so that the deletes don't slow down the upserts.
+func runRetention(params runRetentionParams) {
+	ticker := time.NewTicker(params.interval)
+	defer ticker.Stop()
+	for {
+		runRetentionTargets(params)
+		select {
+		case <-params.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
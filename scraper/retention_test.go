@@ -0,0 +1,111 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/nicklaw5/helix"
+)
+
+// Stores numStreams streams with recordings that started age ago and one stream that started now.
+func makeRetentionStore(t *testing.T, numStreams int, age time.Duration) *vodstore.Memory {
+	t.Helper()
+	ctx := context.Background()
+	store := vodstore.NewMemory()
+	now := time.Now().UTC()
+	streams := []*helix.Stream{}
+	for i := 0; i < numStreams; i++ {
+		stream := makeTestStream(fmt.Sprint("old", i), fmt.Sprint("u", i), 100, now.Add(-age))
+		streams = append(streams, &stream)
+	}
+	stream := makeTestStream("new", "unew", 100, now)
+	streams = append(streams, &stream)
+	err := store.WritePolledStreams(ctx, vodstore.WritePolledStreamsParams{
+		Streams:   twitchGqlResponseUpsertStreamsParams(streams, now),
+		Streamers: twitchGqlResponseUpsertStreamersParams(streams),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range streams {
+		err := store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+			StreamID:     stream.ID,
+			StartTime:    stream.StartedAt,
+			GzippedBytes: []byte("m3u8"),
+			BytesFound:   sql.NullBool{Bool: true, Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// The search also returns similar logins, so only the exact match counts.
+func hasStreamer(t *testing.T, store vodstore.Store, login string) bool {
+	t.Helper()
+	streamers, err := store.GetMatchingStreamers(context.Background(), sqlvods.GetMatchingStreamersParams{Query: login, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, streamer := range streamers {
+		if streamer.StreamerLoginAtStart == login {
+			return true
+		}
+	}
+	return false
+}
+
+func runRetentionOnce(store vodstore.Store, params RunScraperParams, batchSize int) {
+	runRetentionTargets(runRetentionParams{
+		ctx:                 context.Background(),
+		targets:             makeRetentionTargets(store, params),
+		interval:            time.Hour,
+		batchSize:           batchSize,
+		sqlRequestTimeLimit: time.Second,
+	})
+}
+
+func TestRetentionDeletesInBatches(t *testing.T) {
+	store := makeRetentionStore(t, 5, 48*time.Hour)
+	runRetentionOnce(store, RunScraperParams{OldVodsDelete: 24 * time.Hour}, 2)
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, streams[0].StreamID, "new")
+	assertEqual(t, hasStreamer(t, store, "loginu0"), false)
+}
+
+func TestRetentionClearsRecordingsBeforeStreams(t *testing.T) {
+	store := makeRetentionStore(t, 3, 48*time.Hour)
+	runRetentionOnce(store, RunScraperParams{OldVodsDelete: 72 * time.Hour, OldRecordingsDelete: 24 * time.Hour}, 2)
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 4)
+	for _, stream := range streams {
+		assertEqual(t, stream.GzippedBytes == nil, stream.StreamID != "new")
+	}
+	assertEqual(t, hasStreamer(t, store, "loginu0"), true)
+}
+
+func TestRetentionKeepsRowsWithoutMaxAge(t *testing.T) {
+	store := makeRetentionStore(t, 3, 48*time.Hour)
+	runRetentionOnce(store, RunScraperParams{}, 2)
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 4)
+	for _, stream := range streams {
+		assertEqual(t, stream.GzippedBytes != nil, true)
+	}
+}
@@ -0,0 +1,89 @@
+package scraper
+
+import (
+	"errors"
+	"time"
+
+	"github.com/monitor1379/yagods/maps/treemap"
+	"github.com/monitor1379/yagods/utils"
+)
+
+type retryVodKey struct {
+	nextAttemptUnix int64
+	streamId        string
+	startTimeUnix   int64
+}
+
+type retryVodsPriorityQueue struct {
+	// The next attempt time isn't a field of LiveVod, so the key is stored to remove the VOD from the tree.
+	streamIdToKey    map[streamIdStartTime]*retryVodKey // at most one VOD per (streamId, startTime)
+	nextAttemptToVod *treemap.Map[*retryVodKey, *LiveVod]
+}
+
+// This makes it easy to fetch the VOD that should be retried first.
+func CreateNewRetryVodsPriorityQueue() *retryVodsPriorityQueue {
+	return &retryVodsPriorityQueue{
+		streamIdToKey: map[streamIdStartTime]*retryVodKey{},
+		nextAttemptToVod: treemap.NewWith[*retryVodKey, *LiveVod](func(a, b *retryVodKey) int {
+			dif := utils.NumberComparator(a.nextAttemptUnix, b.nextAttemptUnix)
+			if dif != 0 {
+				return dif
+			}
+			dif = utils.StringComparator(a.streamId, b.streamId)
+			if dif != 0 {
+				return dif
+			}
+			return utils.NumberComparator(a.startTimeUnix, b.startTimeUnix)
+		}),
+	}
+}
+
+func (vods *retryVodsPriorityQueue) Size() int {
+	return vods.nextAttemptToVod.Size()
+}
+
+func (vods *retryVodsPriorityQueue) Contains(streamId string, startTimeUnix int64) bool {
+	_, ok := vods.streamIdToKey[streamIdStartTime{streamId: streamId, startTimeUnix: startTimeUnix}]
+	return ok
+}
+
+// Returns the VOD with the earliest next attempt time and that time.
+func (vods *retryVodsPriorityQueue) GetEarliest() (*LiveVod, int64, error) {
+	key, vod := vods.nextAttemptToVod.Min()
+	if key == nil || vod == nil {
+		return nil, 0, errors.New("vods is empty")
+	}
+	return vod, key.nextAttemptUnix, nil
+}
+
+func (vods *retryVodsPriorityQueue) RemoveVod(vod *LiveVod) {
+	id := streamIdStartTime{streamId: vod.StreamId, startTimeUnix: vod.StartTimeUnix}
+	key, ok := vods.streamIdToKey[id]
+	if !ok {
+		return
+	}
+	vods.nextAttemptToVod.Remove(key)
+	delete(vods.streamIdToKey, id)
+}
+
+// Replaces the VOD with the same (streamId, startTime) if there is one.
+func (vods *retryVodsPriorityQueue) Put(vod *LiveVod, nextAttemptUnix int64) {
+	vods.RemoveVod(vod)
+	key := &retryVodKey{nextAttemptUnix: nextAttemptUnix, streamId: vod.StreamId, startTimeUnix: vod.StartTimeUnix}
+	vods.streamIdToKey[streamIdStartTime{streamId: vod.StreamId, startTimeUnix: vod.StartTimeUnix}] = key
+	vods.nextAttemptToVod.Put(key, vod)
+}
+
+// The delay between the last and next search for the .m3u8 of a VOD that has been searched for attempts times.
+// It doubles with every attempt, up to a year so it never overflows.
+func getRetryDelay(baseDelay time.Duration, attempts int) time.Duration {
+	delay := baseDelay
+	for i := 1; i < attempts && delay < 24*time.Hour*365; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+func (vod *LiveVod) getNextHlsFetchUnix(retryBaseDelay time.Duration) int64 {
+	return vod.LastHlsFetchUnix + int64(getRetryDelay(retryBaseDelay, vod.HlsFetchAttempts)/time.Second)
+}
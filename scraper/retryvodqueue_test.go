@@ -0,0 +1,68 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetRetryDelay(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: time.Minute},
+		{attempts: 1, want: time.Minute},
+		{attempts: 2, want: 2 * time.Minute},
+		{attempts: 5, want: 16 * time.Minute},
+		{attempts: 1000, want: time.Minute << 20},
+	}
+	for _, testCase := range testCases {
+		assertEqual(t, getRetryDelay(time.Minute, testCase.attempts), testCase.want)
+	}
+}
+
+func checkRetryVodsInvariants(t testing.TB, vods *retryVodsPriorityQueue) {
+	t.Helper()
+	if len(vods.streamIdToKey) != vods.nextAttemptToVod.Size() {
+		t.Fatalf("map has %v VODs but tree has %v", len(vods.streamIdToKey), vods.nextAttemptToVod.Size())
+	}
+	for id, key := range vods.streamIdToKey {
+		vod, ok := vods.nextAttemptToVod.Get(key)
+		if !ok || vod.StreamId != id.streamId || vod.StartTimeUnix != id.startTimeUnix {
+			t.Fatalf("%v is in the map but not under its key in the tree", id)
+		}
+	}
+}
+
+func TestRetryVodsPriorityQueue(t *testing.T) {
+	vods := CreateNewRetryVodsPriorityQueue()
+	_, _, err := vods.GetEarliest()
+	if err == nil {
+		t.Fatal("empty queue should return an error")
+	}
+	a := makeOldVod("a", 1, 10)
+	b := makeOldVod("b", 1, 10)
+	vods.Put(a, 300)
+	vods.Put(b, 200)
+	checkRetryVodsInvariants(t, vods)
+	earliest, nextAttemptUnix, err := vods.GetEarliest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, earliest, b)
+	assertEqual(t, nextAttemptUnix, int64(200))
+
+	// Putting b again reschedules it instead of adding another entry.
+	vods.Put(b, 400)
+	checkRetryVodsInvariants(t, vods)
+	assertEqual(t, vods.Size(), 2)
+	earliest, _, _ = vods.GetEarliest()
+	assertEqual(t, earliest, a)
+	assertEqual(t, vods.Contains("b", 1), true)
+	assertEqual(t, vods.Contains("b", 2), false)
+
+	vods.RemoveVod(a)
+	vods.RemoveVod(a)
+	checkRetryVodsInvariants(t, vods)
+	assertEqual(t, vods.Size(), 1)
+}
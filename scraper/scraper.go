@@ -4,14 +4,18 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/auoie/goVods/vods"
+	"github.com/auoie/twitch-vods/renditions"
 	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/viewerseries"
+	"github.com/auoie/twitch-vods/vodstore"
 	"github.com/grafov/m3u8"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/jdvr/go-again"
@@ -33,11 +37,32 @@ type LiveVod struct {
 	MaxViews             int
 	LastUpdatedUnix      int64 // time twitchgql request completed
 	LastInteractionUnix  int64 // last time interacted with (e.g. time twitchgql request completed or time sql fetch completed)
+	HlsFetchAttempts     int   // number of times the .m3u8 was searched for
+	LastHlsFetchUnix     int64 // last time the .m3u8 was searched for
 
-	// At the moment, I don't do anything with this. Storing it would use up a lot of space.
-	// Should I serialize it with something like Protobuf and then GZIP it?
-	// Should I use an OLAP database (Clickhouse or TimeScale)?
-	// TimeSeries []VodDataPoint
+	// Downsampled viewer counts, games and titles. It is nil if viewer counts are not recorded.
+	// It is written to the database when the VOD leaves the wait queue.
+	ViewerSeries *viewerseries.Series `json:",omitempty"`
+}
+
+// Copies the VOD so that the copy can be read by another goroutine while the owner keeps observing the VOD.
+func (vod *LiveVod) clone() LiveVod {
+	vodCopy := *vod
+	if vod.ViewerSeries != nil {
+		vodCopy.ViewerSeries = vod.ViewerSeries.Clone()
+	}
+	return vodCopy
+}
+
+// Logged VODs are a group of their identifying fields.
+func (vod *LiveVod) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("stream_id", vod.StreamId),
+		slog.String("streamer_login", vod.StreamerLoginAtStart),
+		slog.Int64("start_time", vod.StartTimeUnix),
+		slog.Int("max_views", vod.MaxViews),
+		slog.Int("hls_fetch_attempts", vod.HlsFetchAttempts),
+	)
 }
 
 func (vod *LiveVod) GetVideoData() *vods.VideoData {
@@ -54,6 +79,12 @@ type VodResult struct {
 	ProfileImageUrl    sql.NullString
 	BoxArtUrl          sql.NullString
 	HlsDurationSeconds sql.NullFloat64
+	HlsError           sql.NullString
+	// renditions found next to the source playlist, nil if the .m3u8 was not found
+	Renditions            []string
+	ThumbnailUrl          sql.NullString
+	SeekPreviewsUrl       sql.NullString
+	SeekPreviewSpriteUrls []string
 }
 
 func edgeNodesMatchingAndNonEmpty(
@@ -76,8 +107,9 @@ func edgeNodesMatchingAndNonEmpty(
 
 type fetchTwitchHelixForeverParams struct {
 	ctx                      context.Context
+	initialLiveVodQueue      *liveVodsPriorityQueue
 	initialWaitVodQueue      *waitVodsPriorityQueue
-	twitchHelixClient        *helix.Client
+	twitchHelixClient        StreamSource
 	sqlRequestTimeLimit      time.Duration
 	twitchHelixFetcherDelay  time.Duration
 	cursorResetThreshold     time.Duration
@@ -86,10 +118,15 @@ type fetchTwitchHelixForeverParams struct {
 	oldVodsCh                chan []*LiveVod
 	minViewerCountToObserve  int
 	minViewerCountToRecord   int
-	queries                  *sqlvods.Queries
+	store                    vodstore.Store
 	numStreamsPerRequest     int
-	oldVodsDelete            time.Duration
-	done                     chan struct{}
+	checkpointer             *queueCheckpointer
+	// Snapshots of the live and wait queues for the admin API. If it is nil, there is no admin API.
+	adminQueuesCh chan *adminQueuesRequest
+	// Pauses and resumes polling for the admin API.
+	pausedCh chan bool
+	// If zero, viewer counts are not recorded.
+	viewerSampleInterval time.Duration
 }
 
 func twitchGqlResponseUpsertStreamsParams(
@@ -114,13 +151,21 @@ func twitchGqlResponseUpsertStreamsParams(
 	return result
 }
 
-func resetAppAccessToken(client *helix.Client) error {
-	appAccessToken, err := client.RequestAppAccessToken([]string{})
-	if err != nil {
-		return err
+// Every poll records the stream's current game and title, so a stream is listed under every game it played.
+func twitchGqlResponseUpsertStreamSegmentsParams(
+	streams []*helix.Stream,
+	responseReturnedTime time.Time,
+) sqlvods.UpsertManyStreamSegmentsParams {
+	result := sqlvods.UpsertManyStreamSegmentsParams{}
+	for _, node := range streams {
+		result.StreamIDArr = append(result.StreamIDArr, node.ID)
+		result.StartTimeArr = append(result.StartTimeArr, node.StartedAt.UTC())
+		result.GameIDArr = append(result.GameIDArr, node.GameID)
+		result.GameNameArr = append(result.GameNameArr, node.GameName)
+		result.TitleArr = append(result.TitleArr, node.Title)
+		result.SeenAtArr = append(result.SeenAtArr, responseReturnedTime)
 	}
-	client.SetAppAccessToken(appAccessToken.Data.AccessToken)
-	return nil
+	return result
 }
 
 func twitchGqlResponseUpsertStreamersParams(
@@ -135,57 +180,113 @@ func twitchGqlResponseUpsertStreamersParams(
 	return result
 }
 
+// VODs without a viewer series are skipped.
+func liveVodsUpdateViewerSeriesParams(vods []*LiveVod) sqlvods.UpdateManyViewerSeriesParams {
+	result := sqlvods.UpdateManyViewerSeriesParams{}
+	for _, vod := range vods {
+		if vod.ViewerSeries == nil {
+			continue
+		}
+		result.StreamIDArr = append(result.StreamIDArr, vod.StreamId)
+		result.StartTimeArr = append(result.StartTimeArr, time.Unix(vod.StartTimeUnix, 0).UTC())
+		result.ViewerSeriesArr = append(result.ViewerSeriesArr, viewerseries.Encode(vod.ViewerSeries))
+	}
+	return result
+}
+
 func retryOnError[T any](doer func() (T, error)) (T, error) {
 	res, err := doer()
 	if err != nil {
-		log.Println(fmt.Sprint("retrying on error: ", err))
+		slog.Warn("retrying on error", "error", err)
 		return doer()
 	}
 	return res, err
 }
 
+// Runs the writes for one poll in a transaction.
+// Serialization failures and connection errors are retried with a doubling delay for at most one polling delay,
+// so a transient database failure costs one iteration. Each attempt gets its own request time limit.
+func writePolledStreams(params fetchTwitchHelixForeverParams, arg vodstore.WritePolledStreamsParams) error {
+	deadline := time.Now().Add(params.twitchHelixFetcherDelay)
+	delay := params.twitchHelixFetcherDelay / 8
+	for {
+		requestCtx, requestCancel := context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
+		err := params.store.WritePolledStreams(requestCtx, arg)
+		requestCancel()
+		if err == nil || !vodstore.IsRetryable(err) || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		slog.Warn("retrying writing polled streams", "delay", delay, "error", err)
+		select {
+		case <-params.ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
 func fetchTwitchHelixForever(params fetchTwitchHelixForeverParams) {
-	log.Println("Inside fetchTwitchGqlForever...")
-	log.Println(fmt.Sprint("Fetcher delay: ", params.twitchHelixFetcherDelay))
-	liveVodQueue := CreateNewLiveVodsPriorityQueue()
+	slog.Info("starting to poll Twitch Helix", "delay", params.twitchHelixFetcherDelay)
+	liveVodQueue := params.initialLiveVodQueue
+	if liveVodQueue == nil {
+		liveVodQueue = CreateNewLiveVodsPriorityQueue()
+	}
 	waitVodQueue := params.initialWaitVodQueue
 	twitchGqlTicker := time.NewTicker(params.twitchHelixFetcherDelay)
 	defer twitchGqlTicker.Stop()
+	var checkpointC <-chan time.Time
+	if params.checkpointer != nil {
+		checkpointTicker := time.NewTicker(params.checkpointer.interval)
+		defer checkpointTicker.Stop()
+		checkpointC = checkpointTicker.C
+		defer params.checkpointer.saveLiveAndWaitVods(liveVodQueue, waitVodQueue)
+	}
 	cursor := ""
 	resetCursorTimeout := time.Now().UTC().Add(params.cursorResetThreshold)
-	debugIndex := -1
-	resetCursor := func() {
-		log.Println(fmt.Sprint("Resetting cursor on debug index: ", debugIndex))
-		debugIndex = -1
+	resetCursor := func(reason string) {
+		slog.Info("resetting cursor", "reason", reason)
+		cursorResets.WithLabelValues(reason).Inc()
 		cursor = ""
 		resetCursorTimeout = time.Now().UTC().Add(params.cursorResetThreshold)
 		_, err := retryOnError(func() (struct{}, error) {
-			return struct{}{}, resetAppAccessToken(params.twitchHelixClient)
+			return struct{}{}, params.twitchHelixClient.ResetAppAccessToken(params.ctx)
 		})
 		if err != nil {
-			log.Println(err)
+			slog.Error("resetting app access token failed", "error", err)
 		}
 	}
-	log.Println("Starting twitchgql infinite for loop.")
 	prevEdges := []helix.Stream{}
-	debugMod := 10
+	paused := false
 	for {
 		// Wait until done are next ticker
 		select {
 		case <-params.ctx.Done():
 			return
+		case <-checkpointC:
+			params.checkpointer.saveLiveAndWaitVods(liveVodQueue, waitVodQueue)
+			continue
+		case request := <-params.adminQueuesCh:
+			request.response.Paused = paused
+			request.response.Live = getQueueSnapshot(liveVodQueue.lastUpdatedToVod, request.limit)
+			request.response.Wait = getQueueSnapshot(waitVodQueue.lastInteractionToVod, request.limit)
+			close(request.done)
+			continue
+		case paused = <-params.pausedCh:
+			continue
 		case <-twitchGqlTicker.C:
+			if paused {
+				continue
+			}
 		}
-		debugIndex++
-		debugQueueSizeStart := liveVodQueue.Size()
+		liveVodQueueSizeStart := liveVodQueue.Size()
 		// Reset cursor if fetching for long time
 		if time.Now().UTC().After(resetCursorTimeout) {
-			log.Println(fmt.Sprint("Reset cursor because we've been fetching for: ", params.cursorResetThreshold))
-			resetCursor()
+			resetCursor("expired")
 		}
 		// Request live streams
 		streams, err := retryOnError(func() (*helix.StreamsResponse, error) {
-			return params.twitchHelixClient.GetStreams(&helix.StreamsParams{
+			return params.twitchHelixClient.GetStreams(params.ctx, &helix.StreamsParams{
 				After: cursor,
 				First: params.numStreamsPerRequest,
 			})
@@ -194,34 +295,21 @@ func fetchTwitchHelixForever(params fetchTwitchHelixForeverParams) {
 		responseReturnedTimeUnix := responseReturnedTime.Unix()
 		// If request failed, reset cursor
 		if err != nil {
-			resetCursor()
-			log.Println(fmt.Sprint("Twitch graphql client reported an error: ", err))
+			slog.Error("getting streams from Twitch Helix failed", "error", err)
+			resetCursor("request_failed")
 			continue
 		}
 		// Get the next cursor and if there are no streams or no next page, reset cursor
 		edges := streams.Data.Streams
 		if len(edges) == 0 {
-			log.Println("edges has length 0")
-			resetCursor()
+			resetCursor("no_streams")
 		} else if streams.Data.Pagination.Cursor == "" {
-			log.Println("streams.Streams.PageInfo does not have next page")
-			resetCursor()
+			resetCursor("no_next_page")
 		} else {
-			if debugIndex%debugMod == 0 {
-				log.Println()
-				log.Println("First and last stream")
-				log.Println(edges[0])
-				log.Println(edges[len(edges)-1])
-				log.Println(fmt.Sprint("Live VOD queue size start: ", debugQueueSizeStart))
-			}
 			if edgeNodesMatchingAndNonEmpty(prevEdges, edges) {
-				log.Println("prevEdges and edges node ids:")
-				log.Println("prevEdges: ", prevEdges)
-				log.Println("edges: ", edges)
-				cursor = streams.Data.Pagination.Cursor
-			} else {
-				cursor = streams.Data.Pagination.Cursor
+				slog.Warn("got the same page of streams twice in a row", "first_stream_id", edges[0].ID, "last_stream_id", edges[len(edges)-1].ID)
 			}
+			cursor = streams.Data.Pagination.Cursor
 		}
 		prevEdges = edges
 		// Remove repeats from wait queue, upsert the min observe view count vods, and insert evicted vods into wait queue
@@ -239,32 +327,29 @@ func fetchTwitchHelixForever(params fetchTwitchHelixForeverParams) {
 			allVodsLessThanMinViewerCount = false
 			waitVod, err := waitVodQueue.GetByStreamIdStartTime(node.ID, node.StartedAt.UTC().Unix())
 			if err == nil {
-				log.Println(fmt.Sprint("Removing vod from wait queue: ", *waitVod))
+				slog.Debug("moving VOD from wait queue back to live queue", "vod", waitVod)
 				waitVodQueue.RemoveVod(waitVod)
 				liveVodQueue.UpsertLiveVod(waitVod)
 			}
-			evictedVod, err := liveVodQueue.UpsertVod(
-				VodDataPoint{Node: node, ResponseReturnedTimeUnix: responseReturnedTimeUnix},
-			)
+			dataPoint := VodDataPoint{Node: node, ResponseReturnedTimeUnix: responseReturnedTimeUnix}
+			evictedVod, err := liveVodQueue.UpsertVod(dataPoint)
+			if params.viewerSampleInterval > 0 {
+				liveVodQueue.ObserveViewers(dataPoint, params.viewerSampleInterval)
+			}
 			if err != nil {
 				continue
 			}
-			log.Println("Streamer restarted stream: ", evictedVod.StreamerLoginAtStart)
+			slog.Info("streamer restarted stream", "vod", evictedVod)
+			streamRestarts.Inc()
 			evictedVod.LastInteractionUnix = responseReturnedTimeUnix
 			waitVodQueue.Put(evictedVod)
 			numRemoved++
 		}
-		if debugIndex%debugMod == 0 {
-			log.Println(fmt.Sprint("Live VOD queue size after upserts: ", liveVodQueue.Size()))
-		}
-		debugNumRestartedStreams := numRemoved
-		if debugNumRestartedStreams > 0 {
-			log.Println(fmt.Sprint("num restarted streams: ", debugNumRestartedStreams))
-		}
+		liveVodQueueSizeAfterUpserts := liveVodQueue.Size()
+		numRestartedStreams := numRemoved
 		// If all vods are below minimum observe view count, reset cursor
 		if allVodsLessThanMinViewerCount {
-			log.Println("All vods less than min viewer count")
-			resetCursor()
+			resetCursor("below_min_viewer_count")
 		}
 		// Evict vods with old last updated time and add vods to wait queue
 		oldestUpdateTimeAllowedUnix := responseReturnedTime.Add(-params.liveVodEvictionThreshold).Unix()
@@ -277,48 +362,26 @@ func fetchTwitchHelixForever(params fetchTwitchHelixForeverParams) {
 				break
 			}
 			liveVodQueue.RemoveVod(stalestVod)
+			vodsEvicted.WithLabelValues("live").Inc()
 			stalestVod.LastInteractionUnix = responseReturnedTimeUnix
 			waitVodQueue.Put(stalestVod)
 			numRemoved++
 		}
-		if debugIndex%debugMod == 0 {
-			log.Println(fmt.Sprint("Live VOD queue size after removing stale VODS: ", liveVodQueue.Size()))
-			log.Println(fmt.Sprint("Wait VOD queue size: ", waitVodQueue.Size()))
+		attrs := []any{
+			"num_streams", len(edges),
+			"live_queue_size_start", liveVodQueueSizeStart,
+			"live_queue_size_after_upserts", liveVodQueueSizeAfterUpserts,
+			"live_queue_size", liveVodQueue.Size(),
+			"wait_queue_size", waitVodQueue.Size(),
+			"num_restarted_streams", numRestartedStreams,
+			"num_stale_vods", numRemoved - numRestartedStreams,
 		}
-		debugNumStaleVods := numRemoved - debugNumRestartedStreams
-		if debugNumStaleVods > 0 {
-			log.Println(fmt.Sprint("num stale vods: ", debugNumStaleVods))
-		}
-		// The queries to delete the old streams and upsert the new streams should be combined into a single transaction
-		requestCtx, requestCancel := context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
-		err = params.queries.DeleteOldStreams(requestCtx, responseReturnedTime.Add(-params.oldVodsDelete))
-		requestCancel()
-		if err != nil {
-			log.Println(fmt.Sprint("deleting old streams failed: ", err))
-			break
+		if len(edges) > 0 {
+			attrs = append(attrs, "first_stream_id", edges[0].ID, "last_stream_id", edges[len(edges)-1].ID)
 		}
-		requestCtx, requestCancel = context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
-		err = params.queries.UpsertManyStreams(requestCtx, twitchGqlResponseUpsertStreamsParams(highViewNodes, responseReturnedTime))
-		requestCancel()
-		if err != nil {
-			log.Println(fmt.Sprint("Upserting streams to streams table failed: ", err))
-			break
-		}
-		requestCtx, requestCancel = context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
-		err = params.queries.DeleteOldStreamers(requestCtx, responseReturnedTime.Add(-params.oldVodsDelete))
-		requestCancel()
-		if err != nil {
-			log.Println(fmt.Sprint("deleting old streamers failed: ", err))
-			break
-		}
-		requestCtx, requestCancel = context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
-		err = params.queries.UpsertManyStreamers(requestCtx, twitchGqlResponseUpsertStreamersParams(highViewNodes))
-		requestCancel()
-		if err != nil {
-			log.Println(fmt.Sprint("Upserting streamers to streamers table failed: ", err))
-			break
-		}
-		// Evict vods with old last interaction time from wait vods queue and record iff at least record view count
+		slog.Debug("polled Twitch Helix", attrs...)
+		// Evict vods with old last interaction time from wait vods queue. Their viewer series are written with this poll.
+		finishedVods := []*LiveVod{}
 		oldestInteractionTimeAllowedUnix := responseReturnedTime.Add(-params.waitVodEvictionThreshold).Unix()
 		for {
 			stalestVod, err := waitVodQueue.GetStalestStream()
@@ -329,32 +392,80 @@ func fetchTwitchHelixForever(params fetchTwitchHelixForeverParams) {
 				break
 			}
 			waitVodQueue.RemoveVod(stalestVod)
-			if stalestVod.MaxViews >= params.minViewerCountToRecord {
-				oldVods = append(oldVods, stalestVod)
+			finishedVods = append(finishedVods, stalestVod)
+		}
+		err = writePolledStreams(params, vodstore.WritePolledStreamsParams{
+			Streams:      twitchGqlResponseUpsertStreamsParams(highViewNodes, responseReturnedTime),
+			Segments:     twitchGqlResponseUpsertStreamSegmentsParams(highViewNodes, responseReturnedTime),
+			Streamers:    twitchGqlResponseUpsertStreamersParams(highViewNodes),
+			ViewerSeries: liveVodsUpdateViewerSeriesParams(finishedVods),
+		})
+		if err != nil {
+			// Put them back so they are evicted again after the next successful write or are in the final checkpoint.
+			for _, finishedVod := range finishedVods {
+				waitVodQueue.Put(finishedVod)
 			}
+			if params.ctx.Err() != nil {
+				return
+			}
+			slog.Error("writing polled streams failed, skipping iteration", "error", err)
+			continue
 		}
+		// Record iff at least record view count
+		for _, finishedVod := range finishedVods {
+			vodsEvicted.WithLabelValues("wait").Inc()
+			if finishedVod.MaxViews >= params.minViewerCountToRecord {
+				oldVods = append(oldVods, finishedVod)
+			}
+		}
+		queueSize.WithLabelValues("live").Set(float64(liveVodQueue.Size()))
+		queueSize.WithLabelValues("wait").Set(float64(waitVodQueue.Size()))
 		// Add old vods to old vods queue
 		select {
 		case <-params.ctx.Done():
+			// Put them back so they are in the final checkpoint. They will be evicted again after a restart.
+			for _, oldVod := range oldVods {
+				waitVodQueue.Put(oldVod)
+			}
 			return
 		case params.oldVodsCh <- oldVods:
 		}
 	}
-	select {
-	case params.done <- struct{}{}:
-	case <-params.ctx.Done():
-	}
 }
 
 type processOldVodJobsParams struct {
-	ctx                 context.Context
-	oldVodsCh           chan []*LiveVod
-	oldVodJobsCh        chan *LiveVod
+	// Jobs are handed out until ctx is done.
+	ctx                context.Context
+	initialOldVodQueue *oldVodsPriorityQueue
+	oldVodsCh          chan []*LiveVod
+	oldVodJobsCh       chan *LiveVod
+	// VODs whose jobs were started but not finished are put back into the queue.
+	handBackCh chan *LiveVod
+	// Closed once nothing can send on handBackCh anymore.
+	// If it is nil, processOldVodJobs returns as soon as ctx is done.
+	drained             chan struct{}
 	maxOldVodsQueueSize int
+	checkpointer        *queueCheckpointer
+	// Snapshots of the old VODs queue for the admin API.
+	adminQueuesCh chan *adminQueuesRequest
 }
 
 func processOldVodJobs(params processOldVodJobsParams) {
-	oldVodsOrderedByViews := CreateNewOldVodQueue()
+	oldVodsOrderedByViews := params.initialOldVodQueue
+	if oldVodsOrderedByViews == nil {
+		oldVodsOrderedByViews = CreateNewOldVodQueue()
+	}
+	for oldVodsOrderedByViews.Size() > params.maxOldVodsQueueSize {
+		oldVodsOrderedByViews.PopLowViewCount()
+		vodsEvicted.WithLabelValues("old").Inc()
+	}
+	var checkpointC <-chan time.Time
+	if params.checkpointer != nil {
+		checkpointTicker := time.NewTicker(params.checkpointer.interval)
+		defer checkpointTicker.Stop()
+		checkpointC = checkpointTicker.C
+		defer params.checkpointer.saveOldVods(oldVodsOrderedByViews)
+	}
 	getJobsCh := func() chan *LiveVod {
 		if oldVodsOrderedByViews.Size() == 0 {
 			return nil
@@ -365,30 +476,194 @@ func processOldVodJobs(params processOldVodJobsParams) {
 		oldVod, _ := oldVodsOrderedByViews.GetLowViewCount()
 		return oldVod
 	}
-	debugCount := -1
+	// Handed back VODs are not subject to maxOldVodsQueueSize, since they already won a place in the queue once.
+	handBack := func(oldVod *LiveVod) {
+		slog.Info("job was handed back", "queue", "old", "vod", oldVod)
+		oldVodsOrderedByViews.Put(oldVod)
+	}
 	for {
-		debugCount++
-		if debugCount%10 == 0 {
-			log.Println(fmt.Sprint("oldVodsOrderedByViews size: ", oldVodsOrderedByViews.Size()))
-		}
+		queueSize.WithLabelValues("old").Set(float64(oldVodsOrderedByViews.Size()))
 		select {
 		case <-params.ctx.Done():
-			return
+			if params.drained == nil {
+				return
+			}
+			for {
+				select {
+				case oldVod := <-params.handBackCh:
+					handBack(oldVod)
+				case <-params.drained:
+					return
+				}
+			}
+		case oldVod := <-params.handBackCh:
+			handBack(oldVod)
 		case oldVods := <-params.oldVodsCh:
 			for _, oldVod := range oldVods {
 				oldVodsOrderedByViews.Put(oldVod)
 				if oldVodsOrderedByViews.Size() > params.maxOldVodsQueueSize {
 					oldVodsOrderedByViews.PopLowViewCount()
+					vodsEvicted.WithLabelValues("old").Inc()
 				}
 			}
 		case getJobsCh() <- getNextInQueue():
 			oldVodsOrderedByViews.PopLowViewCount()
+		case <-checkpointC:
+			params.checkpointer.saveOldVods(oldVodsOrderedByViews)
+		case request := <-params.adminQueuesCh:
+			request.response.Old = getQueueSnapshot(oldVodsOrderedByViews.tree, request.limit)
+			close(request.done)
+		}
+	}
+}
+
+type processRetryVodsParams struct {
+	// Retries are scheduled and the database is swept until ctx is done.
+	ctx                  context.Context
+	initialRetryVodQueue *retryVodsPriorityQueue
+	// VODs whose .m3u8 was not found.
+	retryVodsCh chan *LiveVod
+	// VODs that are due are handed to the HLS workers here. They don't go through the old VODs queue,
+	// since it evicts its least viewed VODs when it is full, and an evicted retry would never be retried again.
+	retryJobsCh chan *LiveVod
+	// Closed once nothing can send on retryVodsCh anymore.
+	drained             chan struct{}
+	store               vodstore.Store
+	sqlRequestTimeLimit time.Duration
+	retryBaseDelay      time.Duration
+	retryMaxAge         time.Duration
+	sweepInterval       time.Duration
+	sweepLimit          int
+	checkpointer        *queueCheckpointer
+	// Snapshots of the retry queue for the admin API.
+	adminQueuesCh chan *adminQueuesRequest
+}
+
+// Schedules VODs whose .m3u8 was not found for another search with exponential backoff.
+// There are outages where the .m3u8 of many public VODs can't be found, so the database is also swept for public VODs without bytes.
+// That finds the VODs that failed before a restart.
+func processRetryVods(params processRetryVodsParams) {
+	retryVods := params.initialRetryVodQueue
+	if retryVods == nil {
+		retryVods = CreateNewRetryVodsPriorityQueue()
+	}
+	var checkpointC <-chan time.Time
+	if params.checkpointer != nil {
+		checkpointTicker := time.NewTicker(params.checkpointer.interval)
+		defer checkpointTicker.Stop()
+		checkpointC = checkpointTicker.C
+		defer params.checkpointer.saveRetryVods(retryVods)
+	}
+	// A VOD handed to an HLS worker is still found by the sweep until its next attempt is written.
+	// This maps it to the attempts it had when it was handed out, so the sweep can skip it.
+	dispatched := map[streamIdStartTime]int{}
+	schedule := func(vod *LiveVod) {
+		if time.Since(time.Unix(vod.LastUpdatedUnix, 0)) > params.retryMaxAge {
+			slog.Info("giving up on finding .m3u8", "queue", "retry", "vod", vod)
+			return
+		}
+		retryVods.Put(vod, vod.getNextHlsFetchUnix(params.retryBaseDelay))
+	}
+	sweep := func() {
+		requestCtx, requestCancel := context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
+		streams, err := params.store.GetStreamsToRetry(requestCtx, sqlvods.GetStreamsToRetryParams{
+			LastUpdatedAt: time.Now().UTC().Add(-params.retryMaxAge),
+			Limit:         int32(params.sweepLimit),
+		})
+		requestCancel()
+		if err != nil {
+			slog.Error("sweeping for streams to retry failed", "error", err)
+			return
+		}
+		numScheduled := 0
+		seen := map[streamIdStartTime]struct{}{}
+		for _, stream := range streams {
+			id := streamIdStartTime{streamId: stream.StreamID, startTimeUnix: stream.StartTime.UTC().Unix()}
+			seen[id] = struct{}{}
+			attempts, ok := dispatched[id]
+			if ok && int(stream.HlsFetchAttempts) <= attempts {
+				continue
+			}
+			if retryVods.Contains(id.streamId, id.startTimeUnix) {
+				continue
+			}
+			schedule(&LiveVod{
+				StreamerId:           stream.StreamerID,
+				StreamId:             stream.StreamID,
+				StartTimeUnix:        id.startTimeUnix,
+				StreamerLoginAtStart: stream.StreamerLoginAtStart,
+				GameIdAtStart:        stream.GameIDAtStart,
+				MaxViews:             int(stream.MaxViews),
+				LastUpdatedUnix:      stream.LastUpdatedAt.UTC().Unix(),
+				LastInteractionUnix:  stream.LastUpdatedAt.UTC().Unix(),
+				HlsFetchAttempts:     int(stream.HlsFetchAttempts),
+				LastHlsFetchUnix:     stream.RecordingFetchedAt.Time.UTC().Unix(),
+			})
+			numScheduled++
+		}
+		// Dispatched VODs that weren't found were either found or given up on.
+		for id := range dispatched {
+			if _, ok := seen[id]; !ok {
+				delete(dispatched, id)
+			}
+		}
+		slog.Info("swept for streams to retry", "queue", "retry", "num_scheduled", numScheduled, "size", retryVods.Size())
+	}
+	sweep()
+	sweepTicker := time.NewTicker(params.sweepInterval)
+	defer sweepTicker.Stop()
+	dueInterval := params.retryBaseDelay
+	if dueInterval > time.Minute {
+		dueInterval = time.Minute
+	}
+	dueTicker := time.NewTicker(dueInterval)
+	defer dueTicker.Stop()
+	getJobsCh := func() chan *LiveVod {
+		_, nextAttemptUnix, err := retryVods.GetEarliest()
+		if err != nil || nextAttemptUnix > time.Now().UTC().Unix() {
+			return nil
+		}
+		return params.retryJobsCh
+	}
+	getNextInQueue := func() *LiveVod {
+		vod, _, _ := retryVods.GetEarliest()
+		return vod
+	}
+	for {
+		queueSize.WithLabelValues("retry").Set(float64(retryVods.Size()))
+		select {
+		case <-params.ctx.Done():
+			for {
+				select {
+				case vod := <-params.retryVodsCh:
+					schedule(vod)
+				case <-params.drained:
+					return
+				}
+			}
+		case vod := <-params.retryVodsCh:
+			delete(dispatched, streamIdStartTime{streamId: vod.StreamId, startTimeUnix: vod.StartTimeUnix})
+			schedule(vod)
+		case <-sweepTicker.C:
+			sweep()
+		case <-checkpointC:
+			params.checkpointer.saveRetryVods(retryVods)
+		case request := <-params.adminQueuesCh:
+			snapshot := getQueueSnapshot(retryVods.nextAttemptToVod, request.limit)
+			request.response.Retry = &snapshot
+			close(request.done)
+		case getJobsCh() <- getNextInQueue():
+			vod := getNextInQueue()
+			retryVods.RemoveVod(vod)
+			dispatched[streamIdStartTime{streamId: vod.StreamId, startTimeUnix: vod.StartTimeUnix}] = vod.HlsFetchAttempts
+		case <-dueTicker.C:
+			// Wakes up the loop, so VODs that became due are handed out.
 		}
 	}
 }
 
-func getFirstValidDwpResponse(ctx context.Context, videoData *vods.VideoData, toUnix bool, client *http.Client) (*vods.ValidDwpResponse, error) {
-	dwp, err := vods.GetFirstValidDwp(ctx, videoData.GetDomainWithPathsList(vods.DOMAINS, 1, toUnix), client)
+func getFirstValidDwpResponse(ctx context.Context, videoData *vods.VideoData, domains []string, toUnix bool, client *http.Client) (*vods.ValidDwpResponse, error) {
+	dwp, err := vods.GetFirstValidDwp(ctx, videoData.GetDomainWithPathsList(domains, 1, toUnix), client)
 	if err != nil {
 		return nil, err
 	}
@@ -414,51 +689,80 @@ type vodCompressedBytesResult struct {
 	compressedBytes []byte
 	dwp             *vods.DomainWithPath
 	duration        time.Duration
+	renditions      []string
 }
 
-func getValidDwp(ctx context.Context, videoData *vods.VideoData, client *http.Client) (*vods.ValidDwpResponse, error) {
-	dwp, err := getFirstValidDwpResponse(ctx, videoData, true, client)
+// Tries the unix time path, then the unix time minus one second path, then the non-unix path.
+// See NOTES.md for why the minus one fallback exists.
+func getValidDwp(ctx context.Context, videoData *vods.VideoData, domains []string, client *http.Client) (*vods.ValidDwpResponse, error) {
+	dwp, err := getFirstValidDwpResponse(ctx, videoData, domains, true, client)
 	if err == nil {
+		hlsFound.WithLabelValues(dwp.Dwp.Domain, "unix").Inc()
 		return dwp, nil
 	}
 	dwp, err = getFirstValidDwpResponse(ctx, &vods.VideoData{
 		StreamerName: videoData.StreamerName,
 		VideoId:      videoData.VideoId,
 		Time:         videoData.Time.Add(-time.Second),
-	}, true, client)
+	}, domains, true, client)
 	if err == nil {
-		log.Println(fmt.Sprint("minus 1 success for ", *videoData))
+		slog.Debug("found .m3u8 with unix time minus one", "stream_id", videoData.VideoId, "streamer_login", videoData.StreamerName, "domain", dwp.Dwp.Domain)
+		hlsFound.WithLabelValues(dwp.Dwp.Domain, "minus_one").Inc()
 		return dwp, nil
 	}
-	dwp, err = getFirstValidDwpResponse(ctx, videoData, false, client)
+	dwp, err = getFirstValidDwpResponse(ctx, videoData, domains, false, client)
 	if err == nil {
-		log.Println(fmt.Sprint("non-unix success for ", *videoData))
+		slog.Debug("found .m3u8 with non-unix time", "stream_id", videoData.VideoId, "streamer_login", videoData.StreamerName, "domain", dwp.Dwp.Domain)
+		hlsFound.WithLabelValues(dwp.Dwp.Domain, "non_unix").Inc()
 		return dwp, nil
 	}
 	return dwp, err
 }
 
-func getVodCompressedBytes(ctx context.Context, videoData *vods.VideoData, compressor *zstd.Encoder, client *http.Client) (*vodCompressedBytesResult, error) {
-	dwp, err := getValidDwp(ctx, videoData, client)
+func getVodCompressedBytes(ctx context.Context, videoData *vods.VideoData, domains []string, compressor *zstd.Encoder, client *http.Client) (*vodCompressedBytesResult, error) {
+	dwp, err := getValidDwp(ctx, videoData, domains, client)
 	if err != nil {
-		log.Println(fmt.Sprint("Link was not found for ", videoData.StreamerName, " because: ", err))
+		slog.Info(".m3u8 was not found", "stream_id", videoData.VideoId, "streamer_login", videoData.StreamerName, "error", err)
 		return nil, err
 	}
 	mediapl, err := getCleanedMediaPlaylistBytes(dwp)
 	if err != nil {
-		log.Println(fmt.Sprint("Removing nil segments for ", videoData.StreamerName, " failed because: ", err))
-		log.Println(mediapl.String())
+		slog.Warn("decoding .m3u8 failed", "stream_id", videoData.VideoId, "streamer_login", videoData.StreamerName, "domain", dwp.Dwp.Domain, "error", err)
 		return nil, err
 	}
 	duration := vods.GetMediaPlaylistDuration(mediapl)
 	compressedBytes := getCompressedBytes(mediapl.Encode().Bytes(), compressor)
-	return &vodCompressedBytesResult{compressedBytes: compressedBytes, dwp: dwp.Dwp, duration: duration}, nil
+	renditionNames := probeRenditions(ctx, dwp.Dwp, client)
+	return &vodCompressedBytesResult{compressedBytes: compressedBytes, dwp: dwp.Dwp, duration: duration, renditions: renditionNames}, nil
+}
+
+// Returns the source and every other rendition whose playlist exists on the same domain and path.
+// Only the names are stored, since the other renditions have the same segments as the source.
+func probeRenditions(ctx context.Context, dwp *vods.DomainWithPath, client *http.Client) []string {
+	found := []string{renditions.Source}
+	for _, rendition := range renditions.All {
+		if rendition.Name == renditions.Source {
+			continue
+		}
+		exists, err := existsAt(ctx, renditions.Url(dwp.GetIndexDvrUrl(), rendition.Name), client)
+		if err != nil {
+			slog.Debug("probing rendition failed", "domain", dwp.Domain, "path", dwp.Path, "rendition", rendition.Name, "error", err)
+			continue
+		}
+		if exists {
+			found = append(found, rendition.Name)
+			hlsRenditionsFound.WithLabelValues(rendition.Name).Inc()
+		}
+	}
+	return found
 }
 
 type videoStatus struct {
 	public          sql.NullBool
 	boxArtUrl       sql.NullString
 	profileImageUrl sql.NullString
+	// id of the archived video of the stream, empty unless public
+	videoId string
 }
 
 func SetBoxArtWidthHeight(boxArtUrl string, width int, height int) string {
@@ -469,20 +773,23 @@ func SetProfileImageWidth(profileImageUrl string, width int) string {
 	return strings.Replace(profileImageUrl, "-300x300.png", fmt.Sprint("-", width, "x", width, ".png"), 1)
 }
 
-func getVideoStatus(client *helix.Client, streamerId string, streamId string, gameId string) videoStatus {
+// The requests are abandoned once ctx is done, which leaves the rest of the status invalid.
+func getVideoStatus(ctx context.Context, client StreamSource, streamerId string, streamId string, gameId string) videoStatus {
 	var public sql.NullBool
+	videoId := ""
 	{
 		response, err := retryOnError(func() (*helix.VideosResponse, error) {
-			return client.GetVideos(&helix.VideosParams{UserID: streamerId})
+			return client.GetVideos(ctx, &helix.VideosParams{UserID: streamerId})
 		})
 		if err != nil {
-			log.Println(fmt.Sprint("error getting user data for (", streamerId, ", ", streamId, "): ", err))
+			slog.Warn("getting videos failed", "streamer_id", streamerId, "stream_id", streamId, "error", err)
 		} else {
 			public = sql.NullBool{Valid: true, Bool: false}
 			videos := response.Data.Videos
 			for _, cur := range videos {
 				if cur.StreamID == streamId {
 					public = sql.NullBool{Valid: true, Bool: true}
+					videoId = cur.ID
 					break
 				}
 			}
@@ -491,10 +798,10 @@ func getVideoStatus(client *helix.Client, streamerId string, streamId string, ga
 	}
 	var profileImageUrl sql.NullString
 	{
-		usersResponse, _ := retryOnError(func() (*helix.UsersResponse, error) {
-			return client.GetUsers(&helix.UsersParams{IDs: []string{streamerId}})
+		usersResponse, err := retryOnError(func() (*helix.UsersResponse, error) {
+			return client.GetUsers(ctx, &helix.UsersParams{IDs: []string{streamerId}})
 		})
-		if len(usersResponse.Data.Users) > 0 {
+		if err == nil && len(usersResponse.Data.Users) > 0 {
 			profileImageUrlStr := usersResponse.Data.Users[0].ProfileImageURL
 			profileImageUrlStr = SetProfileImageWidth(profileImageUrlStr, 50)
 			profileImageUrl = sql.NullString{Valid: true, String: profileImageUrlStr}
@@ -502,10 +809,10 @@ func getVideoStatus(client *helix.Client, streamerId string, streamId string, ga
 	}
 	var boxArtUrl sql.NullString
 	{
-		gamesResponse, _ := retryOnError(func() (*helix.GamesResponse, error) {
-			return client.GetGames(&helix.GamesParams{IDs: []string{gameId}})
+		gamesResponse, err := retryOnError(func() (*helix.GamesResponse, error) {
+			return client.GetGames(ctx, &helix.GamesParams{IDs: []string{gameId}})
 		})
-		if len(gamesResponse.Data.Games) > 0 {
+		if err == nil && len(gamesResponse.Data.Games) > 0 {
 			boxArtUrlStr := gamesResponse.Data.Games[0].BoxArtURL
 			boxArtUrlStr = SetBoxArtWidthHeight(boxArtUrlStr, 40, 56)
 			boxArtUrl = sql.NullString{Valid: true, String: boxArtUrlStr}
@@ -513,20 +820,28 @@ func getVideoStatus(client *helix.Client, streamerId string, streamId string, ga
 	}
 	return videoStatus{
 		public:          public,
+		videoId:         videoId,
 		profileImageUrl: profileImageUrl,
 		boxArtUrl:       boxArtUrl,
 	}
 }
 
 type hlsWorkerFetchCompressSendParams struct {
-	ctx               context.Context
-	twitchHelixClient *helix.Client
+	// No new jobs are taken once ctx is done.
+	ctx context.Context
+	// The current job is abandoned and handed back once workCtx is done.
+	workCtx           context.Context
+	handBackCh        chan *LiveVod
+	twitchHelixClient StreamSource
 	httpClient        *http.Client
+	domains           []string
 	oldVodJobsCh      chan *LiveVod
-	hlsFetcherDelay   time.Duration
-	compressor        *zstd.Encoder
-	resultsCh         chan *VodResult
-	requestTimeLimit  time.Duration
+	// If it is nil, there are no retries.
+	retryJobsCh      chan *LiveVod
+	hlsFetcherDelay  time.Duration
+	compressor       *zstd.Encoder
+	resultsCh        chan *VodResult
+	requestTimeLimit time.Duration
 }
 
 func hlsWorkerFetchCompressSend(params hlsWorkerFetchCompressSendParams) {
@@ -543,11 +858,18 @@ func hlsWorkerFetchCompressSend(params hlsWorkerFetchCompressSendParams) {
 		case <-params.ctx.Done():
 			return
 		case oldVod = <-params.oldVodJobsCh:
+		case oldVod = <-params.retryJobsCh:
 		}
 		requestInitiated := time.Now().UTC()
-		compressedBytesResult, err := getVodCompressedBytes(params.ctx, oldVod.GetVideoData(), params.compressor, params.httpClient)
+		compressedBytesResult, err := getVodCompressedBytes(params.workCtx, oldVod.GetVideoData(), params.domains, params.compressor, params.httpClient)
+		if params.workCtx.Err() != nil {
+			// The fetch may have failed because it was canceled, so the result can't be trusted.
+			params.handBackCh <- oldVod
+			return
+		}
 		var result *VodResult
 		if err != nil {
+			hlsFetches.WithLabelValues("not_found").Inc()
 			result = &VodResult{
 				Vod:              oldVod,
 				HlsBytes:         nil,
@@ -561,8 +883,14 @@ func hlsWorkerFetchCompressSend(params hlsWorkerFetchCompressSendParams) {
 					Float64: 0.0,
 					Valid:   false,
 				},
+				HlsError: sql.NullString{
+					String: err.Error(),
+					Valid:  true,
+				},
 			}
 		} else {
+			hlsFetches.WithLabelValues("found").Inc()
+			hlsCompressedBytes.Observe(float64(len(compressedBytesResult.compressedBytes)))
 			result = &VodResult{
 				Vod:              oldVod,
 				HlsBytes:         compressedBytesResult.compressedBytes,
@@ -576,73 +904,134 @@ func hlsWorkerFetchCompressSend(params hlsWorkerFetchCompressSendParams) {
 					Float64: compressedBytesResult.duration.Seconds(),
 					Valid:   true,
 				},
+				Renditions: compressedBytesResult.renditions,
 			}
 		}
-		videoMeta := getVideoStatus(params.twitchHelixClient, oldVod.StreamerId, oldVod.StreamId, oldVod.GameIdAtStart)
+		videoMeta := getVideoStatus(params.workCtx, params.twitchHelixClient, oldVod.StreamerId, oldVod.StreamId, oldVod.GameIdAtStart)
+		if params.workCtx.Err() != nil {
+			params.handBackCh <- oldVod
+			return
+		}
 		result.Public = videoMeta.public
 		result.BoxArtUrl = videoMeta.boxArtUrl
 		result.ProfileImageUrl = videoMeta.profileImageUrl
+		if compressedBytesResult != nil {
+			previews := getSeekPreviews(params.workCtx, compressedBytesResult.dwp, videoMeta.videoId, params.httpClient)
+			result.ThumbnailUrl = previews.thumbnailUrl
+			result.SeekPreviewsUrl = previews.infoUrl
+			result.SeekPreviewSpriteUrls = previews.spriteUrls
+		}
 		select {
-		case <-params.ctx.Done():
+		case <-params.workCtx.Done():
+			params.handBackCh <- oldVod
 			return
 		case params.resultsCh <- result:
 		}
 	}
 }
 
-func processVodResults(ctx context.Context, resultsCh chan *VodResult, done chan struct{}, queries *sqlvods.Queries) {
-	for {
-		var result *VodResult
-		select {
-		case result = <-resultsCh:
-		case <-ctx.Done():
-		}
-		if result == nil {
-			log.Println("ctx is done meaning result is nil, so breaking")
-			break
-		}
-		log.Println("Result was logged:")
-		log.Println(*result.Vod)
-		log.Println(fmt.Sprint("Gzipped size: ", len(result.HlsBytes)))
-		upsertRecordingParams := sqlvods.UpdateRecordingParams{
-			RecordingFetchedAt:     sql.NullTime{Time: result.RequestInitiated, Valid: true},
-			GzippedBytes:           result.HlsBytes,
+type processVodResultsParams struct {
+	// A failed write is reported on done while ctx is not done.
+	ctx context.Context
+	// Writes are canceled once workCtx is done.
+	workCtx context.Context
+	// Results are written until resultsCh is closed.
+	resultsCh chan *VodResult
+	// VODs whose results could not be written are handed back to the old VODs queue.
+	handBackCh chan *LiveVod
+	// VODs whose .m3u8 was not found are sent here to be retried. If it is nil, they are not retried.
+	retryVodsCh chan *LiveVod
+	done        chan struct{}
+	store       vodstore.Store
+}
+
+// Writes the result of a search for the .m3u8 of a VOD and reports whether the VOD should be retried.
+// A failed search only writes the metadata, so a recording that was found before, e.g. by a forced refetch
+// through the admin API during an outage, is kept and not retried.
+func writeVodResult(ctx context.Context, store vodstore.Store, result *VodResult) (bool, error) {
+	startTime := time.Unix(result.Vod.StartTimeUnix, 0).UTC()
+	if !result.HlsBytesFound {
+		bytesFound, err := store.UpdateRecordingNotFound(ctx, sqlvods.UpdateRecordingNotFoundParams{
 			StreamID:               result.Vod.StreamId,
-			BytesFound:             sql.NullBool{Bool: result.HlsBytesFound, Valid: true},
-			HlsDomain:              result.HlsDomain,
+			StartTime:              startTime,
+			RecordingFetchedAt:     sql.NullTime{Time: result.RequestInitiated, Valid: true},
 			Public:                 result.Public,
-			HlsDurationSeconds:     result.HlsDurationSeconds,
 			ProfileImageUrlAtStart: result.ProfileImageUrl,
 			BoxArtUrlAtStart:       result.BoxArtUrl,
-			StartTime:              time.Unix(result.Vod.StartTimeUnix, 0).UTC(),
+			HlsLastError:           result.HlsError,
+		})
+		// No rows means the stream was deleted by the retention job.
+		return err == nil && len(bytesFound) > 0 && !bytesFound[0].Bool, err
+	}
+	return false, store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+		RecordingFetchedAt:     sql.NullTime{Time: result.RequestInitiated, Valid: true},
+		GzippedBytes:           result.HlsBytes,
+		StreamID:               result.Vod.StreamId,
+		BytesFound:             sql.NullBool{Bool: true, Valid: true},
+		HlsDomain:              result.HlsDomain,
+		Public:                 result.Public,
+		HlsDurationSeconds:     result.HlsDurationSeconds,
+		ProfileImageUrlAtStart: result.ProfileImageUrl,
+		BoxArtUrlAtStart:       result.BoxArtUrl,
+		StartTime:              startTime,
+		HlsLastError:           result.HlsError,
+		Renditions:             result.Renditions,
+		ThumbnailUrl:           result.ThumbnailUrl,
+		SeekPreviewsUrl:        result.SeekPreviewsUrl,
+		SeekPreviewSpriteUrls:  result.SeekPreviewSpriteUrls,
+	})
+}
+
+func processVodResults(params processVodResultsParams) {
+	failed := false
+	for result := range params.resultsCh {
+		if failed {
+			params.handBackCh <- result.Vod
+			continue
 		}
-		err := queries.UpdateRecording(ctx, upsertRecordingParams)
+		slog.Info("writing result", "vod", result.Vod, "bytes_found", result.HlsBytesFound, "domain", result.HlsDomain.String, "compressed_size", len(result.HlsBytes))
+		retry, err := writeVodResult(params.workCtx, params.store, result)
 		if err != nil {
-			log.Println(fmt.Sprint("upserting recording failed: ", err))
-			break
-		}
-		updateStreamerParams := sqlvods.UpdateStreamerParams{
-			StreamerLoginAtStart:   result.Vod.StreamerLoginAtStart,
-			ProfileImageUrlAtStart: result.ProfileImageUrl,
+			slog.Error("updating recording failed", "vod", result.Vod, "error", err)
+			params.handBackCh <- result.Vod
+		} else {
+			result.Vod.HlsFetchAttempts++
+			result.Vod.LastHlsFetchUnix = result.RequestInitiated.Unix()
+			if retry && params.retryVodsCh != nil {
+				params.retryVodsCh <- result.Vod
+			}
+			updateStreamerParams := sqlvods.UpdateStreamerParams{
+				StreamerLoginAtStart:   result.Vod.StreamerLoginAtStart,
+				ProfileImageUrlAtStart: result.ProfileImageUrl,
+			}
+			err = params.store.UpdateStreamer(params.workCtx, updateStreamerParams)
+			if err != nil {
+				slog.Error("updating streamer failed", "streamer_login", result.Vod.StreamerLoginAtStart, "error", err)
+			}
 		}
-		err = queries.UpdateStreamer(ctx, updateStreamerParams)
 		if err != nil {
-			log.Println(fmt.Sprint("updating streamer failed: ", err))
-			break
+			// Keep draining resultsCh so the workers never block, but stop writing.
+			failed = true
+			select {
+			case <-params.ctx.Done():
+			case params.done <- struct{}{}:
+			}
 		}
 	}
-	select {
-	case <-ctx.Done():
-	case done <- struct{}{}:
-	}
 }
 
 type ScrapeTwitchLiveVodsWithGqlApiParams struct {
 	RunScraperParams
-	// initial live vod queue fetched from database
+	// initial live vod queue restored from the checkpoint. If nil, the queue starts empty.
+	InitialLiveVodQueue *liveVodsPriorityQueue
+	// initial wait vod queue restored from the checkpoint or fetched from database
 	InitialWaitVodQueue *waitVodsPriorityQueue
-	// sqlc queries instance
-	Queries *sqlvods.Queries
+	// initial old vod queue restored from the checkpoint. If nil, the queue starts empty.
+	InitialOldVodQueue *oldVodsPriorityQueue
+	// initial retry vod queue restored from the checkpoint. If nil, the queue starts empty.
+	InitialRetryVodQueue *retryVodsPriorityQueue
+	// database the scraper reads from and writes to
+	Store vodstore.Store
 }
 
 func makeRobustHttpClient(timeout time.Duration) *http.Client {
@@ -660,36 +1049,99 @@ func makeRobustHttpClient(timeout time.Duration) *http.Client {
 // This function scrapes the Twitch Graphql API and fetches .m3u8 files for streams that finish.
 // It doesn't exit if a Twitch Graphql API request fails.
 // Instead, it resets the cursor and starts over.
-// It stores the results in a database with concurrent updates, so you should use a queries struct that is safe for that.
-// If any database query or modification returns an error, the function finishes and cleans up all resources.
+// It stores the results in a database with concurrent updates, so you should use a store that is safe for that.
+// If writing the polled streams fails, the iteration is skipped. If writing a recording fails, the function finishes and cleans up all resources.
+// When ctx is done or writing a recording fails, it stops polling and drains in-flight work for at most params.DrainTimeout before returning.
 func ScrapeTwitchLiveVodsWithGqlApi(ctx context.Context, params ScrapeTwitchLiveVodsWithGqlApiParams) error {
-	log.Println("Starting scraping...")
+	slog.Info("starting scraper")
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	httpClient := makeRobustHttpClient(params.RequestTimeLimit)
-	twitchHelixClient, err := helix.NewClient(&helix.Options{
-		ClientID:     params.ClientId,
-		ClientSecret: params.ClientSecret,
-		HTTPClient:   httpClient,
-	})
-	if err != nil {
-		return err
+	httpClient := params.HttpClient
+	if httpClient == nil {
+		httpClient = makeRobustHttpClient(params.RequestTimeLimit)
+	}
+	hlsDomains := params.HlsDomains
+	if len(hlsDomains) == 0 {
+		hlsDomains = vods.DOMAINS
 	}
-	_, err = retryOnError(func() (struct{}, error) {
-		return struct{}{}, resetAppAccessToken(twitchHelixClient)
+	twitchHelixClient := params.StreamSource
+	if twitchHelixClient == nil {
+		helixClient, err := NewHelixStreamSource(params.ClientId, params.ClientSecret, httpClient)
+		if err != nil {
+			return err
+		}
+		twitchHelixClient = helixClient
+	}
+	twitchHelixClient = &instrumentedStreamSource{StreamSource: twitchHelixClient}
+	store := instrumentedStore{Store: params.Store}
+	_, err := retryOnError(func() (struct{}, error) {
+		return struct{}{}, twitchHelixClient.ResetAppAccessToken(ctx)
 	})
 	if err != nil {
 		return err
 	}
+	liveVodQueue := params.InitialLiveVodQueue
+	if liveVodQueue == nil {
+		liveVodQueue = CreateNewLiveVodsPriorityQueue()
+	}
+	oldVodQueue := params.InitialOldVodQueue
+	if oldVodQueue == nil {
+		oldVodQueue = CreateNewOldVodQueue()
+	}
+	retryVodQueue := params.InitialRetryVodQueue
+	if retryVodQueue == nil {
+		retryVodQueue = CreateNewRetryVodsPriorityQueue()
+	}
+	var checkpointer *queueCheckpointer
+	if params.CheckpointPath != "" {
+		checkpointInterval := params.CheckpointInterval
+		if checkpointInterval <= 0 {
+			checkpointInterval = time.Minute
+		}
+		checkpointer = newQueueCheckpointer(params.CheckpointPath, checkpointInterval, liveVodQueue, params.InitialWaitVodQueue, oldVodQueue, retryVodQueue)
+	}
 	oldVodsCh := make(chan []*LiveVod)
 	oldVodJobsCh := make(chan *LiveVod)
+	handBackCh := make(chan *LiveVod)
+	var retryVodsCh, retryJobsCh chan *LiveVod
+	if params.HlsRetryBaseDelay > 0 {
+		retryVodsCh = make(chan *LiveVod)
+		retryJobsCh = make(chan *LiveVod)
+	}
 	resultsCh := make(chan *VodResult)
+	drained := make(chan struct{})
 	done := make(chan struct{})
-	log.Println("Made twitchgql client and channels.")
-	go fetchTwitchHelixForever(
-		fetchTwitchHelixForeverParams{
+	// With no admin API, the nil channels are never ready.
+	adminChannels := &adminChannels{}
+	if params.AdminAddr != "" {
+		adminChannels = makeAdminChannels(retryVodsCh != nil)
+		adminChannels.oldVodJobsCh = oldVodJobsCh
+	}
+	compressors := []*zstd.Encoder{}
+	for i := 0; i < params.NumHlsFetchers; i++ {
+		compressor, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			for _, compressor := range compressors {
+				compressor.Close()
+			}
+			return err
+		}
+		compressors = append(compressors, compressor)
+	}
+	// Shutdown has two phases.
+	// First, ctx is canceled, which stops polling Twitch Helix and handing out jobs.
+	// Then the HLS workers finish their current jobs and the results are written until workCtx is canceled at the drain deadline.
+	// Jobs that don't finish are handed back to the old VODs queue, so they are in its final checkpoint.
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+	var queueOwners, workers, resultsWriter sync.WaitGroup
+	queueOwners.Add(2)
+	go func() {
+		defer queueOwners.Done()
+		fetchTwitchHelixForever(fetchTwitchHelixForeverParams{
 			ctx:                      ctx,
 			twitchHelixClient:        twitchHelixClient,
+			initialLiveVodQueue:      liveVodQueue,
 			initialWaitVodQueue:      params.InitialWaitVodQueue,
 			sqlRequestTimeLimit:      params.RequestTimeLimit,
 			twitchHelixFetcherDelay:  params.TwitchHelixFetcherDelay,
@@ -699,39 +1151,178 @@ func ScrapeTwitchLiveVodsWithGqlApi(ctx context.Context, params ScrapeTwitchLive
 			oldVodsCh:                oldVodsCh,
 			minViewerCountToObserve:  params.MinViewerCountToObserve,
 			minViewerCountToRecord:   params.MinViewerCountToRecord,
-			queries:                  params.Queries,
+			store:                    store,
 			numStreamsPerRequest:     params.NumStreamsPerRequest,
-			oldVodsDelete:            params.OldVodsDelete,
-			done:                     done,
-		},
-	)
-	go processOldVodJobs(processOldVodJobsParams{
-		ctx:                 ctx,
-		oldVodsCh:           oldVodsCh,
-		oldVodJobsCh:        oldVodJobsCh,
-		maxOldVodsQueueSize: params.MaxOldVodsQueueSize,
-	})
-	for i := 0; i < params.NumHlsFetchers; i++ {
-		compressor, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
-		if err != nil {
-			return err
-		}
-		go hlsWorkerFetchCompressSend(hlsWorkerFetchCompressSendParams{
-			ctx:               ctx,
-			twitchHelixClient: twitchHelixClient,
-			httpClient:        httpClient,
-			oldVodJobsCh:      oldVodJobsCh,
-			hlsFetcherDelay:   params.HlsFetcherDelay,
-			compressor:        compressor,
-			resultsCh:         resultsCh,
-			requestTimeLimit:  params.RequestTimeLimit,
+			checkpointer:             checkpointer,
+			adminQueuesCh:            adminChannels.liveAndWaitVodsCh,
+			pausedCh:                 adminChannels.pausedCh,
+			viewerSampleInterval:     params.ViewerSampleInterval,
+		})
+	}()
+	go func() {
+		defer queueOwners.Done()
+		processOldVodJobs(processOldVodJobsParams{
+			ctx:                 ctx,
+			initialOldVodQueue:  oldVodQueue,
+			oldVodsCh:           oldVodsCh,
+			oldVodJobsCh:        oldVodJobsCh,
+			handBackCh:          handBackCh,
+			drained:             drained,
+			maxOldVodsQueueSize: params.MaxOldVodsQueueSize,
+			checkpointer:        checkpointer,
+			adminQueuesCh:       adminChannels.oldVodsCh,
+		})
+	}()
+	if retryVodsCh != nil {
+		queueOwners.Add(1)
+		go func() {
+			defer queueOwners.Done()
+			processRetryVods(processRetryVodsParams{
+				ctx:                  ctx,
+				initialRetryVodQueue: retryVodQueue,
+				retryVodsCh:          retryVodsCh,
+				retryJobsCh:          retryJobsCh,
+				drained:              drained,
+				store:                store,
+				sqlRequestTimeLimit:  params.RequestTimeLimit,
+				retryBaseDelay:       params.HlsRetryBaseDelay,
+				retryMaxAge:          params.HlsRetryMaxAge,
+				sweepInterval:        params.HlsRetrySweepInterval,
+				sweepLimit:           params.HlsRetrySweepLimit,
+				checkpointer:         checkpointer,
+				adminQueuesCh:        adminChannels.retryVodsCh,
+			})
+		}()
+	}
+	for _, compressor := range compressors {
+		workers.Add(1)
+		go func(compressor *zstd.Encoder) {
+			defer workers.Done()
+			hlsWorkerFetchCompressSend(hlsWorkerFetchCompressSendParams{
+				ctx:               ctx,
+				workCtx:           workCtx,
+				handBackCh:        handBackCh,
+				twitchHelixClient: twitchHelixClient,
+				httpClient:        httpClient,
+				domains:           hlsDomains,
+				oldVodJobsCh:      oldVodJobsCh,
+				retryJobsCh:       retryJobsCh,
+				hlsFetcherDelay:   params.HlsFetcherDelay,
+				compressor:        compressor,
+				resultsCh:         resultsCh,
+				requestTimeLimit:  params.RequestTimeLimit,
+			})
+		}(compressor)
+	}
+	resultsWriter.Add(1)
+	go func() {
+		defer resultsWriter.Done()
+		processVodResults(processVodResultsParams{
+			ctx:         ctx,
+			workCtx:     workCtx,
+			resultsCh:   resultsCh,
+			handBackCh:  handBackCh,
+			retryVodsCh: retryVodsCh,
+			done:        done,
+			store:       store,
 		})
+	}()
+	retentionInterval := params.RetentionInterval
+	if retentionInterval <= 0 {
+		retentionInterval = 10 * time.Minute
+	}
+	retentionBatchSize := params.RetentionBatchSize
+	if retentionBatchSize <= 0 {
+		retentionBatchSize = 1000
+	}
+	var retention sync.WaitGroup
+	retention.Add(1)
+	go func() {
+		defer retention.Done()
+		runRetention(runRetentionParams{
+			ctx:                 ctx,
+			targets:             makeRetentionTargets(store, params.RunScraperParams),
+			interval:            retentionInterval,
+			batchSize:           retentionBatchSize,
+			sqlRequestTimeLimit: params.RequestTimeLimit,
+		})
+	}()
+	var verifier sync.WaitGroup
+	if params.VerifyInterval > 0 {
+		verifyBatchSize := params.VerifyBatchSize
+		if verifyBatchSize <= 0 {
+			verifyBatchSize = 100
+		}
+		reverifyAfter := params.ReverifyAfter
+		if reverifyAfter <= 0 {
+			reverifyAfter = 24 * time.Hour
+		}
+		goneAfterChecks := params.VerifyGoneAfterChecks
+		if goneAfterChecks <= 0 {
+			goneAfterChecks = 3
+		}
+		goneCheckAfter := params.VerifyGoneCheckAfter
+		if goneCheckAfter <= 0 {
+			goneCheckAfter = 6 * time.Hour
+		}
+		reverifyGoneAfter := params.ReverifyGoneAfter
+		if reverifyGoneAfter <= 0 {
+			reverifyGoneAfter = 7 * 24 * time.Hour
+		}
+		verifier.Add(1)
+		go func() {
+			defer verifier.Done()
+			err := runVerifier(runVerifierParams{
+				ctx:                 ctx,
+				store:               store,
+				httpClient:          httpClient,
+				interval:            params.VerifyInterval,
+				batchSize:           verifyBatchSize,
+				reverifyAfter:       reverifyAfter,
+				goneAfterChecks:     goneAfterChecks,
+				goneCheckAfter:      goneCheckAfter,
+				reverifyGoneAfter:   reverifyGoneAfter,
+				requestTimeLimit:    params.RequestTimeLimit,
+				sqlRequestTimeLimit: params.RequestTimeLimit,
+			})
+			if err != nil {
+				slog.Error("verifier failed", "error", err)
+			}
+		}()
+	}
+	var admin sync.WaitGroup
+	if params.AdminAddr != "" {
+		admin.Add(1)
+		go func() {
+			defer admin.Done()
+			serveAdmin(&adminServer{
+				ctx:                 ctx,
+				channels:            adminChannels,
+				store:               store,
+				sqlRequestTimeLimit: params.RequestTimeLimit,
+			}, params.AdminAddr)
+		}()
 	}
-	go processVodResults(ctx, resultsCh, done, params.Queries)
 	select {
 	case <-done:
 	case <-ctx.Done():
 	}
+	slog.Info("stopped polling, draining in-flight HLS fetches and results", "drain_timeout", params.DrainTimeout)
+	cancel()
+	drainTimer := time.AfterFunc(params.DrainTimeout, cancelWork)
+	defer drainTimer.Stop()
+	workers.Wait()
+	close(resultsCh)
+	resultsWriter.Wait()
+	close(drained)
+	// The queue owners write their final checkpoint when they exit, so wait for them before returning.
+	// Otherwise a restarted scraper could read the checkpoint before it is written.
+	queueOwners.Wait()
+	retention.Wait()
+	verifier.Wait()
+	// The admin server must release its address before the scraper is restarted.
+	admin.Wait()
+	slog.Info("finished draining")
 	return nil
 }
 
@@ -762,12 +1353,135 @@ type RunScraperParams struct {
 	MinViewerCountToRecord int
 	// Num streams per request (must be between 1 and 30 inclusive)
 	NumStreamsPerRequest int
-	// Vods older than the current time minus this duration will be deleted
+	// Streams that started longer ago than this are deleted by the retention job. If zero, streams are kept forever.
 	OldVodsDelete time.Duration
+	// Streamers whose latest stream started longer ago than this are deleted by the retention job. If zero, OldVodsDelete is used.
+	OldStreamersDelete time.Duration
+	// The .m3u8 bytes of streams that started longer ago than this are cleared by the retention job.
+	// The rest of the row is kept until OldVodsDelete. If zero, the bytes are kept as long as their stream.
+	OldRecordingsDelete time.Duration
+	// Viewer counts are downsampled to at most one sample per interval of this length, which keeps the highest count in the interval.
+	// Game and title changes are always recorded. If zero, viewer counts are not recorded.
+	ViewerSampleInterval time.Duration
+	// Time between runs of the retention job. If zero, it is ten minutes.
+	RetentionInterval time.Duration
+	// Maximum number of rows each retention statement deletes or clears. If zero, it is 1000.
+	RetentionBatchSize int
+	// Time between runs of the verifier, which fetches stored .m3u8 files again to find VODs that were deleted or muted since.
+	// If zero, stored .m3u8 files are never verified.
+	VerifyInterval time.Duration
+	// Maximum number of .m3u8 files each run of the verifier fetches. If zero, it is 100.
+	VerifyBatchSize int
+	// A stored .m3u8 is verified again once this much time has passed since it was last verified. If zero, it is one day.
+	ReverifyAfter time.Duration
+	// A VOD is marked as gone once this many checks in a row found no .m3u8 (403 or 404). If zero, it is 3.
+	VerifyGoneAfterChecks int
+	// A stored .m3u8 that was not found in its last check is verified again once this much time has passed,
+	// so the checks that mark a VOD as gone are spread out. If zero, it is six hours.
+	VerifyGoneCheckAfter time.Duration
+	// A VOD marked as gone is verified again once this much time has passed, in case its .m3u8 comes back. If zero, it is one week.
+	ReverifyGoneAfter time.Duration
 	// Twitch helix client ID
 	ClientId string
 	// Twitch helix client secret
 	ClientSecret string
+	// Source of live streams and video metadata. If nil, a Twitch Helix client is made from ClientId and ClientSecret.
+	StreamSource StreamSource
+	// Domains that are searched for .m3u8 files. If empty, vods.DOMAINS is used.
+	HlsDomains []string
+	// HTTP client for Twitch Helix and .m3u8 requests. If nil, a client with RequestTimeLimit as its timeouts is used.
+	HttpClient *http.Client
+	// If not empty, the live, wait and old VOD queues are checkpointed to this file and restored from it on startup.
+	// If the file is missing or unreadable, the wait queue is rebuilt from the database instead.
+	CheckpointPath string
+	// Time between checkpoints. If zero, it is one minute. A final checkpoint is always written when the scraper stops.
+	CheckpointInterval time.Duration
+	// When the scraper stops, in-flight .m3u8 fetches and database writes get this long to finish.
+	// Jobs that don't finish in time are put back into the old VODs queue.
+	DrainTimeout time.Duration
+	// If a .m3u8 is not found, it is searched for again after this delay, which doubles with every attempt.
+	// If zero, .m3u8 files that are not found are never searched for again.
+	HlsRetryBaseDelay time.Duration
+	// VODs that were last live longer ago than this are not searched for again.
+	HlsRetryMaxAge time.Duration
+	// Time between sweeps of the database for public VODs whose .m3u8 was not found.
+	HlsRetrySweepInterval time.Duration
+	// Maximum number of VODs found by each sweep. The VODs with the highest view counts are found first.
+	HlsRetrySweepLimit int
+	// If not empty, the admin API is served on this address, e.g. "localhost:8081".
+	// It has no authentication, so it should not be reachable from the internet.
+	AdminAddr string
+}
+
+type initialQueues struct {
+	liveVodQueue  *liveVodsPriorityQueue
+	waitVodQueue  *waitVodsPriorityQueue
+	oldVodQueue   *oldVodsPriorityQueue
+	retryVodQueue *retryVodsPriorityQueue
+}
+
+// Restores the queues from params.CheckpointPath if there is a checkpoint.
+// Otherwise, only the wait queue is rebuilt from the store.
+func getInitialQueues(ctx context.Context, store vodstore.Store, evictionRatio float64, params RunScraperParams) (*initialQueues, error) {
+	if params.CheckpointPath != "" {
+		checkpoint, err := readQueueCheckpoint(params.CheckpointPath)
+		if err == nil {
+			slog.Info("restoring queues from checkpoint", "created_at", time.Unix(checkpoint.CreatedAtUnix, 0).UTC())
+			return &initialQueues{
+				liveVodQueue:  checkpoint.liveVodQueue(),
+				waitVodQueue:  checkpoint.waitVodQueue(),
+				oldVodQueue:   checkpoint.oldVodQueue(),
+				retryVodQueue: checkpoint.retryVodQueue(params.HlsRetryBaseDelay),
+			}, nil
+		}
+		slog.Warn("failed to read queue checkpoint, so rebuilding wait queue from database", "path", params.CheckpointPath, "error", err)
+	}
+	waitVodQueue, err := getInitialWaitVodQueue(ctx, store, evictionRatio, params)
+	if err != nil {
+		return nil, err
+	}
+	return &initialQueues{
+		liveVodQueue:  CreateNewLiveVodsPriorityQueue(),
+		waitVodQueue:  waitVodQueue,
+		oldVodQueue:   CreateNewOldVodQueue(),
+		retryVodQueue: CreateNewRetryVodsPriorityQueue(),
+	}, nil
+}
+
+// Builds the wait VOD queue from the streams in the store that might still be live.
+// We select live vods that were updated at most evictionRatio * (liveVodEvictionThreshold + waitVodEvictionThreshold) ago before the newest live vod.
+func getInitialWaitVodQueue(ctx context.Context, store vodstore.Store, evictionRatio float64, params RunScraperParams) (*waitVodsPriorityQueue, error) {
+	latestStreams, err := store.GetLatestStreams(ctx, 1)
+	if err != nil {
+		slog.Error("failed to get latest streams", "error", err)
+		return nil, err
+	}
+	waitVodQueue := CreateNewWaitVodsPriorityQueue()
+	if len(latestStreams) == 0 {
+		slog.Info("there are no streams to rebuild the wait queue from")
+		return waitVodQueue, nil
+	}
+	latestStream := latestStreams[0]
+	lastTimeAllowed := latestStream.LastUpdatedAt.UTC().Add(-time.Duration(float64(params.LiveVodEvictionThreshold+params.WaitVodEvictionThreshold) * evictionRatio))
+	latestLiveStreams, err := store.GetLatestLiveStreams(ctx, lastTimeAllowed)
+	if err != nil {
+		slog.Error("failed to get latest live streams", "error", err)
+		return nil, err
+	}
+	lastInteraction := time.Now().UTC()
+	for _, liveStream := range latestLiveStreams {
+		waitVodQueue.Put(&LiveVod{
+			StreamerId:           liveStream.StreamerID,
+			StreamId:             liveStream.StreamID,
+			StartTimeUnix:        liveStream.StartTime.UTC().Unix(),
+			StreamerLoginAtStart: liveStream.StreamerLoginAtStart,
+			GameIdAtStart:        liveStream.GameIDAtStart,
+			MaxViews:             int(liveStream.MaxViews),
+			LastUpdatedUnix:      liveStream.LastUpdatedAt.UTC().Unix(),
+			LastInteractionUnix:  lastInteraction.Unix(),
+		})
+	}
+	return waitVodQueue, nil
 }
 
 // databaseUrl is the postgres database to connect to.
@@ -776,89 +1490,95 @@ type RunScraperParams struct {
 // params are the parameters twitch graphql scraper.
 func RunScraper(ctx context.Context, databaseUrl string, evictionRatio float64, params RunScraperParams) error {
 	type tInitialState struct {
-		conn         *pgxpool.Pool
-		queries      *sqlvods.Queries
-		waitVodQueue *waitVodsPriorityQueue
+		conn   *pgxpool.Pool
+		store  vodstore.Store
+		queues *initialQueues
 	}
 	getInitialState := func(ctx context.Context) (*tInitialState, error) {
 		compressor, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
 		if err != nil {
-			log.Println(fmt.Sprint("failed to create compressor: ", err))
+			slog.Error("failed to create compressor", "error", err)
 			return nil, err
 		}
-		log.Println(fmt.Sprint("created compressor"))
 		_ = getCompressedBytes([]byte("Lorem ipsum dolor sit amet. Lorem ipsum dolor sit amet. Lorem ipsum dolor sit amet. Lorem ipsum dolor sit amet."), compressor)
 		compressor.Close()
-		testClient := makeRobustHttpClient(params.RequestTimeLimit)
+		testClient := params.HttpClient
+		if testClient == nil {
+			testClient = makeRobustHttpClient(params.RequestTimeLimit)
+		}
+		testDomain := vods.DOMAINS[0]
+		if len(params.HlsDomains) > 0 {
+			testDomain = params.HlsDomains[0]
+		}
 		resp, err := retryOnError(func() (*http.Response, error) {
-			return testClient.Get(vods.DOMAINS[0])
+			return testClient.Get(testDomain)
 		})
 		if err != nil {
-			log.Println(fmt.Sprint("failed to establish test connection to domain: ", err))
+			slog.Error("failed to establish test connection to domain", "domain", testDomain, "error", err)
 			return nil, err
 		}
-		log.Println("established test connection to domain")
+		slog.Info("established test connection to domain", "domain", testDomain)
 		resp.Body.Close()
 		conn, err := pgxpool.Connect(ctx, databaseUrl)
 		if err != nil {
-			log.Println(fmt.Sprint("failed to connect to ", databaseUrl, ": ", err))
+			slog.Error("failed to connect to database", "error", err)
 			return nil, err
 		}
 		err = conn.Ping(ctx)
 		if err != nil {
-			log.Println(fmt.Sprint("failed to ping ", databaseUrl, ": ", err))
+			slog.Error("failed to ping database", "error", err)
 			conn.Close()
 			return nil, err
 		}
-		log.Println("successfully pinged")
-		queries := sqlvods.New(conn)
-		latestStreams, err := queries.GetLatestStreams(ctx, 1)
+		slog.Info("pinged database")
+		store := vodstore.NewPostgres(conn)
+		queues, err := getInitialQueues(ctx, store, evictionRatio, params)
 		if err != nil {
-			log.Println(fmt.Sprint("failed to get latest streams from ", databaseUrl, ": ", err))
 			conn.Close()
 			return nil, err
 		}
-		waitVodQueue := CreateNewWaitVodsPriorityQueue()
-		if len(latestStreams) == 0 {
-			log.Println("there are 0 live vods")
-			return &tInitialState{conn: conn, queries: queries, waitVodQueue: waitVodQueue}, nil
-		}
-		latestStream := latestStreams[0]
-		lastTimeAllowed := latestStream.LastUpdatedAt.UTC().Add(-time.Duration(float64(params.LiveVodEvictionThreshold+params.WaitVodEvictionThreshold) * evictionRatio))
-		latestLiveStreams, err := queries.GetLatestLiveStreams(ctx, lastTimeAllowed)
-		if err != nil {
-			log.Println("There are no latestLiveStreams")
-			conn.Close()
-			return nil, err
-		}
-		lastInteraction := time.Now().UTC()
-		for _, liveStream := range latestLiveStreams {
-			waitVodQueue.Put(&LiveVod{
-				StreamerId:           liveStream.StreamerID,
-				StreamId:             liveStream.StreamID,
-				StartTimeUnix:        liveStream.StartTime.UTC().Unix(),
-				StreamerLoginAtStart: liveStream.StreamerLoginAtStart,
-				GameIdAtStart:        liveStream.GameIDAtStart,
-				MaxViews:             int(liveStream.MaxViews),
-				LastUpdatedUnix:      liveStream.LastUpdatedAt.UTC().Unix(),
-				LastInteractionUnix:  lastInteraction.Unix(),
-			})
-		}
-		return &tInitialState{conn: conn, queries: queries, waitVodQueue: waitVodQueue}, nil
+		return &tInitialState{conn: conn, store: store, queues: queues}, nil
 	}
 	initialState, err := again.Retry(ctx, getInitialState)
 	if err != nil {
-		log.Println(fmt.Sprint("failed to get initial state: ", err))
+		slog.Error("failed to get initial state", "error", err)
 		return err
 	}
 	defer initialState.conn.Close()
-	log.Println(fmt.Sprint("entries in waitVodsQueue: ", initialState.waitVodQueue.Size()))
+	slog.Info("got initial queues", "live_queue_size", initialState.queues.liveVodQueue.Size(), "wait_queue_size", initialState.queues.waitVodQueue.Size(), "old_queue_size", initialState.queues.oldVodQueue.Size())
+	return ScrapeTwitchLiveVodsWithGqlApi(
+		ctx,
+		ScrapeTwitchLiveVodsWithGqlApiParams{
+			RunScraperParams:     params,
+			InitialLiveVodQueue:  initialState.queues.liveVodQueue,
+			InitialWaitVodQueue:  initialState.queues.waitVodQueue,
+			InitialOldVodQueue:   initialState.queues.oldVodQueue,
+			InitialRetryVodQueue: initialState.queues.retryVodQueue,
+			Store:                initialState.store,
+		},
+	)
+}
+
+// This is RunScraper for a store that is already open, e.g. vodstore.NewMemory().
+// The caller owns the store.
+func RunScraperWithStore(ctx context.Context, store vodstore.Store, evictionRatio float64, params RunScraperParams) error {
+	queues, err := again.Retry(ctx, func(ctx context.Context) (*initialQueues, error) {
+		return getInitialQueues(ctx, store, evictionRatio, params)
+	})
+	if err != nil {
+		slog.Error("failed to get initial state", "error", err)
+		return err
+	}
+	slog.Info("got initial queues", "live_queue_size", queues.liveVodQueue.Size(), "wait_queue_size", queues.waitVodQueue.Size(), "old_queue_size", queues.oldVodQueue.Size())
 	return ScrapeTwitchLiveVodsWithGqlApi(
 		ctx,
 		ScrapeTwitchLiveVodsWithGqlApiParams{
-			RunScraperParams:    params,
-			InitialWaitVodQueue: initialState.waitVodQueue,
-			Queries:             initialState.queries,
+			RunScraperParams:     params,
+			InitialLiveVodQueue:  queues.liveVodQueue,
+			InitialWaitVodQueue:  queues.waitVodQueue,
+			InitialOldVodQueue:   queues.oldVodQueue,
+			InitialRetryVodQueue: queues.retryVodQueue,
+			Store:                store,
 		},
 	)
 }
@@ -1,7 +1,23 @@
 package scraper
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/auoie/goVods/vods"
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/viewerseries"
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/jackc/pgconn"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nicklaw5/helix"
 )
 
 func assertEqual[T comparable](t testing.TB, got, want T) {
@@ -24,3 +40,1022 @@ func TestSetBoxArtWidthHeight(t *testing.T) {
 	result := SetBoxArtWidthHeight(boxArtUrl, 40, 56)
 	assertEqual(t, result, want)
 }
+
+func TestEdgeNodesMatchingAndNonEmpty(t *testing.T) {
+	testCases := []struct {
+		name string
+		a    []helix.Stream
+		b    []helix.Stream
+		want bool
+	}{
+		{name: "both empty", a: []helix.Stream{}, b: []helix.Stream{}, want: false},
+		{name: "different lengths", a: []helix.Stream{{ID: "1"}}, b: []helix.Stream{{ID: "1"}, {ID: "2"}}, want: false},
+		{name: "same ids", a: []helix.Stream{{ID: "1"}, {ID: "2"}}, b: []helix.Stream{{ID: "1", ViewerCount: 5}, {ID: "2"}}, want: true},
+		{name: "different order", a: []helix.Stream{{ID: "1"}, {ID: "2"}}, b: []helix.Stream{{ID: "2"}, {ID: "1"}}, want: false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assertEqual(t, edgeNodesMatchingAndNonEmpty(testCase.a, testCase.b), testCase.want)
+		})
+	}
+}
+
+var testStartTime = time.Date(2023, 5, 21, 8, 0, 0, 0, time.UTC)
+
+func makeTestStream(streamId string, streamerId string, viewers int, startedAt time.Time) helix.Stream {
+	return helix.Stream{
+		ID:          streamId,
+		UserID:      streamerId,
+		UserLogin:   "login" + streamerId,
+		GameID:      "509658",
+		GameName:    "Just Chatting",
+		Title:       "title",
+		ViewerCount: viewers,
+		StartedAt:   startedAt,
+		Language:    "en",
+	}
+}
+
+// Collects everything fetchTwitchHelixForever sends on oldVodsCh so the loop never blocks.
+type oldVodsCollector struct {
+	mu      sync.Mutex
+	oldVods []*LiveVod
+}
+
+func (collector *oldVodsCollector) streamIds() []string {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	ids := []string{}
+	for _, vod := range collector.oldVods {
+		ids = append(ids, vod.StreamId)
+	}
+	return ids
+}
+
+type fetchHarness struct {
+	source    *fakeStreamSource
+	store     *vodstore.Memory
+	collector *oldVodsCollector
+	cancel    context.CancelFunc
+	exited    chan struct{}
+}
+
+func (harness *fetchHarness) stop() {
+	harness.cancel()
+	<-harness.exited
+}
+
+func startFetchTwitchHelixForever(t *testing.T, source *fakeStreamSource, configure func(params *fetchTwitchHelixForeverParams)) *fetchHarness {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	harness := &fetchHarness{
+		source:    source,
+		store:     vodstore.NewMemory(),
+		collector: &oldVodsCollector{},
+		cancel:    cancel,
+		exited:    make(chan struct{}),
+	}
+	params := fetchTwitchHelixForeverParams{
+		ctx:                      ctx,
+		initialWaitVodQueue:      CreateNewWaitVodsPriorityQueue(),
+		twitchHelixClient:        source,
+		sqlRequestTimeLimit:      time.Second,
+		twitchHelixFetcherDelay:  time.Millisecond,
+		cursorResetThreshold:     time.Hour,
+		liveVodEvictionThreshold: time.Hour,
+		waitVodEvictionThreshold: time.Hour,
+		oldVodsCh:                make(chan []*LiveVod),
+		minViewerCountToObserve:  1,
+		minViewerCountToRecord:   1,
+		store:                    harness.store,
+		numStreamsPerRequest:     100,
+	}
+	configure(&params)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case oldVods := <-params.oldVodsCh:
+				harness.collector.mu.Lock()
+				harness.collector.oldVods = append(harness.collector.oldVods, oldVods...)
+				harness.collector.mu.Unlock()
+			}
+		}
+	}()
+	go func() {
+		fetchTwitchHelixForever(params)
+		close(harness.exited)
+	}()
+	t.Cleanup(harness.stop)
+	return harness
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met before the deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func assertPrefix(t *testing.T, got []string, want []string) {
+	t.Helper()
+	if len(got) < len(want) {
+		t.Fatalf("got %v want prefix %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v want prefix %v", got, want)
+		}
+	}
+}
+
+func TestFetchTwitchHelixForeverResetsCursorWithoutNextPage(t *testing.T) {
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s2", "u2", 50, testStartTime)}, cursor: "c2"},
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s3", "u3", 10, testStartTime)}, cursor: ""},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {})
+	eventually(t, func() bool { return len(source.requestedCursors()) >= 4 })
+	harness.stop()
+	assertPrefix(t, source.requestedCursors(), []string{"", "c1", "c2", ""})
+	if source.getTokenResets() == 0 {
+		t.Fatal("expected the app access token to be reset with the cursor")
+	}
+	streams, err := harness.store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 3)
+}
+
+func TestFetchTwitchHelixForeverResetsCursorOnError(t *testing.T) {
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+		fakeStreamsPage{err: errFakeHelix},
+		fakeStreamsPage{err: errFakeHelix},
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s2", "u2", 100, testStartTime)}, cursor: "c2"},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {})
+	eventually(t, func() bool { return len(source.requestedCursors()) >= 5 })
+	harness.stop()
+	// The failed request is retried once with the same cursor before the cursor is reset.
+	assertPrefix(t, source.requestedCursors(), []string{"", "c1", "c1", "", "c2"})
+}
+
+func TestFetchTwitchHelixForeverResetsCursorBelowMinViewerCount(t *testing.T) {
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s2", "u2", 4, testStartTime)}, cursor: "c2"},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.minViewerCountToObserve = 5
+	})
+	eventually(t, func() bool { return len(source.requestedCursors()) >= 3 })
+	harness.stop()
+	assertPrefix(t, source.requestedCursors(), []string{"", "c1", ""})
+}
+
+func TestFetchTwitchHelixForeverResetsExpiredCursor(t *testing.T) {
+	pages := []fakeStreamsPage{}
+	for i := 0; i < 10000; i++ {
+		pages = append(pages, fakeStreamsPage{streams: []helix.Stream{makeTestStream(fmt.Sprint("s", i), fmt.Sprint("u", i), 100, testStartTime)}, cursor: fmt.Sprint("c", i)})
+	}
+	source := newFakeStreamSource(pages...)
+	startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.cursorResetThreshold = 20 * time.Millisecond
+	})
+	eventually(t, func() bool {
+		cursors := source.requestedCursors()
+		for i, cursor := range cursors {
+			if i > 0 && cursor == "" {
+				return true
+			}
+		}
+		return false
+	})
+	if source.getTokenResets() == 0 {
+		t.Fatal("expected the app access token to be reset with the cursor")
+	}
+}
+
+func TestFetchTwitchHelixForeverEvictsRestartedStream(t *testing.T) {
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s2", "u1", 100, testStartTime.Add(time.Hour))}, cursor: "c2"},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.waitVodEvictionThreshold = 0
+	})
+	eventually(t, func() bool { return len(harness.collector.streamIds()) >= 1 })
+	harness.stop()
+	ids := harness.collector.streamIds()
+	assertEqual(t, len(ids), 1)
+	assertEqual(t, ids[0], "s1")
+}
+
+func TestFetchTwitchHelixForeverRecordsOnlyHighViewStreams(t *testing.T) {
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{
+			makeTestStream("s1", "u1", 5, testStartTime),
+			makeTestStream("s2", "u2", 50, testStartTime),
+		}, cursor: "c1"},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.liveVodEvictionThreshold = 0
+		params.waitVodEvictionThreshold = 0
+		params.minViewerCountToRecord = 10
+	})
+	eventually(t, func() bool { return len(harness.collector.streamIds()) >= 1 })
+	harness.stop()
+	ids := harness.collector.streamIds()
+	assertEqual(t, len(ids), 1)
+	assertEqual(t, ids[0], "s2")
+}
+
+func TestFetchTwitchHelixForeverMovesObservedWaitVodsBackToLiveQueue(t *testing.T) {
+	waitVodQueue := CreateNewWaitVodsPriorityQueue()
+	for _, id := range []string{"1", "2"} {
+		waitVodQueue.Put(&LiveVod{
+			StreamerId:          "u" + id,
+			StreamId:            "s" + id,
+			StartTimeUnix:       testStartTime.Unix(),
+			MaxViews:            100,
+			LastUpdatedUnix:     testStartTime.Unix(),
+			LastInteractionUnix: time.Now().Unix(),
+		})
+	}
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.initialWaitVodQueue = waitVodQueue
+		params.waitVodEvictionThreshold = 0
+	})
+	eventually(t, func() bool { return len(harness.collector.streamIds()) >= 1 })
+	harness.stop()
+	ids := harness.collector.streamIds()
+	assertEqual(t, len(ids), 1)
+	assertEqual(t, ids[0], "s2")
+}
+
+// flakyStore fails the first writes of polled streams with errs.
+type flakyStore struct {
+	*vodstore.Memory
+	mu       sync.Mutex
+	errs     []error
+	attempts int
+}
+
+func (store *flakyStore) WritePolledStreams(ctx context.Context, arg vodstore.WritePolledStreamsParams) error {
+	store.mu.Lock()
+	store.attempts++
+	var err error
+	if len(store.errs) > 0 {
+		err, store.errs = store.errs[0], store.errs[1:]
+	}
+	store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return store.Memory.WritePolledStreams(ctx, arg)
+}
+
+func (store *flakyStore) getAttempts() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.attempts
+}
+
+func TestFetchTwitchHelixForeverRetriesSerializationFailure(t *testing.T) {
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+	)
+	store := &flakyStore{Memory: vodstore.NewMemory(), errs: []error{&pgconn.PgError{Code: "40001"}}}
+	startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.store = store
+		params.twitchHelixFetcherDelay = 100 * time.Millisecond
+	})
+	eventually(t, func() bool { return store.getAttempts() >= 2 })
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, source.requestedCursors()[0], "")
+	assertEqual(t, len(source.requestedCursors()), 1)
+}
+
+func TestFetchTwitchHelixForeverSkipsIterationOnWriteFailure(t *testing.T) {
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s1", "u1", 100, testStartTime)}, cursor: "c1"},
+		fakeStreamsPage{streams: []helix.Stream{makeTestStream("s2", "u2", 50, testStartTime)}, cursor: "c2"},
+	)
+	store := &flakyStore{Memory: vodstore.NewMemory(), errs: []error{errors.New("fake constraint violation")}}
+	startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.store = store
+	})
+	eventually(t, func() bool { return len(source.requestedCursors()) >= 3 })
+	assertPrefix(t, source.requestedCursors(), []string{"", "c1", "c2"})
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, streams[0].StreamID, "s2")
+}
+
+func TestFetchTwitchHelixForeverWritesViewerSeriesOfFinishedVods(t *testing.T) {
+	stream := makeTestStream("s1", "u1", 100, testStartTime)
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{stream}, cursor: "c1"},
+		fakeStreamsPage{streams: []helix.Stream{stream}, cursor: "c2"},
+	)
+	// The first write fails, so the finished VOD has to be put back into the wait queue and written with the next poll.
+	store := &flakyStore{Memory: vodstore.NewMemory(), errs: []error{errors.New("fake constraint violation")}}
+	startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {
+		params.store = store
+		params.liveVodEvictionThreshold = 0
+		params.waitVodEvictionThreshold = 0
+		params.viewerSampleInterval = time.Minute
+	})
+	var encoded []byte
+	eventually(t, func() bool {
+		results, err := store.GetStreamViewerSeries(context.Background(), sqlvods.GetStreamViewerSeriesParams{StreamID: "s1", StartTime: testStartTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) == 0 || results[0] == nil {
+			return false
+		}
+		encoded = results[0]
+		return true
+	})
+	series, err := viewerseries.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(series.Samples), 1)
+	assertEqual(t, series.Samples[0].Viewers, 100)
+	assertEqual(t, len(series.Changes), 1)
+	assertEqual(t, series.Changes[0].GameId, stream.GameID)
+	assertEqual(t, series.Changes[0].Title, stream.Title)
+}
+
+func TestFetchTwitchHelixForeverRecordsGameChanges(t *testing.T) {
+	chatting := makeTestStream("s1", "u1", 100, testStartTime)
+	playing := chatting
+	playing.GameID = "32982"
+	playing.GameName = "Grand Theft Auto V"
+	source := newFakeStreamSource(
+		fakeStreamsPage{streams: []helix.Stream{chatting}, cursor: "c1"},
+		fakeStreamsPage{streams: []helix.Stream{playing}, cursor: "c2"},
+	)
+	harness := startFetchTwitchHelixForever(t, source, func(params *fetchTwitchHelixForeverParams) {})
+	eventually(t, func() bool {
+		categories, err := harness.store.GetPopularCategories(context.Background(), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(categories) == 2
+	})
+	streams, err := harness.store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, streams[0].GameIDAtStart, "509658")
+}
+
+func TestGetVideoStatus(t *testing.T) {
+	source := newFakeStreamSource()
+	source.videosByUserId["u1"] = []helix.Video{{ID: "v1", StreamID: "s0"}, {ID: "v2", StreamID: "s1"}}
+	source.usersById["u1"] = helix.User{ID: "u1", ProfileImageURL: "https://static-cdn.jtvnw.net/jtv_user_pictures/a-profile_image-300x300.png"}
+	source.gamesById["g1"] = helix.Game{ID: "g1", BoxArtURL: "https://static-cdn.jtvnw.net/ttv-boxart/32399_IGDB-{width}x{height}.jpg"}
+
+	status := getVideoStatus(context.Background(), source, "u1", "s1", "g1")
+	assertEqual(t, status.public, sql.NullBool{Bool: true, Valid: true})
+	assertEqual(t, status.profileImageUrl.String, "https://static-cdn.jtvnw.net/jtv_user_pictures/a-profile_image-50x50.png")
+	assertEqual(t, status.boxArtUrl.String, "https://static-cdn.jtvnw.net/ttv-boxart/32399_IGDB-40x56.jpg")
+
+	status = getVideoStatus(context.Background(), source, "u1", "s2", "g2")
+	assertEqual(t, status.public, sql.NullBool{Bool: false, Valid: true})
+	assertEqual(t, status.boxArtUrl.Valid, false)
+
+	source.videosErr = errFakeHelix
+	status = getVideoStatus(context.Background(), source, "u1", "s1", "g1")
+	assertEqual(t, status.public.Valid, false)
+}
+
+func makeTestVideoData() *vods.VideoData {
+	return &vods.VideoData{StreamerName: "streamer", VideoId: "47971746813", Time: testStartTime}
+}
+
+func TestGetValidDwpFallbacks(t *testing.T) {
+	videoData := makeTestVideoData()
+	minusOne := &vods.VideoData{StreamerName: videoData.StreamerName, VideoId: videoData.VideoId, Time: videoData.Time.Add(-time.Second)}
+	testCases := []struct {
+		name     string
+		register func(origin *fakeOrigin)
+		found    bool
+	}{
+		{name: "unix", register: func(origin *fakeOrigin) {
+			origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+		}, found: true},
+		{name: "unix minus one", register: func(origin *fakeOrigin) {
+			origin.setVideo(minusOne, true, fakeOriginServe, fakeMediaPlaylist)
+		}, found: true},
+		{name: "non-unix", register: func(origin *fakeOrigin) {
+			origin.setVideo(videoData, false, fakeOriginServe, fakeMediaPlaylist)
+		}, found: true},
+		{name: "forbidden", register: func(origin *fakeOrigin) {
+			origin.setFallback(fakeOriginForbidden)
+		}, found: false},
+		{name: "not found", register: func(origin *fakeOrigin) {}, found: false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			origin := newFakeOrigin(t)
+			testCase.register(origin)
+			dwp, err := getValidDwp(context.Background(), videoData, []string{origin.domain()}, makeRobustHttpClient(time.Second))
+			assertEqual(t, err == nil, testCase.found)
+			if testCase.found {
+				assertEqual(t, dwp.Dwp.Domain, origin.domain())
+			}
+		})
+	}
+}
+
+func TestGetValidDwpSkipsStalledDomain(t *testing.T) {
+	videoData := makeTestVideoData()
+	stalled := newFakeOrigin(t)
+	stalled.setFallback(fakeOriginStall)
+	working := newFakeOrigin(t)
+	working.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	dwp, err := getValidDwp(context.Background(), videoData, []string{stalled.domain(), working.domain()}, makeRobustHttpClient(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, dwp.Dwp.Domain, working.domain())
+}
+
+func TestGetValidDwpGivesUpOnStalledDomain(t *testing.T) {
+	origin := newFakeOrigin(t)
+	origin.setFallback(fakeOriginStall)
+	start := time.Now()
+	_, err := getValidDwp(context.Background(), makeTestVideoData(), []string{origin.domain()}, makeRobustHttpClient(50*time.Millisecond))
+	if err == nil {
+		t.Fatal("expected stalled domain to fail")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("stalled domain took %v to fail", time.Since(start))
+	}
+	if origin.numRequests() < 3 {
+		t.Fatalf("expected the unix, minus one and non-unix paths to be tried, got %v requests", origin.numRequests())
+	}
+}
+
+func TestHlsWorkerFetchCompressSend(t *testing.T) {
+	vod := &LiveVod{
+		StreamerId:           "u1",
+		StreamId:             "47971746813",
+		StartTimeUnix:        testStartTime.Unix(),
+		StreamerLoginAtStart: "streamer",
+		GameIdAtStart:        "g1",
+		MaxViews:             100,
+	}
+	missingVod := &LiveVod{
+		StreamerId:           "u2",
+		StreamId:             "47971746814",
+		StartTimeUnix:        testStartTime.Unix(),
+		StreamerLoginAtStart: "other",
+		MaxViews:             100,
+	}
+	origin := newFakeOrigin(t)
+	origin.setVideo(vod.GetVideoData(), true, fakeOriginServe, fakeMediaPlaylist)
+	origin.setFile(vod.GetVideoData(), true, "720p60/", fakeOriginNotFound, "")
+	source := newFakeStreamSource()
+	source.videosByUserId["u1"] = []helix.Video{{ID: "v1", StreamID: vod.StreamId}}
+	compressor, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	oldVodJobsCh := make(chan *LiveVod)
+	resultsCh := make(chan *VodResult)
+	go hlsWorkerFetchCompressSend(hlsWorkerFetchCompressSendParams{
+		ctx:               ctx,
+		workCtx:           ctx,
+		handBackCh:        make(chan *LiveVod),
+		twitchHelixClient: source,
+		httpClient:        makeRobustHttpClient(time.Second),
+		domains:           []string{origin.domain()},
+		oldVodJobsCh:      oldVodJobsCh,
+		hlsFetcherDelay:   time.Millisecond,
+		compressor:        compressor,
+		resultsCh:         resultsCh,
+		requestTimeLimit:  time.Second,
+	})
+
+	oldVodJobsCh <- vod
+	result := <-resultsCh
+	assertEqual(t, result.Vod, vod)
+	assertEqual(t, result.HlsBytesFound, true)
+	assertEqual(t, result.HlsDomain.String, origin.domain())
+	assertEqual(t, result.HlsDurationSeconds.Float64, 24.5)
+	assertEqual(t, result.Public.Bool, true)
+	decompressor, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decompressor.Close()
+	playlist, err := decompressor.DecodeAll(result.HlsBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(playlist), origin.domain()) {
+		t.Fatalf("segment paths were not made explicit: %s", playlist)
+	}
+	if !strings.Contains(string(playlist), "1-muted.ts") {
+		t.Fatalf("unmuted segment was not muted: %s", playlist)
+	}
+	assertEqual(t, strings.Join(result.Renditions, ","), "chunked,480p30,360p30,160p30,audio_only")
+	assertEqual(t, result.ThumbnailUrl.Valid, true)
+
+	oldVodJobsCh <- missingVod
+	result = <-resultsCh
+	assertEqual(t, result.Vod, missingVod)
+	assertEqual(t, result.HlsBytesFound, false)
+	assertEqual(t, result.HlsDomain.Valid, false)
+	assertEqual(t, result.Public, sql.NullBool{Bool: false, Valid: true})
+	assertEqual(t, len(result.Renditions), 0)
+	assertEqual(t, result.ThumbnailUrl.Valid, false)
+}
+
+func TestHlsWorkerHandsBackJobAfterDrainDeadline(t *testing.T) {
+	vod := makeLiveVod("u1", testStartTime.Unix(), 100, testStartTime.Unix())
+	origin := newFakeOrigin(t)
+	origin.setFallback(fakeOriginStall)
+	compressor, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stopPolling := context.WithCancel(context.Background())
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer stopPolling()
+	defer cancelWork()
+	oldVodJobsCh := make(chan *LiveVod)
+	handBackCh := make(chan *LiveVod)
+	exited := make(chan struct{})
+	go func() {
+		hlsWorkerFetchCompressSend(hlsWorkerFetchCompressSendParams{
+			ctx:               ctx,
+			workCtx:           workCtx,
+			handBackCh:        handBackCh,
+			twitchHelixClient: newFakeStreamSource(),
+			httpClient:        makeRobustHttpClient(time.Hour),
+			domains:           []string{origin.domain()},
+			oldVodJobsCh:      oldVodJobsCh,
+			hlsFetcherDelay:   time.Millisecond,
+			compressor:        compressor,
+			resultsCh:         make(chan *VodResult),
+			requestTimeLimit:  time.Hour,
+		})
+		close(exited)
+	}()
+	oldVodJobsCh <- vod
+	eventually(t, func() bool { return origin.numRequests() > 0 })
+	stopPolling()
+	cancelWork()
+	assertEqual(t, <-handBackCh, vod)
+	<-exited
+}
+
+func TestHlsWorkerHandsBackJobStalledOnHelix(t *testing.T) {
+	vod := makeFinishedTestVod()
+	origin := newFakeOrigin(t)
+	origin.setVideo(vod.GetVideoData(), true, fakeOriginServe, fakeMediaPlaylist)
+	source := newFakeStreamSource()
+	source.videosStall = true
+	compressor, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stopPolling := context.WithCancel(context.Background())
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer stopPolling()
+	defer cancelWork()
+	oldVodJobsCh := make(chan *LiveVod)
+	handBackCh := make(chan *LiveVod)
+	exited := make(chan struct{})
+	go func() {
+		hlsWorkerFetchCompressSend(hlsWorkerFetchCompressSendParams{
+			ctx:               ctx,
+			workCtx:           workCtx,
+			handBackCh:        handBackCh,
+			twitchHelixClient: source,
+			httpClient:        makeRobustHttpClient(time.Second),
+			domains:           []string{origin.domain()},
+			oldVodJobsCh:      oldVodJobsCh,
+			hlsFetcherDelay:   time.Millisecond,
+			compressor:        compressor,
+			resultsCh:         make(chan *VodResult),
+			requestTimeLimit:  time.Second,
+		})
+		close(exited)
+	}()
+	oldVodJobsCh <- vod
+	// The .m3u8 was found, and the worker is waiting for Twitch Helix when the drain deadline passes.
+	eventually(t, func() bool { return source.getVideosRequests() > 0 })
+	stopPolling()
+	cancelWork()
+	assertEqual(t, <-handBackCh, vod)
+	<-exited
+}
+
+// failingStore fails every recording update.
+type failingStore struct {
+	*vodstore.Memory
+}
+
+func (store failingStore) UpdateRecording(ctx context.Context, arg sqlvods.UpdateRecordingParams) error {
+	return errors.New("fake database error")
+}
+
+func (store failingStore) UpdateRecordingNotFound(ctx context.Context, arg sqlvods.UpdateRecordingNotFoundParams) ([]sql.NullBool, error) {
+	return nil, errors.New("fake database error")
+}
+
+func TestProcessVodResultsHandsBackAfterFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resultsCh := make(chan *VodResult)
+	handBackCh := make(chan *LiveVod, 2)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		processVodResults(processVodResultsParams{
+			ctx:        ctx,
+			workCtx:    context.Background(),
+			resultsCh:  resultsCh,
+			handBackCh: handBackCh,
+			done:       done,
+			store:      failingStore{vodstore.NewMemory()},
+		})
+		close(exited)
+	}()
+	first := makeLiveVod("u1", testStartTime.Unix(), 100, testStartTime.Unix())
+	second := makeLiveVod("u2", testStartTime.Unix(), 100, testStartTime.Unix())
+	resultsCh <- &VodResult{Vod: first}
+	<-done
+	resultsCh <- &VodResult{Vod: second}
+	close(resultsCh)
+	<-exited
+	assertEqual(t, <-handBackCh, first)
+	assertEqual(t, <-handBackCh, second)
+}
+
+func makeFinishedTestVod() *LiveVod {
+	return &LiveVod{
+		StreamerId:           "u1",
+		StreamId:             "47971746813",
+		StartTimeUnix:        testStartTime.Unix(),
+		StreamerLoginAtStart: "streamer",
+		MaxViews:             100,
+		LastUpdatedUnix:      testStartTime.Unix(),
+		LastInteractionUnix:  testStartTime.Unix(),
+	}
+}
+
+// Runs the whole scraper with vod in the wait queue.
+// The VOD is evicted on the first poll, so its .m3u8 fetch starts right away.
+func runScraperWithFinishedVod(t *testing.T, ctx context.Context, vod *LiveVod, origin *fakeOrigin, store vodstore.Store, params RunScraperParams) chan error {
+	t.Helper()
+	stream := makeTestStream(vod.StreamId, vod.StreamerId, vod.MaxViews, testStartTime)
+	stream.UserLogin = vod.StreamerLoginAtStart
+	err := store.UpsertManyStreams(context.Background(), twitchGqlResponseUpsertStreamsParams([]*helix.Stream{&stream}, testStartTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitVodQueue := CreateNewWaitVodsPriorityQueue()
+	waitVodQueue.Put(vod)
+	params.TwitchHelixFetcherDelay = time.Millisecond
+	params.RequestTimeLimit = time.Second
+	params.LiveVodEvictionThreshold = time.Hour
+	params.WaitVodEvictionThreshold = time.Hour
+	params.MaxOldVodsQueueSize = 10
+	params.NumHlsFetchers = 1
+	params.HlsFetcherDelay = time.Millisecond
+	params.CursorResetThreshold = time.Hour
+	params.MinViewerCountToObserve = 1
+	params.MinViewerCountToRecord = 1
+	params.NumStreamsPerRequest = 100
+	params.OldVodsDelete = 24 * time.Hour * 365 * 100
+	params.StreamSource = newFakeStreamSource()
+	params.HlsDomains = []string{origin.domain()}
+	params.HttpClient = makeRobustHttpClient(time.Hour)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ScrapeTwitchLiveVodsWithGqlApi(ctx, ScrapeTwitchLiveVodsWithGqlApiParams{
+			RunScraperParams:    params,
+			InitialWaitVodQueue: waitVodQueue,
+			Store:               store,
+		})
+	}()
+	return errCh
+}
+
+func TestScrapeTwitchLiveVodsWithGqlApiFinishesInFlightFetch(t *testing.T) {
+	store := vodstore.NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	origin := newFakeOrigin(t)
+	origin.setDelay(200 * time.Millisecond)
+	vod := makeFinishedTestVod()
+	origin.setVideo(vod.GetVideoData(), true, fakeOriginServe, fakeMediaPlaylist)
+	errCh := runScraperWithFinishedVod(t, ctx, vod, origin, store, RunScraperParams{DrainTimeout: 5 * time.Second})
+	eventually(t, func() bool { return origin.numRequests() > 0 })
+	cancel()
+	err := <-errCh
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, streams[0].BytesFound, sql.NullBool{Bool: true, Valid: true})
+}
+
+func TestScrapeTwitchLiveVodsWithGqlApiHandsBackStalledFetch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queues.json")
+	store := vodstore.NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	origin := newFakeOrigin(t)
+	origin.setFallback(fakeOriginStall)
+	vod := makeFinishedTestVod()
+	errCh := runScraperWithFinishedVod(t, ctx, vod, origin, store, RunScraperParams{
+		DrainTimeout:       50 * time.Millisecond,
+		CheckpointPath:     path,
+		CheckpointInterval: time.Hour,
+	})
+	eventually(t, func() bool { return origin.numRequests() > 0 })
+	cancel()
+	err := <-errCh
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, streams[0].BytesFound.Valid, false)
+	checkpoint, err := readQueueCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(checkpoint.OldVods), 1)
+	assertEqual(t, checkpoint.OldVods[0], *vod)
+}
+
+func TestProcessVodResultsRetriesNotFound(t *testing.T) {
+	store := vodstore.NewMemory()
+	vod := makeFinishedTestVod()
+	stream := makeTestStream(vod.StreamId, vod.StreamerId, vod.MaxViews, testStartTime)
+	err := store.UpsertManyStreams(context.Background(), twitchGqlResponseUpsertStreamsParams([]*helix.Stream{&stream}, testStartTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultsCh := make(chan *VodResult)
+	retryVodsCh := make(chan *LiveVod, 1)
+	go processVodResults(processVodResultsParams{
+		ctx:         context.Background(),
+		workCtx:     context.Background(),
+		resultsCh:   resultsCh,
+		handBackCh:  make(chan *LiveVod),
+		retryVodsCh: retryVodsCh,
+		done:        make(chan struct{}),
+		store:       store,
+	})
+	defer close(resultsCh)
+	requestInitiated := testStartTime.Add(time.Hour)
+	resultsCh <- &VodResult{
+		Vod:              vod,
+		HlsBytesFound:    false,
+		RequestInitiated: requestInitiated,
+		Public:           sql.NullBool{Bool: true, Valid: true},
+		HlsError:         sql.NullString{String: "fake not found", Valid: true},
+	}
+	retried := <-retryVodsCh
+	assertEqual(t, retried, vod)
+	assertEqual(t, retried.HlsFetchAttempts, 1)
+	assertEqual(t, retried.LastHlsFetchUnix, requestInitiated.Unix())
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, streams[0].HlsFetchAttempts, int32(1))
+	assertEqual(t, streams[0].HlsLastError.String, "fake not found")
+}
+
+// A forced refetch through the admin API of a VOD that was found before may fail during an outage.
+func TestProcessVodResultsKeepsFoundRecordingAfterFailedRefetch(t *testing.T) {
+	store := vodstore.NewMemory()
+	vod := makeFinishedTestVod()
+	stream := makeTestStream(vod.StreamId, vod.StreamerId, vod.MaxViews, testStartTime)
+	err := store.UpsertManyStreams(context.Background(), twitchGqlResponseUpsertStreamsParams([]*helix.Stream{&stream}, testStartTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateRecording(context.Background(), sqlvods.UpdateRecordingParams{
+		StreamID:           vod.StreamId,
+		StartTime:          testStartTime,
+		HlsDomain:          sql.NullString{String: "https://example.com/", Valid: true},
+		GzippedBytes:       []byte("m3u8"),
+		BytesFound:         sql.NullBool{Bool: true, Valid: true},
+		HlsDurationSeconds: sql.NullFloat64{Float64: 60, Valid: true},
+		Renditions:         []string{"chunked"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultsCh := make(chan *VodResult)
+	retryVodsCh := make(chan *LiveVod, 1)
+	exited := make(chan struct{})
+	go func() {
+		processVodResults(processVodResultsParams{
+			ctx:         context.Background(),
+			workCtx:     context.Background(),
+			resultsCh:   resultsCh,
+			handBackCh:  make(chan *LiveVod),
+			retryVodsCh: retryVodsCh,
+			done:        make(chan struct{}),
+			store:       store,
+		})
+		close(exited)
+	}()
+	resultsCh <- &VodResult{
+		Vod:              vod,
+		HlsBytesFound:    false,
+		RequestInitiated: testStartTime.Add(time.Hour),
+		Public:           sql.NullBool{Bool: true, Valid: true},
+		HlsError:         sql.NullString{String: "fake not found", Valid: true},
+	}
+	close(resultsCh)
+	<-exited
+	assertEqual(t, len(retryVodsCh), 0)
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(streams[0].GzippedBytes), "m3u8")
+	assertEqual(t, streams[0].BytesFound, sql.NullBool{Bool: true, Valid: true})
+	assertEqual(t, streams[0].HlsDomain.String, "https://example.com/")
+	assertEqual(t, streams[0].HlsDurationSeconds.Float64, 60.0)
+	assertEqual(t, len(streams[0].Renditions), 1)
+	assertEqual(t, streams[0].HlsLastError.String, "fake not found")
+}
+
+type retryHarness struct {
+	retryVodsCh chan *LiveVod
+	retryJobsCh chan *LiveVod
+	cancel      context.CancelFunc
+	drained     chan struct{}
+	exited      chan struct{}
+}
+
+func (harness *retryHarness) stop() {
+	harness.cancel()
+	close(harness.drained)
+	<-harness.exited
+}
+
+func startProcessRetryVods(t *testing.T, store vodstore.Store, checkpointer *queueCheckpointer) *retryHarness {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	harness := &retryHarness{
+		retryVodsCh: make(chan *LiveVod),
+		retryJobsCh: make(chan *LiveVod),
+		cancel:      cancel,
+		drained:     make(chan struct{}),
+		exited:      make(chan struct{}),
+	}
+	go func() {
+		processRetryVods(processRetryVodsParams{
+			ctx:                 ctx,
+			retryVodsCh:         harness.retryVodsCh,
+			retryJobsCh:         harness.retryJobsCh,
+			drained:             harness.drained,
+			store:               store,
+			sqlRequestTimeLimit: time.Second,
+			retryBaseDelay:      time.Second,
+			retryMaxAge:         24 * time.Hour,
+			sweepInterval:       time.Hour,
+			sweepLimit:          10,
+			checkpointer:        checkpointer,
+		})
+		close(harness.exited)
+	}()
+	return harness
+}
+
+func TestProcessRetryVodsSchedulesDueVods(t *testing.T) {
+	harness := startProcessRetryVods(t, vodstore.NewMemory(), nil)
+	defer harness.stop()
+	now := time.Now().UTC().Unix()
+	vod := makeLiveVod("u1", now-3600, 100, now-60)
+	vod.HlsFetchAttempts = 1
+	vod.LastHlsFetchUnix = now - 60
+	harness.retryVodsCh <- vod
+	assertEqual(t, <-harness.retryJobsCh, vod)
+}
+
+func TestProcessRetryVodsBypassesFullOldVodQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	oldVodsCh := make(chan []*LiveVod)
+	oldVodJobsCh := make(chan *LiveVod)
+	go processOldVodJobs(processOldVodJobsParams{
+		ctx:                 ctx,
+		oldVodsCh:           oldVodsCh,
+		oldVodJobsCh:        oldVodJobsCh,
+		handBackCh:          make(chan *LiveVod),
+		maxOldVodsQueueSize: 1,
+	})
+	harness := startProcessRetryVods(t, vodstore.NewMemory(), nil)
+	defer harness.stop()
+	now := time.Now().UTC().Unix()
+	// The old VODs queue is full of VODs with more views than the retry.
+	oldVodsCh <- []*LiveVod{makeLiveVod("u1", now-3600, 1000, now-60), makeLiveVod("u2", now-3600, 2000, now-60)}
+	vod := makeLiveVod("u3", now-3600, 10, now-60)
+	vod.HlsFetchAttempts = 1
+	vod.LastHlsFetchUnix = now - 60
+	harness.retryVodsCh <- vod
+	assertEqual(t, <-harness.retryJobsCh, vod)
+	assertEqual(t, (<-oldVodJobsCh).StreamerId, "u2")
+	// The retry is handed out once, and it is not handed out again until its next attempt is written.
+	select {
+	case job := <-harness.retryJobsCh:
+		t.Fatalf("retry was handed out twice: %v", job)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProcessRetryVodsGivesUpOnOldVods(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queues.json")
+	checkpointer := newQueueCheckpointer(path, time.Hour, CreateNewLiveVodsPriorityQueue(), CreateNewWaitVodsPriorityQueue(), CreateNewOldVodQueue(), CreateNewRetryVodsPriorityQueue())
+	harness := startProcessRetryVods(t, vodstore.NewMemory(), checkpointer)
+	now := time.Now().UTC().Unix()
+	tooOld := makeLiveVod("u1", now-49*3600, 100, now-48*3600)
+	tooOld.HlsFetchAttempts = 1
+	tooOld.LastHlsFetchUnix = now
+	notDue := makeLiveVod("u2", now-3600, 100, now-60)
+	notDue.HlsFetchAttempts = 10
+	notDue.LastHlsFetchUnix = now
+	harness.retryVodsCh <- tooOld
+	harness.retryVodsCh <- notDue
+	harness.stop()
+	checkpoint, err := readQueueCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(checkpoint.RetryVods), 1)
+	assertEqual(t, checkpoint.RetryVods[0], *notDue)
+}
+
+func TestProcessRetryVodsSweepsPublicVodsWithoutBytes(t *testing.T) {
+	store := vodstore.NewMemory()
+	now := time.Now().UTC()
+	public := makeTestStream("public", "u1", 100, now.Add(-2*time.Hour))
+	private := makeTestStream("private", "u2", 100, now.Add(-2*time.Hour))
+	err := store.UpsertManyStreams(context.Background(), twitchGqlResponseUpsertStreamsParams([]*helix.Stream{&public, &private}, now.Add(-time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range []helix.Stream{public, private} {
+		err = store.UpdateRecording(context.Background(), sqlvods.UpdateRecordingParams{
+			StreamID:           stream.ID,
+			StartTime:          stream.StartedAt,
+			RecordingFetchedAt: sql.NullTime{Time: now.Add(-time.Hour), Valid: true},
+			BytesFound:         sql.NullBool{Bool: false, Valid: true},
+			Public:             sql.NullBool{Bool: stream.ID == "public", Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	harness := startProcessRetryVods(t, store, nil)
+	defer harness.stop()
+	job := <-harness.retryJobsCh
+	assertEqual(t, job.StreamId, "public")
+	assertEqual(t, job.StreamerLoginAtStart, "loginu1")
+	assertEqual(t, job.HlsFetchAttempts, 1)
+}
@@ -0,0 +1,88 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nicklaw5/helix"
+)
+
+// StreamSource is the subset of the Twitch Helix API that the scraper uses.
+// The production implementation wraps a *helix.Client.
+// Tests use a scripted implementation so they never talk to Twitch.
+// Requests are abandoned once ctx is done, so a shutdown doesn't wait for Twitch.
+type StreamSource interface {
+	GetStreams(ctx context.Context, params *helix.StreamsParams) (*helix.StreamsResponse, error)
+	GetVideos(ctx context.Context, params *helix.VideosParams) (*helix.VideosResponse, error)
+	GetUsers(ctx context.Context, params *helix.UsersParams) (*helix.UsersResponse, error)
+	GetGames(ctx context.Context, params *helix.GamesParams) (*helix.GamesResponse, error)
+	// Fetch a new app access token and use it for the following requests.
+	ResetAppAccessToken(ctx context.Context) error
+}
+
+// Sends every request of a helix.Client with ctx.
+type contextHttpClient struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+func (client contextHttpClient) Do(request *http.Request) (*http.Response, error) {
+	return client.client.Do(request.WithContext(client.ctx))
+}
+
+// helix.Client doesn't take a context, so every request is made with a client that shares the app access token
+// of the long-lived client and sends its requests with the context of the call.
+type helixStreamSource struct {
+	clientId     string
+	clientSecret string
+	httpClient   *http.Client
+	client       *helix.Client
+}
+
+func (source *helixStreamSource) withContext(ctx context.Context) *helix.Client {
+	// NewClient only fails without a client ID, which NewHelixStreamSource already checked.
+	client, _ := helix.NewClient(&helix.Options{
+		ClientID:       source.clientId,
+		ClientSecret:   source.clientSecret,
+		AppAccessToken: source.client.GetAppAccessToken(),
+		HTTPClient:     contextHttpClient{ctx: ctx, client: source.httpClient},
+	})
+	return client
+}
+
+func (source *helixStreamSource) GetStreams(ctx context.Context, params *helix.StreamsParams) (*helix.StreamsResponse, error) {
+	return source.withContext(ctx).GetStreams(params)
+}
+
+func (source *helixStreamSource) GetVideos(ctx context.Context, params *helix.VideosParams) (*helix.VideosResponse, error) {
+	return source.withContext(ctx).GetVideos(params)
+}
+
+func (source *helixStreamSource) GetUsers(ctx context.Context, params *helix.UsersParams) (*helix.UsersResponse, error) {
+	return source.withContext(ctx).GetUsers(params)
+}
+
+func (source *helixStreamSource) GetGames(ctx context.Context, params *helix.GamesParams) (*helix.GamesResponse, error) {
+	return source.withContext(ctx).GetGames(params)
+}
+
+func (source *helixStreamSource) ResetAppAccessToken(ctx context.Context) error {
+	appAccessToken, err := source.withContext(ctx).RequestAppAccessToken([]string{})
+	if err != nil {
+		return err
+	}
+	source.client.SetAppAccessToken(appAccessToken.Data.AccessToken)
+	return nil
+}
+
+func NewHelixStreamSource(clientId string, clientSecret string, httpClient *http.Client) (StreamSource, error) {
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:     clientId,
+		ClientSecret: clientSecret,
+		HTTPClient:   httpClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &helixStreamSource{clientId: clientId, clientSecret: clientSecret, httpClient: httpClient, client: client}, nil
+}
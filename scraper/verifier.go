@@ -0,0 +1,292 @@
+package scraper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/auoie/goVods/vods"
+	"github.com/auoie/twitch-vods/renditions"
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/klauspost/compress/zstd"
+)
+
+var errNoStoredSegments = errors.New("stored .m3u8 has no segments on its domain")
+
+// What the verifier found when it fetched a stored .m3u8 again.
+type verifyResult string
+
+const (
+	verifyUnchanged verifyResult = "unchanged"
+	// The .m3u8 changed, but no segments were muted, e.g. because the stored copy was fetched before Twitch finished writing it.
+	verifyChanged verifyResult = "changed"
+	verifyRemuted verifyResult = "remuted"
+	// The .m3u8 was not found, but not in enough checks yet to mark it as gone.
+	verifyMissing verifyResult = "missing"
+	verifyGone    verifyResult = "gone"
+	verifyFailed  verifyResult = "failed"
+)
+
+// Older rows are gzipped, newer rows are zstd compressed.
+func decompressRecording(compressedBytes []byte, decompressor *zstd.Decoder) ([]byte, error) {
+	if len(compressedBytes) >= 2 && compressedBytes[0] == 0x1f && compressedBytes[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(compressedBytes))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return decompressor.DecodeAll(compressedBytes, nil)
+}
+
+// The stored segment URIs are explicit (see getCleanedMediaPlaylistBytes), so the domain and path of the .m3u8
+// are the directory of the first segment.
+func getStoredDwp(hlsDomain string, m3u8 []byte) (*vods.DomainWithPath, error) {
+	for _, line := range strings.Split(string(m3u8), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rest, ok := strings.CutPrefix(line, hlsDomain)
+		if !ok {
+			break
+		}
+		index := strings.LastIndex(rest, "/"+renditions.Source+"/")
+		if index == -1 {
+			break
+		}
+		return &vods.DomainWithPath{Domain: hlsDomain, Path: rest[:index]}, nil
+	}
+	return nil, errNoStoredSegments
+}
+
+func countMutedSegments(m3u8 []byte) int {
+	count := 0
+	for _, line := range strings.Split(string(m3u8), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") && strings.Contains(line, "-muted") {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the body of the .m3u8, or nil if the domain says it doesn't exist.
+// CloudFront answers 403 for deleted files, like it does for files that never existed.
+func fetchStoredDwp(ctx context.Context, dwp *vods.DomainWithPath, client *http.Client) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, dwp.GetIndexDvrUrl(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(response.Body)
+	case http.StatusForbidden, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+}
+
+type verifyStreamParams struct {
+	ctx              context.Context
+	store            vodstore.Store
+	stream           *sqlvods.GetStreamsToVerifyRow
+	httpClient       *http.Client
+	compressor       *zstd.Encoder
+	decompressor     *zstd.Decoder
+	goneAfterChecks  int
+	requestTimeLimit time.Duration
+}
+
+// Fetches the .m3u8 of a stream from the domain it was found on and compares it to the stored copy.
+// A VOD is marked as gone so the API stops serving it once goneAfterChecks checks in a row didn't find its .m3u8,
+// since the domains sometimes answer 403 for a while for files that exist. Finding the .m3u8 again clears the mark.
+// Changed .m3u8 files replace the stored copy, and the stream is marked as remuted if segments were muted since it was stored.
+// Streams whose .m3u8 could not be verified are left as they are apart from when they were last verified.
+func verifyStream(params verifyStreamParams) (verifyResult, error) {
+	stream := params.stream
+	stored, err := decompressRecording(stream.GzippedBytes, params.decompressor)
+	if err != nil {
+		return verifyFailed, err
+	}
+	dwp, err := getStoredDwp(stream.HlsDomain.String, stored)
+	if err != nil {
+		return verifyFailed, err
+	}
+	requestCtx, requestCancel := context.WithTimeout(params.ctx, params.requestTimeLimit)
+	body, err := fetchStoredDwp(requestCtx, dwp, params.httpClient)
+	requestCancel()
+	if err != nil {
+		return verifyFailed, err
+	}
+	verifiedAt := sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	if body == nil {
+		requestCtx, requestCancel = context.WithTimeout(params.ctx, params.requestTimeLimit)
+		defer requestCancel()
+		gone, err := params.store.UpdateVerificationMissing(requestCtx, sqlvods.UpdateVerificationMissingParams{
+			LastVerifiedAt:  verifiedAt,
+			GoneAfterChecks: int32(params.goneAfterChecks),
+			StreamID:        stream.StreamID,
+			StartTime:       stream.StartTime,
+		})
+		if err != nil {
+			return verifyFailed, err
+		}
+		if len(gone) == 1 && gone[0] {
+			return verifyGone, nil
+		}
+		return verifyMissing, nil
+	}
+	mediapl, err := getCleanedMediaPlaylistBytes(&vods.ValidDwpResponse{Dwp: dwp, Body: body})
+	if err != nil {
+		return verifyFailed, err
+	}
+	fetched := mediapl.Encode().Bytes()
+	requestCtx, requestCancel = context.WithTimeout(params.ctx, params.requestTimeLimit)
+	defer requestCancel()
+	if bytes.Equal(fetched, stored) {
+		return verifyUnchanged, params.store.UpdateVerification(requestCtx, sqlvods.UpdateVerificationParams{
+			StreamID:       stream.StreamID,
+			StartTime:      stream.StartTime,
+			LastVerifiedAt: verifiedAt,
+		})
+	}
+	remuted := countMutedSegments(fetched) > countMutedSegments(stored)
+	err = params.store.UpdateVerifiedRecording(requestCtx, sqlvods.UpdateVerifiedRecordingParams{
+		LastVerifiedAt:     verifiedAt,
+		GzippedBytes:       getCompressedBytes(fetched, params.compressor),
+		HlsDurationSeconds: sql.NullFloat64{Float64: vods.GetMediaPlaylistDuration(mediapl).Seconds(), Valid: true},
+		Remuted:            remuted,
+		StreamID:           stream.StreamID,
+		StartTime:          stream.StartTime,
+	})
+	if remuted {
+		return verifyRemuted, err
+	}
+	return verifyChanged, err
+}
+
+type runVerifierParams struct {
+	// The verifier runs until ctx is done.
+	ctx        context.Context
+	store      vodstore.Store
+	httpClient *http.Client
+	interval   time.Duration
+	batchSize  int
+	// Streams verified more recently than this are skipped.
+	reverifyAfter time.Duration
+	// A VOD is marked as gone once this many checks in a row didn't find its .m3u8.
+	goneAfterChecks int
+	// Streams whose .m3u8 was not found in their last check are checked again after this, instead of after reverifyAfter.
+	goneCheckAfter time.Duration
+	// Streams marked as gone are checked again after this, in case their .m3u8 comes back.
+	reverifyGoneAfter   time.Duration
+	requestTimeLimit    time.Duration
+	sqlRequestTimeLimit time.Duration
+}
+
+// Streams that can never be verified, e.g. because their stored .m3u8 doesn't decompress, would otherwise come first in every batch
+// and keep the rest from being verified, so they wait for reverifyAfter like the streams that were verified.
+func markVerificationFailed(params runVerifierParams, stream *sqlvods.GetStreamsToVerifyRow) {
+	requestCtx, requestCancel := context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
+	defer requestCancel()
+	err := params.store.UpdateVerificationFailed(requestCtx, sqlvods.UpdateVerificationFailedParams{
+		StreamID:       stream.StreamID,
+		StartTime:      stream.StartTime,
+		LastVerifiedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil && params.ctx.Err() == nil {
+		slog.Error("updating failed verification failed", "stream_id", stream.StreamID, "error", err)
+	}
+}
+
+// Verifies the batchSize streams that were verified least recently, starting with the ones that were never verified.
+// Streams marked as gone are verified at a lower rate than the rest.
+// The .m3u8 files are fetched one at a time, so the verifier never competes with the HLS fetchers for long.
+func runVerifierBatch(params runVerifierParams, compressor *zstd.Encoder, decompressor *zstd.Decoder) {
+	requestCtx, requestCancel := context.WithTimeout(params.ctx, params.sqlRequestTimeLimit)
+	now := time.Now().UTC()
+	streams, err := params.store.GetStreamsToVerify(requestCtx, sqlvods.GetStreamsToVerifyParams{
+		LastVerifiedAt:      sql.NullTime{Time: now.Add(-params.reverifyAfter), Valid: true},
+		GoneCheckVerifiedAt: sql.NullTime{Time: now.Add(-params.goneCheckAfter), Valid: true},
+		GoneVerifiedAt:      sql.NullTime{Time: now.Add(-params.reverifyGoneAfter), Valid: true},
+		Limit:               int32(params.batchSize),
+	})
+	requestCancel()
+	if err != nil {
+		if params.ctx.Err() == nil {
+			slog.Error("getting streams to verify failed", "error", err)
+		}
+		return
+	}
+	counts := map[verifyResult]int{}
+	for _, stream := range streams {
+		if params.ctx.Err() != nil {
+			return
+		}
+		result, err := verifyStream(verifyStreamParams{
+			ctx:              params.ctx,
+			store:            params.store,
+			stream:           stream,
+			httpClient:       params.httpClient,
+			compressor:       compressor,
+			decompressor:     decompressor,
+			goneAfterChecks:  params.goneAfterChecks,
+			requestTimeLimit: params.requestTimeLimit,
+		})
+		if err != nil {
+			if params.ctx.Err() != nil {
+				return
+			}
+			slog.Warn("verifying .m3u8 failed", "stream_id", stream.StreamID, "streamer_login", stream.StreamerLoginAtStart, "result", result, "error", err)
+			result = verifyFailed
+			markVerificationFailed(params, stream)
+		}
+		if result == verifyGone || result == verifyRemuted {
+			slog.Info("stored .m3u8 is outdated", "stream_id", stream.StreamID, "streamer_login", stream.StreamerLoginAtStart, "result", result)
+		}
+		counts[result]++
+		hlsVerifications.WithLabelValues(string(result)).Inc()
+	}
+	if len(streams) > 0 {
+		slog.Info("verification finished", "num_streams", len(streams), "num_missing", counts[verifyMissing], "num_gone", counts[verifyGone], "num_remuted", counts[verifyRemuted], "num_failed", counts[verifyFailed])
+	}
+}
+
+// Re-fetches stored .m3u8 files once on startup and then every interval to find VODs that were deleted or muted since they were stored.
+func runVerifier(params runVerifierParams) error {
+	compressor, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	if err != nil {
+		return err
+	}
+	defer compressor.Close()
+	decompressor, err := zstd.NewReader(nil)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+	ticker := time.NewTicker(params.interval)
+	defer ticker.Stop()
+	for {
+		runVerifierBatch(params, compressor, decompressor)
+		select {
+		case <-params.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
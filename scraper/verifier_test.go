@@ -0,0 +1,317 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/vodstore"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nicklaw5/helix"
+)
+
+// Stores the stream of videoData with the .m3u8 the origin currently serves for it, like the HLS fetchers do.
+func makeVerifierStore(t *testing.T, origin *fakeOrigin) *vodstore.Memory {
+	t.Helper()
+	ctx := context.Background()
+	videoData := makeTestVideoData()
+	store := vodstore.NewMemory()
+	stream := makeTestStream(videoData.VideoId, "u1", 100, videoData.Time)
+	err := store.WritePolledStreams(ctx, vodstore.WritePolledStreamsParams{
+		Streams:   twitchGqlResponseUpsertStreamsParams([]*helix.Stream{&stream}, videoData.Time),
+		Streamers: twitchGqlResponseUpsertStreamersParams([]*helix.Stream{&stream}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressor, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer compressor.Close()
+	result, err := getVodCompressedBytes(ctx, videoData, []string{origin.domain()}, compressor, makeRobustHttpClient(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+		StreamID:     videoData.VideoId,
+		StartTime:    videoData.Time,
+		HlsDomain:    sql.NullString{String: result.dwp.Domain, Valid: true},
+		GzippedBytes: result.compressedBytes,
+		BytesFound:   sql.NullBool{Bool: true, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func runVerifierOnce(t *testing.T, store vodstore.Store) {
+	t.Helper()
+	runVerifierOnceAfter(t, store, time.Hour, time.Hour)
+}
+
+// Like runVerifierOnce, but streams whose .m3u8 was not found in their last check are verified again once goneCheckAfter has passed,
+// and streams marked as gone once reverifyGoneAfter has passed. A negative duration verifies them again right away.
+func runVerifierOnceAfter(t *testing.T, store vodstore.Store, goneCheckAfter, reverifyGoneAfter time.Duration) {
+	t.Helper()
+	runVerifierBatchOf(t, store, 10, goneCheckAfter, reverifyGoneAfter)
+}
+
+func runVerifierBatchOf(t *testing.T, store vodstore.Store, batchSize int, goneCheckAfter, reverifyGoneAfter time.Duration) {
+	t.Helper()
+	compressor, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer compressor.Close()
+	decompressor, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decompressor.Close()
+	runVerifierBatch(runVerifierParams{
+		ctx:                 context.Background(),
+		store:               store,
+		httpClient:          makeRobustHttpClient(time.Second),
+		interval:            time.Hour,
+		batchSize:           batchSize,
+		reverifyAfter:       time.Hour,
+		goneAfterChecks:     3,
+		goneCheckAfter:      goneCheckAfter,
+		reverifyGoneAfter:   reverifyGoneAfter,
+		requestTimeLimit:    200 * time.Millisecond,
+		sqlRequestTimeLimit: time.Second,
+	}, compressor, decompressor)
+}
+
+func getVerifiedStream(t *testing.T, store vodstore.Store) *sqlvods.Stream {
+	t.Helper()
+	streams, err := store.GetEverything(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 1)
+	return streams[0]
+}
+
+func getStoredM3u8(t *testing.T, stream *sqlvods.Stream) string {
+	t.Helper()
+	decompressor, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decompressor.Close()
+	m3u8, err := decompressRecording(stream.GzippedBytes, decompressor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(m3u8)
+}
+
+func TestVerifierUnchanged(t *testing.T) {
+	origin := newFakeOrigin(t)
+	origin.setVideo(makeTestVideoData(), true, fakeOriginServe, fakeMediaPlaylist)
+	store := makeVerifierStore(t, origin)
+	before := getVerifiedStream(t, store).GzippedBytes
+
+	runVerifierOnce(t, store)
+	stream := getVerifiedStream(t, store)
+	assertEqual(t, stream.LastVerifiedAt.Valid, true)
+	assertEqual(t, stream.HlsGone, false)
+	assertEqual(t, stream.HlsRemutedAt.Valid, false)
+	assertEqual(t, string(stream.GzippedBytes), string(before))
+
+	// Streams that were verified recently are not fetched again.
+	numRequests := origin.numRequests()
+	runVerifierOnce(t, store)
+	assertEqual(t, origin.numRequests(), numRequests)
+}
+
+func TestVerifierRemuted(t *testing.T) {
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	store := makeVerifierStore(t, origin)
+	origin.setFile(videoData, true, "chunked/index-dvr.m3u8", fakeOriginServe, strings.Replace(fakeMediaPlaylist, "2.ts", "2-muted.ts", 1))
+
+	runVerifierOnce(t, store)
+	stream := getVerifiedStream(t, store)
+	assertEqual(t, stream.LastVerifiedAt.Valid, true)
+	assertEqual(t, stream.HlsRemutedAt.Valid, true)
+	assertEqual(t, stream.HlsGone, false)
+	m3u8 := getStoredM3u8(t, stream)
+	assertEqual(t, strings.Contains(m3u8, "/chunked/2-muted.ts"), true)
+	assertEqual(t, strings.Contains(m3u8, "/chunked/1-muted.ts"), true)
+}
+
+func getServedGzippedBytes(t *testing.T, store vodstore.Store, stream *sqlvods.Stream) [][]byte {
+	t.Helper()
+	results, err := store.GetStreamGzippedBytes(context.Background(), sqlvods.GetStreamGzippedBytesParams{StreamID: stream.StreamID, StartTime: stream.StartTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestVerifierGone(t *testing.T) {
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	store := makeVerifierStore(t, origin)
+	origin.setFile(videoData, true, "chunked/index-dvr.m3u8", fakeOriginForbidden, "")
+
+	// A single 403 doesn't mark the VOD as gone.
+	runVerifierOnce(t, store)
+	stream := getVerifiedStream(t, store)
+	assertEqual(t, stream.LastVerifiedAt.Valid, true)
+	assertEqual(t, stream.HlsGone, false)
+	assertEqual(t, stream.HlsGoneChecks, int32(1))
+	assertEqual(t, len(getServedGzippedBytes(t, store, stream)), 1)
+
+	// The next check waits for goneCheckAfter.
+	numRequests := origin.numRequests()
+	runVerifierOnce(t, store)
+	assertEqual(t, origin.numRequests(), numRequests)
+	assertEqual(t, getVerifiedStream(t, store).HlsGoneChecks, int32(1))
+
+	runVerifierOnceAfter(t, store, -time.Second, time.Hour)
+	stream = getVerifiedStream(t, store)
+	assertEqual(t, stream.HlsGone, false)
+	assertEqual(t, stream.HlsGoneChecks, int32(2))
+	runVerifierOnceAfter(t, store, -time.Second, time.Hour)
+	stream = getVerifiedStream(t, store)
+	assertEqual(t, stream.HlsGone, true)
+	assertEqual(t, stream.HlsGoneChecks, int32(3))
+	// The API no longer serves the .m3u8, and the verifier checks it again only after reverifyGoneAfter.
+	assertEqual(t, len(getServedGzippedBytes(t, store, stream)), 0)
+	numRequests = origin.numRequests()
+	runVerifierOnceAfter(t, store, -time.Second, time.Hour)
+	assertEqual(t, origin.numRequests(), numRequests)
+	runVerifierOnceAfter(t, store, time.Hour, -time.Second)
+	assertEqual(t, origin.numRequests() > numRequests, true)
+	assertEqual(t, getVerifiedStream(t, store).HlsGone, true)
+}
+
+func TestVerifierFoundAgainResetsGoneChecks(t *testing.T) {
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	store := makeVerifierStore(t, origin)
+	origin.setFile(videoData, true, "chunked/index-dvr.m3u8", fakeOriginForbidden, "")
+	runVerifierOnceAfter(t, store, -time.Second, time.Hour)
+	runVerifierOnceAfter(t, store, -time.Second, time.Hour)
+	assertEqual(t, getVerifiedStream(t, store).HlsGoneChecks, int32(2))
+
+	// The 403s were temporary, so the checks start over.
+	origin.setFile(videoData, true, "chunked/index-dvr.m3u8", fakeOriginServe, fakeMediaPlaylist)
+	runVerifierOnceAfter(t, store, -time.Second, time.Hour)
+	stream := getVerifiedStream(t, store)
+	assertEqual(t, stream.HlsGone, false)
+	assertEqual(t, stream.HlsGoneChecks, int32(0))
+}
+
+func TestVerifierClearsGone(t *testing.T) {
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	store := makeVerifierStore(t, origin)
+	origin.setFile(videoData, true, "chunked/index-dvr.m3u8", fakeOriginForbidden, "")
+	for i := 0; i < 3; i++ {
+		runVerifierOnceAfter(t, store, -time.Second, time.Hour)
+	}
+	assertEqual(t, getVerifiedStream(t, store).HlsGone, true)
+
+	// A gone VOD whose .m3u8 is found again is served again.
+	origin.setFile(videoData, true, "chunked/index-dvr.m3u8", fakeOriginServe, fakeMediaPlaylist)
+	runVerifierOnceAfter(t, store, time.Hour, -time.Second)
+	stream := getVerifiedStream(t, store)
+	assertEqual(t, stream.HlsGone, false)
+	assertEqual(t, stream.HlsGoneChecks, int32(0))
+	assertEqual(t, len(getServedGzippedBytes(t, store, stream)), 1)
+}
+
+func TestRecordingClearsGone(t *testing.T) {
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	store := makeVerifierStore(t, origin)
+	origin.setFile(videoData, true, "chunked/index-dvr.m3u8", fakeOriginForbidden, "")
+	for i := 0; i < 3; i++ {
+		runVerifierOnceAfter(t, store, -time.Second, time.Hour)
+	}
+	stream := getVerifiedStream(t, store)
+	assertEqual(t, stream.HlsGone, true)
+
+	// Recording the VOD again, e.g. through a refetch, clears the mark.
+	err := store.UpdateRecording(context.Background(), sqlvods.UpdateRecordingParams{
+		StreamID:     stream.StreamID,
+		StartTime:    stream.StartTime,
+		HlsDomain:    stream.HlsDomain,
+		GzippedBytes: stream.GzippedBytes,
+		BytesFound:   sql.NullBool{Bool: true, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream = getVerifiedStream(t, store)
+	assertEqual(t, stream.HlsGone, false)
+	assertEqual(t, stream.HlsGoneChecks, int32(0))
+}
+
+func TestVerifierKeepsStreamsThatCouldNotBeFetched(t *testing.T) {
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	store := makeVerifierStore(t, origin)
+	origin.setFile(videoData, true, "chunked/index-dvr.m3u8", fakeOriginStall, "")
+
+	runVerifierOnce(t, store)
+	stream := getVerifiedStream(t, store)
+	assertEqual(t, stream.LastVerifiedAt.Valid, true)
+	assertEqual(t, stream.HlsGone, false)
+	assertEqual(t, stream.HlsGoneChecks, int32(0))
+}
+
+func TestVerifierFailedStreamsDontStarveTheBatch(t *testing.T) {
+	ctx := context.Background()
+	videoData := makeTestVideoData()
+	origin := newFakeOrigin(t)
+	origin.setVideo(videoData, true, fakeOriginServe, fakeMediaPlaylist)
+	store := makeVerifierStore(t, origin)
+	// The stored copy of the earlier stream doesn't decompress, so verifying it always fails and it would come first in every batch.
+	failingTime := videoData.Time.Add(-time.Hour)
+	failing := makeTestStream("failing", "u2", 100, failingTime)
+	err := store.WritePolledStreams(ctx, vodstore.WritePolledStreamsParams{
+		Streams:   twitchGqlResponseUpsertStreamsParams([]*helix.Stream{&failing}, failingTime),
+		Streamers: twitchGqlResponseUpsertStreamersParams([]*helix.Stream{&failing}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+		StreamID:     "failing",
+		StartTime:    failingTime,
+		HlsDomain:    sql.NullString{String: origin.domain(), Valid: true},
+		GzippedBytes: []byte("garbage"),
+		BytesFound:   sql.NullBool{Bool: true, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runVerifierBatchOf(t, store, 1, time.Hour, time.Hour)
+	runVerifierBatchOf(t, store, 1, time.Hour, time.Hour)
+	streams, err := store.GetEverything(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(streams), 2)
+	for _, stream := range streams {
+		assertEqual(t, stream.LastVerifiedAt.Valid, true)
+		assertEqual(t, stream.HlsGone, false)
+	}
+}
@@ -0,0 +1,223 @@
+package scraper
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func makeWaitVod(streamId string, startTimeUnix int64, lastInteractionUnix int64) *LiveVod {
+	return &LiveVod{
+		StreamerId:          "streamer-" + streamId,
+		StreamId:            streamId,
+		StartTimeUnix:       startTimeUnix,
+		LastUpdatedUnix:     lastInteractionUnix,
+		LastInteractionUnix: lastInteractionUnix,
+	}
+}
+
+// Checks that the map and the tree describe the same set of VODs.
+func checkWaitVodsInvariants(t testing.TB, vods *waitVodsPriorityQueue) {
+	t.Helper()
+	if len(vods.streamIdToVod) != vods.lastInteractionToVod.Size() {
+		t.Fatalf("map has %v VODs but tree has %v", len(vods.streamIdToVod), vods.lastInteractionToVod.Size())
+	}
+	for key, vod := range vods.streamIdToVod {
+		if key != (streamIdStartTime{streamId: vod.StreamId, startTimeUnix: vod.StartTimeUnix}) {
+			t.Fatalf("VOD %v is stored under %v", *vod, key)
+		}
+		treeVod, ok := vods.lastInteractionToVod.Get(vod.getWaitVodsKey())
+		if !ok || treeVod != vod {
+			t.Fatalf("VOD %v is in the map but not under its key in the tree", *vod)
+		}
+	}
+	vods.lastInteractionToVod.Each(func(key *waitVodKey, vod *LiveVod) {
+		if *key != *vod.getWaitVodsKey() {
+			t.Fatalf("tree key %v does not match VOD %v", *key, *vod)
+		}
+	})
+}
+
+func TestWaitVodsPriorityQueuePut(t *testing.T) {
+	testCases := []struct {
+		name                    string
+		existing                []*LiveVod
+		put                     *LiveVod
+		wantSize                int
+		wantStalestStreamId     string
+		wantStalestStartTime    int64
+		wantStalestInteractedAt int64
+	}{
+		{
+			name:                    "empty queue",
+			put:                     makeWaitVod("s1", 100, 200),
+			wantSize:                1,
+			wantStalestStreamId:     "s1",
+			wantStalestStartTime:    100,
+			wantStalestInteractedAt: 200,
+		},
+		{
+			name:                    "replaces same stream id and start time",
+			existing:                []*LiveVod{makeWaitVod("s1", 100, 200)},
+			put:                     makeWaitVod("s1", 100, 300),
+			wantSize:                1,
+			wantStalestStreamId:     "s1",
+			wantStalestStartTime:    100,
+			wantStalestInteractedAt: 300,
+		},
+		{
+			name:                    "same stream id with another start time is another VOD",
+			existing:                []*LiveVod{makeWaitVod("s1", 100, 200)},
+			put:                     makeWaitVod("s1", 101, 150),
+			wantSize:                2,
+			wantStalestStreamId:     "s1",
+			wantStalestStartTime:    101,
+			wantStalestInteractedAt: 150,
+		},
+		{
+			name:                    "ties on interaction time are broken by stream id",
+			existing:                []*LiveVod{makeWaitVod("s2", 100, 200)},
+			put:                     makeWaitVod("s1", 100, 200),
+			wantSize:                2,
+			wantStalestStreamId:     "s1",
+			wantStalestStartTime:    100,
+			wantStalestInteractedAt: 200,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			vods := CreateNewWaitVodsPriorityQueue()
+			for _, vod := range testCase.existing {
+				vods.Put(vod)
+			}
+			vods.Put(testCase.put)
+			checkWaitVodsInvariants(t, vods)
+			assertEqual(t, vods.Size(), testCase.wantSize)
+			stalest, err := vods.GetStalestStream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, stalest.StreamId, testCase.wantStalestStreamId)
+			assertEqual(t, stalest.StartTimeUnix, testCase.wantStalestStartTime)
+			assertEqual(t, stalest.LastInteractionUnix, testCase.wantStalestInteractedAt)
+		})
+	}
+}
+
+func TestWaitVodsPriorityQueueGetAndDelete(t *testing.T) {
+	vods := CreateNewWaitVodsPriorityQueue()
+	_, err := vods.GetStalestStream()
+	if err == nil {
+		t.Fatal("empty queue should return an error")
+	}
+	vod := makeWaitVod("s1", 100, 200)
+	vods.Put(vod)
+	got, err := vods.GetByStreamIdStartTime("s1", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, vod)
+	_, err = vods.GetByStreamIdStartTime("s1", 101)
+	if err == nil {
+		t.Fatal("different start time should not be present")
+	}
+	vods.DeleteByStreamIdStartTime("s1", 101)
+	assertEqual(t, vods.Size(), 1)
+	vods.DeleteByStreamIdStartTime("s1", 100)
+	checkWaitVodsInvariants(t, vods)
+	assertEqual(t, vods.Size(), 0)
+}
+
+// The fetch loop re-queues a VOD by removing it, bumping LastInteractionUnix and putting it back.
+// The key has to be removed before the field changes or the old tree entry is orphaned.
+func TestWaitVodsPriorityQueueRequeue(t *testing.T) {
+	vods := CreateNewWaitVodsPriorityQueue()
+	vod := makeWaitVod("s1", 100, 200)
+	vods.Put(vod)
+	vods.Put(makeWaitVod("s2", 100, 250))
+	vods.RemoveVod(vod)
+	vod.LastInteractionUnix = 300
+	vods.Put(vod)
+	checkWaitVodsInvariants(t, vods)
+	assertEqual(t, vods.Size(), 2)
+	stalest, err := vods.GetStalestStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, stalest.StreamId, "s2")
+}
+
+// Applies one operation to both the queue and a plain map model, then compares them.
+func applyWaitVodsOp(t testing.TB, vods *waitVodsPriorityQueue, model map[streamIdStartTime]int64, kind, stream, startTime byte, value int64) {
+	t.Helper()
+	key := streamIdStartTime{streamId: fmt.Sprint(stream % 8), startTimeUnix: int64(startTime % 4)}
+	switch kind % 4 {
+	case 0:
+		vods.Put(makeWaitVod(key.streamId, key.startTimeUnix, value))
+		model[key] = value
+	case 1:
+		vod, err := vods.GetByStreamIdStartTime(key.streamId, key.startTimeUnix)
+		_, ok := model[key]
+		assertEqual(t, err == nil, ok)
+		if !ok {
+			return
+		}
+		vods.RemoveVod(vod)
+		vod.LastInteractionUnix = value
+		vods.Put(vod)
+		model[key] = value
+	case 2:
+		vod, err := vods.GetStalestStream()
+		if len(model) == 0 {
+			if err == nil {
+				t.Fatal("empty queue returned a VOD")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, lastInteractionUnix := range model {
+			if lastInteractionUnix < vod.LastInteractionUnix {
+				t.Fatalf("stalest VOD was interacted with at %v but another was at %v", vod.LastInteractionUnix, lastInteractionUnix)
+			}
+		}
+		vods.RemoveVod(vod)
+		delete(model, streamIdStartTime{streamId: vod.StreamId, startTimeUnix: vod.StartTimeUnix})
+	case 3:
+		vods.DeleteByStreamIdStartTime(key.streamId, key.startTimeUnix)
+		delete(model, key)
+	}
+	checkWaitVodsInvariants(t, vods)
+	assertEqual(t, vods.Size(), len(model))
+	for key, lastInteractionUnix := range model {
+		vod, err := vods.GetByStreamIdStartTime(key.streamId, key.startTimeUnix)
+		if err != nil {
+			t.Fatalf("%v is missing", key)
+		}
+		assertEqual(t, vod.LastInteractionUnix, lastInteractionUnix)
+	}
+}
+
+func TestWaitVodsPriorityQueueRandomOperations(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		random := rand.New(rand.NewSource(seed))
+		vods := CreateNewWaitVodsPriorityQueue()
+		model := map[streamIdStartTime]int64{}
+		for i := 0; i < 500; i++ {
+			applyWaitVodsOp(t, vods, model, byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256)), random.Int63n(64))
+		}
+	}
+}
+
+func FuzzWaitVodsPriorityQueue(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 10, 0, 1, 1, 20, 1, 1, 0, 30, 2, 0, 0, 0, 3, 1, 1, 0})
+	f.Add([]byte{0, 1, 0, 5, 0, 2, 0, 5, 1, 2, 0, 1, 2, 0, 0, 0, 2, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		vods := CreateNewWaitVodsPriorityQueue()
+		model := map[streamIdStartTime]int64{}
+		for i := 0; i+4 <= len(ops); i += 4 {
+			applyWaitVodsOp(t, vods, model, ops[i], ops[i+1], ops[i+2], int64(ops[i+3]))
+		}
+	})
+}
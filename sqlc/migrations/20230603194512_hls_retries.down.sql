@@ -0,0 +1,6 @@
+-- DropIndex
+DROP INDEX "streams_public_bytes_found_last_updated_at_idx";
+
+-- AlterTable
+ALTER TABLE "streams" DROP COLUMN "hls_fetch_attempts",
+DROP COLUMN "hls_last_error";
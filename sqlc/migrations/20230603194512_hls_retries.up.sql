@@ -0,0 +1,6 @@
+-- AlterTable
+ALTER TABLE "streams" ADD COLUMN     "hls_fetch_attempts" INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN     "hls_last_error" TEXT;
+
+-- CreateIndex
+CREATE INDEX "streams_public_bytes_found_last_updated_at_idx" ON "streams"("public", "bytes_found", "last_updated_at");
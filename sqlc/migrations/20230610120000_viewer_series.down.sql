@@ -0,0 +1,2 @@
+-- AlterTable
+ALTER TABLE "streams" DROP COLUMN "viewer_series";
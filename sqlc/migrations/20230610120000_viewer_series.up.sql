@@ -0,0 +1,2 @@
+-- AlterTable
+ALTER TABLE "streams" ADD COLUMN     "viewer_series" BYTEA;
@@ -0,0 +1,5 @@
+-- DropForeignKey
+ALTER TABLE "stream_segments" DROP CONSTRAINT "stream_segments_stream_id_start_time_fkey";
+
+-- DropTable
+DROP TABLE "stream_segments";
@@ -0,0 +1,29 @@
+-- CreateTable
+CREATE TABLE "stream_segments" (
+    "id" UUID NOT NULL DEFAULT gen_random_uuid(),
+    "stream_id" TEXT NOT NULL,
+    "start_time" TIMESTAMP(3) NOT NULL,
+    "game_id" TEXT NOT NULL,
+    "game_name" TEXT NOT NULL,
+    "title" TEXT NOT NULL,
+    "first_seen_at" TIMESTAMP(3) NOT NULL,
+    "last_seen_at" TIMESTAMP(3) NOT NULL,
+
+    CONSTRAINT "stream_segments_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "stream_segments_stream_id_start_time_game_id_title_key" ON "stream_segments"("stream_id", "start_time", "game_id", "title");
+
+-- CreateIndex
+CREATE INDEX "stream_segments_game_id_last_seen_at_idx" ON "stream_segments"("game_id", "last_seen_at");
+
+-- CreateIndex
+CREATE INDEX "stream_segments_game_id_stream_id_start_time_idx" ON "stream_segments"("game_id", "stream_id", "start_time");
+
+-- AddForeignKey
+ALTER TABLE "stream_segments" ADD CONSTRAINT "stream_segments_stream_id_start_time_fkey" FOREIGN KEY ("stream_id", "start_time") REFERENCES "streams"("stream_id", "start_time") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- Backfill the segment each existing stream was first seen with
+INSERT INTO "stream_segments" ("stream_id", "start_time", "game_id", "game_name", "title", "first_seen_at", "last_seen_at")
+SELECT "stream_id", "start_time", "game_id_at_start", "game_name_at_start", "title_at_start", "start_time", "last_updated_at" FROM "streams";
@@ -0,0 +1,4 @@
+-- AlterTable
+ALTER TABLE "streams" DROP COLUMN "seek_preview_sprite_urls",
+DROP COLUMN "seek_previews_url",
+DROP COLUMN "thumbnail_url";
@@ -0,0 +1,4 @@
+-- AlterTable
+ALTER TABLE "streams" ADD COLUMN     "seek_preview_sprite_urls" TEXT[],
+ADD COLUMN     "seek_previews_url" TEXT,
+ADD COLUMN     "thumbnail_url" TEXT;
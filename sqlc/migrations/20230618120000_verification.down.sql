@@ -0,0 +1,8 @@
+-- DropIndex
+DROP INDEX "streams_bytes_found_last_verified_at_idx";
+
+-- AlterTable
+ALTER TABLE "streams" DROP COLUMN "hls_gone",
+DROP COLUMN "hls_gone_checks",
+DROP COLUMN "hls_remuted_at",
+DROP COLUMN "last_verified_at";
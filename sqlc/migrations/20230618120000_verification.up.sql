@@ -0,0 +1,8 @@
+-- AlterTable
+ALTER TABLE "streams" ADD COLUMN     "hls_gone" BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN     "hls_gone_checks" INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN     "hls_remuted_at" TIMESTAMP(3),
+ADD COLUMN     "last_verified_at" TIMESTAMP(3);
+
+-- CreateIndex
+CREATE INDEX "streams_bytes_found_last_verified_at_idx" ON "streams"("bytes_found", "last_verified_at");
@@ -0,0 +1,8 @@
+-- DropIndex
+DROP INDEX "stream_segments_game_id_start_time_idx";
+
+-- DropIndex
+DROP INDEX "streams_language_at_start_public_start_time_idx";
+
+-- DropIndex
+DROP INDEX "streams_public_start_time_idx";
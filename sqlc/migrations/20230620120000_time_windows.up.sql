@@ -0,0 +1,8 @@
+-- CreateIndex
+CREATE INDEX "stream_segments_game_id_start_time_idx" ON "stream_segments"("game_id", "start_time");
+
+-- CreateIndex
+CREATE INDEX "streams_public_start_time_idx" ON "streams"("public", "start_time");
+
+-- CreateIndex
+CREATE INDEX "streams_language_at_start_public_start_time_idx" ON "streams"("language_at_start", "public", "start_time");
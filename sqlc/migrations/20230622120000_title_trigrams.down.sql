@@ -0,0 +1,5 @@
+-- DropIndex
+DROP INDEX "streams_game_name_at_start_gin_trgm_ops_idx";
+
+-- DropIndex
+DROP INDEX "streams_title_at_start_gin_trgm_ops_idx";
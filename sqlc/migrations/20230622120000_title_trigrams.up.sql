@@ -0,0 +1,6 @@
+-- The pg_trgm extension was created in 20230111172408_trigrams.
+-- CreateIndex
+CREATE INDEX "streams_title_at_start_gin_trgm_ops_idx" ON "streams" USING gin ("title_at_start" gin_trgm_ops);
+
+-- CreateIndex
+CREATE INDEX "streams_game_name_at_start_gin_trgm_ops_idx" ON "streams" USING gin ("game_name_at_start" gin_trgm_ops);
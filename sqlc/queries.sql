@@ -5,7 +5,8 @@ FROM
   streams
 WHERE
   stream_id = $1 AND
-  start_time = $2
+  start_time = $2 AND
+  hls_gone = FALSE
 LIMIT 1;
 
 -- name: GetLatestStreamsFromStreamerLogin :many
@@ -16,21 +17,24 @@ WITH
 FROM
   streams
 WHERE
-  streams.streamer_login_at_start = $1
+  streams.streamer_login_at_start = @streamer_login_at_start
 ORDER BY
   start_time DESC
 LIMIT 1)
 SELECT
-  id, max_views, start_time, s.streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start
+  id, max_views, start_time, s.streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start, thumbnail_url, hls_gone
 FROM
   streams s
 INNER JOIN
   goal_id
 ON
   s.streamer_id = goal_id.streamer_id
+WHERE
+  s.start_time >= @since::TIMESTAMP(3) AND
+  s.start_time < @cursor_start_time::TIMESTAMP(3)
 ORDER BY
   start_time DESC
-LIMIT $2;
+LIMIT @limit;
 
 -- name: UpsertManyStreams :exec
 INSERT INTO
@@ -56,6 +60,38 @@ DO
     last_updated_minus_start_time_seconds = EXCLUDED.last_updated_minus_start_time_seconds,
     max_views = GREATEST(streams.max_views, EXCLUDED.max_views);
   
+-- name: UpdateManyViewerSeries :exec
+UPDATE
+  streams
+SET
+  viewer_series = data.viewer_series
+FROM (
+  SELECT
+    unnest(@stream_id_arr::TEXT[]) AS stream_id,
+    unnest(@start_time_arr::TIMESTAMP(3)[]) AS start_time,
+    unnest(@viewer_series_arr::BYTEA[]) AS viewer_series) AS data
+WHERE
+  streams.stream_id = data.stream_id AND
+  streams.start_time = data.start_time;
+
+-- name: UpsertManyStreamSegments :exec
+INSERT INTO
+  stream_segments (stream_id, start_time, game_id, game_name, title, first_seen_at, last_seen_at)
+SELECT
+  unnest(@stream_id_arr::TEXT[]) AS stream_id,
+  unnest(@start_time_arr::TIMESTAMP(3)[]) AS start_time,
+  unnest(@game_id_arr::TEXT[]) AS game_id,
+  unnest(@game_name_arr::TEXT[]) AS game_name,
+  unnest(@title_arr::TEXT[]) AS title,
+  unnest(@seen_at_arr::TIMESTAMP(3)[]) AS first_seen_at,
+  unnest(@seen_at_arr::TIMESTAMP(3)[]) AS last_seen_at
+ON CONFLICT
+  (stream_id, start_time, game_id, title)
+DO
+  UPDATE SET
+    game_name = EXCLUDED.game_name,
+    last_seen_at = GREATEST(stream_segments.last_seen_at, EXCLUDED.last_seen_at);
+
 -- name: UpsertManyStreamers :exec
 INSERT INTO
   streamers (streamer_id, start_time, streamer_login_at_start)
@@ -99,80 +135,202 @@ SET
   public = $7,
   hls_duration_seconds = $8,
   profile_image_url_at_start = $9,
-  box_art_url_at_start = $10
+  box_art_url_at_start = $10,
+  hls_last_error = $11,
+  renditions = $12,
+  thumbnail_url = $13,
+  seek_previews_url = $14,
+  seek_preview_sprite_urls = $15,
+  hls_fetch_attempts = hls_fetch_attempts + 1,
+  hls_gone = FALSE,
+  hls_gone_checks = 0
 WHERE
   stream_id = $1 AND
   start_time = $2;
 
--- name: UpdateStreamer :exec
+-- name: UpdateRecordingNotFound :many
 UPDATE
-  streamers
+  streams
 SET
-  profile_image_url_at_start = $2
+  recording_fetched_at = $3,
+  bytes_found = COALESCE(bytes_found, FALSE),
+  public = $4,
+  profile_image_url_at_start = $5,
+  box_art_url_at_start = $6,
+  hls_last_error = $7,
+  hls_fetch_attempts = hls_fetch_attempts + 1
 WHERE
-  streamer_login_at_start = $1;
+  stream_id = $1 AND
+  start_time = $2
+RETURNING
+  bytes_found;
 
--- name: GetPopularLiveStreams :many
+-- name: GetStreamRenditions :many
 SELECT
-  id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start
+  renditions
 FROM
   streams
 WHERE
-  public = $1
+  stream_id = $1 AND
+  start_time = $2 AND
+  hls_gone = FALSE
+LIMIT 1;
+
+-- name: GetStreamSegments :many
+SELECT
+  game_id, game_name, title, first_seen_at, last_seen_at
+FROM
+  stream_segments
+WHERE
+  stream_id = $1 AND
+  start_time = $2
 ORDER BY
-  max_views DESC, id DESC
-LIMIT $2;
+  first_seen_at, game_id, title;
 
--- name: GetPopularLiveStreamsByLanguage :many
+-- name: GetStreamViewerSeries :many
 SELECT
-  id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start
+  viewer_series
 FROM
   streams
 WHERE
-  language_at_start = $1 AND public = $2
+  stream_id = $1 AND
+  start_time = $2
+LIMIT 1;
+
+-- name: GetStreamsToVerify :many
+SELECT
+  stream_id, start_time, streamer_login_at_start, hls_domain, gzipped_bytes
+FROM
+  streams
+WHERE
+  bytes_found = TRUE AND
+  gzipped_bytes IS NOT NULL AND
+  (last_verified_at IS NULL OR
+    (hls_gone = FALSE AND hls_gone_checks = 0 AND last_verified_at < @last_verified_at) OR
+    (hls_gone = FALSE AND hls_gone_checks > 0 AND last_verified_at < @gone_check_verified_at) OR
+    (hls_gone = TRUE AND last_verified_at < @gone_verified_at))
 ORDER BY
-  max_views DESC, id DESC
-LIMIT $3;
+  last_verified_at NULLS FIRST, start_time
+LIMIT @limit;
+
+-- name: UpdateVerification :exec
+UPDATE
+  streams
+SET
+  last_verified_at = $3,
+  hls_gone = FALSE,
+  hls_gone_checks = 0
+WHERE
+  stream_id = $1 AND
+  start_time = $2;
 
--- name: GetPopularLiveStreamsByGameId :many
+-- name: UpdateVerificationFailed :exec
+UPDATE
+  streams
+SET
+  last_verified_at = $3
+WHERE
+  stream_id = $1 AND
+  start_time = $2;
+
+-- name: UpdateVerificationMissing :many
+UPDATE
+  streams
+SET
+  last_verified_at = @last_verified_at,
+  hls_gone = hls_gone_checks + 1 >= @gone_after_checks::INT,
+  hls_gone_checks = hls_gone_checks + 1
+WHERE
+  stream_id = @stream_id AND
+  start_time = @start_time
+RETURNING
+  hls_gone;
+
+-- name: UpdateVerifiedRecording :exec
+UPDATE
+  streams
+SET
+  last_verified_at = @last_verified_at,
+  gzipped_bytes = @gzipped_bytes,
+  hls_duration_seconds = @hls_duration_seconds,
+  hls_remuted_at = CASE WHEN @remuted::BOOLEAN THEN @last_verified_at ELSE hls_remuted_at END,
+  hls_gone = FALSE,
+  hls_gone_checks = 0
+WHERE
+  stream_id = @stream_id AND
+  start_time = @start_time;
+
+-- name: GetStreamsToRetry :many
 SELECT
-  id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start
+  stream_id, streamer_id, streamer_login_at_start, game_id_at_start, start_time, max_views, last_updated_at, recording_fetched_at, hls_fetch_attempts
 FROM
   streams
 WHERE
-  game_id_at_start = $1 AND public = $2
+  public = TRUE AND
+  bytes_found = FALSE AND
+  last_updated_at >= $1
 ORDER BY
-  max_views DESC, id DESC
-LIMIT $3;
+  max_views DESC
+LIMIT $2;
 
--- name: GetMatchingStreamers :many
-(SELECT 
-  profile_image_url_at_start, streamer_login_at_start
+-- name: GetStream :many
+SELECT
+  stream_id, streamer_id, streamer_login_at_start, game_id_at_start, start_time, max_views, last_updated_at, recording_fetched_at, hls_fetch_attempts
 FROM
+  streams
+WHERE
+  stream_id = $1 AND
+  start_time = $2
+LIMIT 1;
+
+-- name: UpdateStreamer :exec
+UPDATE
   streamers
+SET
+  profile_image_url_at_start = $2
 WHERE
-  streamers.streamer_login_at_start = $1)
-UNION
-(SELECT
-  profile_image_url_at_start, streamer_login_at_start
+  streamer_login_at_start = $1;
+
+-- name: GetMatchingStreamers :many
+SELECT
+  profile_image_url_at_start, streamer_login_at_start, start_time AS last_stream_start_time,
+  (SELECT COUNT(*) FROM streams WHERE streams.streamer_login_at_start = streamers.streamer_login_at_start) AS stream_count
 FROM
   streamers
 WHERE
-  streamers.streamer_login_at_start ILIKE $2
-LIMIT
-  $3);
+  @query::TEXT <% streamer_login_at_start OR streamer_login_at_start ILIKE @pattern::TEXT
+ORDER BY
+  streamer_login_at_start = @query::TEXT DESC,
+  word_similarity(@query::TEXT, streamer_login_at_start) DESC,
+  similarity(@query::TEXT, streamer_login_at_start) DESC,
+  stream_count DESC,
+  streamer_login_at_start
+LIMIT @limit;
+
+-- name: GetMatchingTitles :many
+SELECT
+  id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start, thumbnail_url, hls_gone
+FROM
+  streams
+WHERE
+  (@query::TEXT <% title_at_start OR @query::TEXT <% game_name_at_start) AND
+  start_time >= @since::TIMESTAMP(3) AND
+  start_time < @until::TIMESTAMP(3)
+ORDER BY
+  GREATEST(word_similarity(@query::TEXT, title_at_start), word_similarity(@query::TEXT, game_name_at_start)) DESC, max_views DESC, id DESC
+LIMIT @limit;
 
 -- name: GetPopularCategories :many
 WITH
   categories AS
 (SELECT
-  COUNT(*) AS count, game_name_at_start, game_id_at_start
+  COUNT(DISTINCT (stream_id, start_time)) AS count, game_name AS game_name_at_start, game_id AS game_id_at_start
 FROM
-  streams
+  stream_segments
 WHERE
-  last_updated_at > NOW() - INTERVAL '1 day'
+  last_seen_at > NOW() - INTERVAL '1 day'
 GROUP BY
-  game_name_at_start, game_id_at_start)
+  game_name, game_id)
 SELECT
   *
 FROM
@@ -200,15 +358,53 @@ FROM
 ORDER BY
   count DESC;
 
--- name: DeleteOldStreams :exec
+-- name: DeleteOldStreams :execrows
 DELETE FROM streams
-WHERE 
-  start_time < $1;
+WHERE
+  id IN (
+    SELECT
+      id
+    FROM
+      streams
+    WHERE
+      start_time < $1
+    ORDER BY
+      start_time
+    LIMIT $2);
 
--- name: DeleteOldStreamers :exec
+-- name: DeleteOldStreamers :execrows
 DELETE FROM streamers
-WHERE 
-  start_time < $1;
+WHERE
+  id IN (
+    SELECT
+      id
+    FROM
+      streamers
+    WHERE
+      start_time < $1
+    ORDER BY
+      start_time
+    LIMIT $2);
+
+-- name: ClearOldRecordings :execrows
+UPDATE
+  streams
+SET
+  gzipped_bytes = NULL,
+  bytes_found = FALSE,
+  renditions = NULL
+WHERE
+  id IN (
+    SELECT
+      id
+    FROM
+      streams
+    WHERE
+      start_time < $1 AND
+      gzipped_bytes IS NOT NULL
+    ORDER BY
+      start_time
+    LIMIT $2);
 
 -- name: GetEverything :many
 SELECT
@@ -33,6 +33,28 @@ type Stream struct {
 	Public                           sql.NullBool
 	BoxArtUrlAtStart                 sql.NullString
 	ProfileImageUrlAtStart           sql.NullString
+	HlsFetchAttempts                 int32
+	HlsLastError                     sql.NullString
+	ViewerSeries                     []byte
+	Renditions                       []string
+	SeekPreviewSpriteUrls            []string
+	SeekPreviewsUrl                  sql.NullString
+	ThumbnailUrl                     sql.NullString
+	HlsGone                          bool
+	HlsGoneChecks                    int32
+	HlsRemutedAt                     sql.NullTime
+	LastVerifiedAt                   sql.NullTime
+}
+
+type StreamSegment struct {
+	ID          uuid.UUID
+	StreamID    string
+	StartTime   time.Time
+	GameID      string
+	GameName    string
+	Title       string
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
 }
 
 type Streamer struct {
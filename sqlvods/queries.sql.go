@@ -13,26 +13,94 @@ import (
 	"github.com/google/uuid"
 )
 
-const deleteOldStreamers = `-- name: DeleteOldStreamers :exec
+const clearOldRecordings = `-- name: ClearOldRecordings :execrows
+UPDATE
+  streams
+SET
+  gzipped_bytes = NULL,
+  bytes_found = FALSE,
+  renditions = NULL
+WHERE
+  id IN (
+    SELECT
+      id
+    FROM
+      streams
+    WHERE
+      start_time < $1 AND
+      gzipped_bytes IS NOT NULL
+    ORDER BY
+      start_time
+    LIMIT $2)
+`
+
+type ClearOldRecordingsParams struct {
+	StartTime time.Time
+	Limit     int32
+}
+
+func (q *Queries) ClearOldRecordings(ctx context.Context, arg ClearOldRecordingsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, clearOldRecordings, arg.StartTime, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOldStreamers = `-- name: DeleteOldStreamers :execrows
 DELETE FROM streamers
-WHERE 
-  start_time < $1
+WHERE
+  id IN (
+    SELECT
+      id
+    FROM
+      streamers
+    WHERE
+      start_time < $1
+    ORDER BY
+      start_time
+    LIMIT $2)
 `
 
-func (q *Queries) DeleteOldStreamers(ctx context.Context, startTime time.Time) error {
-	_, err := q.db.Exec(ctx, deleteOldStreamers, startTime)
-	return err
+type DeleteOldStreamersParams struct {
+	StartTime time.Time
+	Limit     int32
 }
 
-const deleteOldStreams = `-- name: DeleteOldStreams :exec
+func (q *Queries) DeleteOldStreamers(ctx context.Context, arg DeleteOldStreamersParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOldStreamers, arg.StartTime, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOldStreams = `-- name: DeleteOldStreams :execrows
 DELETE FROM streams
-WHERE 
-  start_time < $1
+WHERE
+  id IN (
+    SELECT
+      id
+    FROM
+      streams
+    WHERE
+      start_time < $1
+    ORDER BY
+      start_time
+    LIMIT $2)
 `
 
-func (q *Queries) DeleteOldStreams(ctx context.Context, startTime time.Time) error {
-	_, err := q.db.Exec(ctx, deleteOldStreams, startTime)
-	return err
+type DeleteOldStreamsParams struct {
+	StartTime time.Time
+	Limit     int32
+}
+
+func (q *Queries) DeleteOldStreams(ctx context.Context, arg DeleteOldStreamsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOldStreams, arg.StartTime, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const deleteStreams = `-- name: DeleteStreams :exec
@@ -46,7 +114,7 @@ func (q *Queries) DeleteStreams(ctx context.Context) error {
 
 const getEverything = `-- name: GetEverything :many
 SELECT
-  id, streamer_id, stream_id, start_time, max_views, last_updated_at, streamer_login_at_start, language_at_start, title_at_start, game_name_at_start, game_id_at_start, is_mature_at_start, last_updated_minus_start_time_seconds, recording_fetched_at, gzipped_bytes, hls_domain, hls_duration_seconds, bytes_found, public, box_art_url_at_start, profile_image_url_at_start
+  id, streamer_id, stream_id, start_time, max_views, last_updated_at, streamer_login_at_start, language_at_start, title_at_start, game_name_at_start, game_id_at_start, is_mature_at_start, last_updated_minus_start_time_seconds, recording_fetched_at, gzipped_bytes, hls_domain, hls_duration_seconds, bytes_found, public, box_art_url_at_start, profile_image_url_at_start, hls_fetch_attempts, hls_last_error, viewer_series, renditions, seek_preview_sprite_urls, seek_previews_url, thumbnail_url, hls_gone, hls_gone_checks, hls_remuted_at, last_verified_at
 FROM
   streams s
 `
@@ -82,6 +150,17 @@ func (q *Queries) GetEverything(ctx context.Context) ([]*Stream, error) {
 			&i.Public,
 			&i.BoxArtUrlAtStart,
 			&i.ProfileImageUrlAtStart,
+			&i.HlsFetchAttempts,
+			&i.HlsLastError,
+			&i.ViewerSeries,
+			&i.Renditions,
+			&i.SeekPreviewSpriteUrls,
+			&i.SeekPreviewsUrl,
+			&i.ThumbnailUrl,
+			&i.HlsGone,
+			&i.HlsGoneChecks,
+			&i.HlsRemutedAt,
+			&i.LastVerifiedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -246,20 +325,25 @@ ORDER BY
   start_time DESC
 LIMIT 1)
 SELECT
-  id, max_views, start_time, s.streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start
+  id, max_views, start_time, s.streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start, thumbnail_url, hls_gone
 FROM
   streams s
 INNER JOIN
   goal_id
 ON
   s.streamer_id = goal_id.streamer_id
+WHERE
+  s.start_time >= $2::TIMESTAMP(3) AND
+  s.start_time < $3::TIMESTAMP(3)
 ORDER BY
   start_time DESC
-LIMIT $2
+LIMIT $4
 `
 
 type GetLatestStreamsFromStreamerLoginParams struct {
 	StreamerLoginAtStart string
+	Since                time.Time
+	CursorStartTime      time.Time
 	Limit                int32
 }
 
@@ -280,10 +364,17 @@ type GetLatestStreamsFromStreamerLoginRow struct {
 	HlsDurationSeconds     sql.NullFloat64
 	BoxArtUrlAtStart       sql.NullString
 	ProfileImageUrlAtStart sql.NullString
+	ThumbnailUrl           sql.NullString
+	HlsGone                bool
 }
 
 func (q *Queries) GetLatestStreamsFromStreamerLogin(ctx context.Context, arg GetLatestStreamsFromStreamerLoginParams) ([]*GetLatestStreamsFromStreamerLoginRow, error) {
-	rows, err := q.db.Query(ctx, getLatestStreamsFromStreamerLogin, arg.StreamerLoginAtStart, arg.Limit)
+	rows, err := q.db.Query(ctx, getLatestStreamsFromStreamerLogin,
+		arg.StreamerLoginAtStart,
+		arg.Since,
+		arg.CursorStartTime,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -308,6 +399,8 @@ func (q *Queries) GetLatestStreamsFromStreamerLogin(ctx context.Context, arg Get
 			&i.HlsDurationSeconds,
 			&i.BoxArtUrlAtStart,
 			&i.ProfileImageUrlAtStart,
+			&i.ThumbnailUrl,
+			&i.HlsGone,
 		); err != nil {
 			return nil, err
 		}
@@ -320,36 +413,37 @@ func (q *Queries) GetLatestStreamsFromStreamerLogin(ctx context.Context, arg Get
 }
 
 const getMatchingStreamers = `-- name: GetMatchingStreamers :many
-(SELECT 
-  profile_image_url_at_start, streamer_login_at_start
-FROM
-  streamers
-WHERE
-  streamers.streamer_login_at_start = $1)
-UNION
-(SELECT
-  profile_image_url_at_start, streamer_login_at_start
+SELECT
+  profile_image_url_at_start, streamer_login_at_start, start_time AS last_stream_start_time,
+  (SELECT COUNT(*) FROM streams WHERE streams.streamer_login_at_start = streamers.streamer_login_at_start) AS stream_count
 FROM
   streamers
 WHERE
-  streamers.streamer_login_at_start ILIKE $2
-LIMIT
-  $3)
+  $1::TEXT <% streamer_login_at_start OR streamer_login_at_start ILIKE $2::TEXT
+ORDER BY
+  streamer_login_at_start = $1::TEXT DESC,
+  word_similarity($1::TEXT, streamer_login_at_start) DESC,
+  similarity($1::TEXT, streamer_login_at_start) DESC,
+  stream_count DESC,
+  streamer_login_at_start
+LIMIT $3
 `
 
 type GetMatchingStreamersParams struct {
-	StreamerLoginAtStart   string
-	StreamerLoginAtStart_2 string
-	Limit                  int32
+	Query   string
+	Pattern string
+	Limit   int32
 }
 
 type GetMatchingStreamersRow struct {
 	ProfileImageUrlAtStart sql.NullString
 	StreamerLoginAtStart   string
+	LastStreamStartTime    time.Time
+	StreamCount            int64
 }
 
 func (q *Queries) GetMatchingStreamers(ctx context.Context, arg GetMatchingStreamersParams) ([]*GetMatchingStreamersRow, error) {
-	rows, err := q.db.Query(ctx, getMatchingStreamers, arg.StreamerLoginAtStart, arg.StreamerLoginAtStart_2, arg.Limit)
+	rows, err := q.db.Query(ctx, getMatchingStreamers, arg.Query, arg.Pattern, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -357,7 +451,98 @@ func (q *Queries) GetMatchingStreamers(ctx context.Context, arg GetMatchingStrea
 	var items []*GetMatchingStreamersRow
 	for rows.Next() {
 		var i GetMatchingStreamersRow
-		if err := rows.Scan(&i.ProfileImageUrlAtStart, &i.StreamerLoginAtStart); err != nil {
+		if err := rows.Scan(
+			&i.ProfileImageUrlAtStart,
+			&i.StreamerLoginAtStart,
+			&i.LastStreamStartTime,
+			&i.StreamCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMatchingTitles = `-- name: GetMatchingTitles :many
+SELECT
+  id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start, thumbnail_url, hls_gone
+FROM
+  streams
+WHERE
+  ($1::TEXT <% title_at_start OR $1::TEXT <% game_name_at_start) AND
+  start_time >= $2::TIMESTAMP(3) AND
+  start_time < $3::TIMESTAMP(3)
+ORDER BY
+  GREATEST(word_similarity($1::TEXT, title_at_start), word_similarity($1::TEXT, game_name_at_start)) DESC, max_views DESC, id DESC
+LIMIT $4
+`
+
+type GetMatchingTitlesParams struct {
+	Query string
+	Since time.Time
+	Until time.Time
+	Limit int32
+}
+
+type GetMatchingTitlesRow struct {
+	ID                     uuid.UUID
+	MaxViews               int64
+	StartTime              time.Time
+	StreamerID             string
+	StreamID               string
+	StreamerLoginAtStart   string
+	GameNameAtStart        string
+	LanguageAtStart        string
+	TitleAtStart           string
+	IsMatureAtStart        bool
+	GameIDAtStart          string
+	BytesFound             sql.NullBool
+	Public                 sql.NullBool
+	HlsDurationSeconds     sql.NullFloat64
+	BoxArtUrlAtStart       sql.NullString
+	ProfileImageUrlAtStart sql.NullString
+	ThumbnailUrl           sql.NullString
+	HlsGone                bool
+}
+
+func (q *Queries) GetMatchingTitles(ctx context.Context, arg GetMatchingTitlesParams) ([]*GetMatchingTitlesRow, error) {
+	rows, err := q.db.Query(ctx, getMatchingTitles,
+		arg.Query,
+		arg.Since,
+		arg.Until,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*GetMatchingTitlesRow
+	for rows.Next() {
+		var i GetMatchingTitlesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.MaxViews,
+			&i.StartTime,
+			&i.StreamerID,
+			&i.StreamID,
+			&i.StreamerLoginAtStart,
+			&i.GameNameAtStart,
+			&i.LanguageAtStart,
+			&i.TitleAtStart,
+			&i.IsMatureAtStart,
+			&i.GameIDAtStart,
+			&i.BytesFound,
+			&i.Public,
+			&i.HlsDurationSeconds,
+			&i.BoxArtUrlAtStart,
+			&i.ProfileImageUrlAtStart,
+			&i.ThumbnailUrl,
+			&i.HlsGone,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, &i)
@@ -372,13 +557,13 @@ const getPopularCategories = `-- name: GetPopularCategories :many
 WITH
   categories AS
 (SELECT
-  COUNT(*) AS count, game_name_at_start, game_id_at_start
+  COUNT(DISTINCT (stream_id, start_time)) AS count, game_name AS game_name_at_start, game_id AS game_id_at_start
 FROM
-  streams
+  stream_segments
 WHERE
-  last_updated_at > NOW() - INTERVAL '1 day'
+  last_seen_at > NOW() - INTERVAL '1 day'
 GROUP BY
-  game_name_at_start, game_id_at_start)
+  game_name, game_id)
 SELECT
   count, game_name_at_start, game_id_at_start
 FROM
@@ -415,68 +600,53 @@ func (q *Queries) GetPopularCategories(ctx context.Context, limit int32) ([]*Get
 	return items, nil
 }
 
-const getPopularLiveStreams = `-- name: GetPopularLiveStreams :many
+const getStream = `-- name: GetStream :many
 SELECT
-  id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start
+  stream_id, streamer_id, streamer_login_at_start, game_id_at_start, start_time, max_views, last_updated_at, recording_fetched_at, hls_fetch_attempts
 FROM
   streams
 WHERE
-  public = $1
-ORDER BY
-  max_views DESC, id DESC
-LIMIT $2
+  stream_id = $1 AND
+  start_time = $2
+LIMIT 1
 `
 
-type GetPopularLiveStreamsParams struct {
-	Public sql.NullBool
-	Limit  int32
+type GetStreamParams struct {
+	StreamID  string
+	StartTime time.Time
 }
 
-type GetPopularLiveStreamsRow struct {
-	ID                     uuid.UUID
-	MaxViews               int64
-	StartTime              time.Time
-	StreamerID             string
-	StreamID               string
-	StreamerLoginAtStart   string
-	GameNameAtStart        string
-	LanguageAtStart        string
-	TitleAtStart           string
-	IsMatureAtStart        bool
-	GameIDAtStart          string
-	BytesFound             sql.NullBool
-	Public                 sql.NullBool
-	HlsDurationSeconds     sql.NullFloat64
-	BoxArtUrlAtStart       sql.NullString
-	ProfileImageUrlAtStart sql.NullString
+type GetStreamRow struct {
+	StreamID             string
+	StreamerID           string
+	StreamerLoginAtStart string
+	GameIDAtStart        string
+	StartTime            time.Time
+	MaxViews             int64
+	LastUpdatedAt        time.Time
+	RecordingFetchedAt   sql.NullTime
+	HlsFetchAttempts     int32
 }
 
-func (q *Queries) GetPopularLiveStreams(ctx context.Context, arg GetPopularLiveStreamsParams) ([]*GetPopularLiveStreamsRow, error) {
-	rows, err := q.db.Query(ctx, getPopularLiveStreams, arg.Public, arg.Limit)
+func (q *Queries) GetStream(ctx context.Context, arg GetStreamParams) ([]*GetStreamRow, error) {
+	rows, err := q.db.Query(ctx, getStream, arg.StreamID, arg.StartTime)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []*GetPopularLiveStreamsRow
+	var items []*GetStreamRow
 	for rows.Next() {
-		var i GetPopularLiveStreamsRow
+		var i GetStreamRow
 		if err := rows.Scan(
-			&i.ID,
-			&i.MaxViews,
-			&i.StartTime,
-			&i.StreamerID,
 			&i.StreamID,
+			&i.StreamerID,
 			&i.StreamerLoginAtStart,
-			&i.GameNameAtStart,
-			&i.LanguageAtStart,
-			&i.TitleAtStart,
-			&i.IsMatureAtStart,
 			&i.GameIDAtStart,
-			&i.BytesFound,
-			&i.Public,
-			&i.HlsDurationSeconds,
-			&i.BoxArtUrlAtStart,
-			&i.ProfileImageUrlAtStart,
+			&i.StartTime,
+			&i.MaxViews,
+			&i.LastUpdatedAt,
+			&i.RecordingFetchedAt,
+			&i.HlsFetchAttempts,
 		); err != nil {
 			return nil, err
 		}
@@ -488,69 +658,120 @@ func (q *Queries) GetPopularLiveStreams(ctx context.Context, arg GetPopularLiveS
 	return items, nil
 }
 
-const getPopularLiveStreamsByGameId = `-- name: GetPopularLiveStreamsByGameId :many
+const getStreamGzippedBytes = `-- name: GetStreamGzippedBytes :many
+SELECT
+  gzipped_bytes
+FROM
+  streams
+WHERE
+  stream_id = $1 AND
+  start_time = $2 AND
+  hls_gone = FALSE
+LIMIT 1
+`
+
+type GetStreamGzippedBytesParams struct {
+	StreamID  string
+	StartTime time.Time
+}
+
+func (q *Queries) GetStreamGzippedBytes(ctx context.Context, arg GetStreamGzippedBytesParams) ([][]byte, error) {
+	rows, err := q.db.Query(ctx, getStreamGzippedBytes, arg.StreamID, arg.StartTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items [][]byte
+	for rows.Next() {
+		var gzipped_bytes []byte
+		if err := rows.Scan(&gzipped_bytes); err != nil {
+			return nil, err
+		}
+		items = append(items, gzipped_bytes)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStreamRenditions = `-- name: GetStreamRenditions :many
 SELECT
-  id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start
+  renditions
 FROM
   streams
 WHERE
-  game_id_at_start = $1 AND public = $2
+  stream_id = $1 AND
+  start_time = $2 AND
+  hls_gone = FALSE
+LIMIT 1
+`
+
+type GetStreamRenditionsParams struct {
+	StreamID  string
+	StartTime time.Time
+}
+
+func (q *Queries) GetStreamRenditions(ctx context.Context, arg GetStreamRenditionsParams) ([][]string, error) {
+	rows, err := q.db.Query(ctx, getStreamRenditions, arg.StreamID, arg.StartTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items [][]string
+	for rows.Next() {
+		var renditions []string
+		if err := rows.Scan(&renditions); err != nil {
+			return nil, err
+		}
+		items = append(items, renditions)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStreamSegments = `-- name: GetStreamSegments :many
+SELECT
+  game_id, game_name, title, first_seen_at, last_seen_at
+FROM
+  stream_segments
+WHERE
+  stream_id = $1 AND
+  start_time = $2
 ORDER BY
-  max_views DESC, id DESC
-LIMIT $3
+  first_seen_at, game_id, title
 `
 
-type GetPopularLiveStreamsByGameIdParams struct {
-	GameIDAtStart string
-	Public        sql.NullBool
-	Limit         int32
+type GetStreamSegmentsParams struct {
+	StreamID  string
+	StartTime time.Time
 }
 
-type GetPopularLiveStreamsByGameIdRow struct {
-	ID                     uuid.UUID
-	MaxViews               int64
-	StartTime              time.Time
-	StreamerID             string
-	StreamID               string
-	StreamerLoginAtStart   string
-	GameNameAtStart        string
-	LanguageAtStart        string
-	TitleAtStart           string
-	IsMatureAtStart        bool
-	GameIDAtStart          string
-	BytesFound             sql.NullBool
-	Public                 sql.NullBool
-	HlsDurationSeconds     sql.NullFloat64
-	BoxArtUrlAtStart       sql.NullString
-	ProfileImageUrlAtStart sql.NullString
+type GetStreamSegmentsRow struct {
+	GameID      string
+	GameName    string
+	Title       string
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
 }
 
-func (q *Queries) GetPopularLiveStreamsByGameId(ctx context.Context, arg GetPopularLiveStreamsByGameIdParams) ([]*GetPopularLiveStreamsByGameIdRow, error) {
-	rows, err := q.db.Query(ctx, getPopularLiveStreamsByGameId, arg.GameIDAtStart, arg.Public, arg.Limit)
+func (q *Queries) GetStreamSegments(ctx context.Context, arg GetStreamSegmentsParams) ([]*GetStreamSegmentsRow, error) {
+	rows, err := q.db.Query(ctx, getStreamSegments, arg.StreamID, arg.StartTime)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []*GetPopularLiveStreamsByGameIdRow
+	var items []*GetStreamSegmentsRow
 	for rows.Next() {
-		var i GetPopularLiveStreamsByGameIdRow
+		var i GetStreamSegmentsRow
 		if err := rows.Scan(
-			&i.ID,
-			&i.MaxViews,
-			&i.StartTime,
-			&i.StreamerID,
-			&i.StreamID,
-			&i.StreamerLoginAtStart,
-			&i.GameNameAtStart,
-			&i.LanguageAtStart,
-			&i.TitleAtStart,
-			&i.IsMatureAtStart,
-			&i.GameIDAtStart,
-			&i.BytesFound,
-			&i.Public,
-			&i.HlsDurationSeconds,
-			&i.BoxArtUrlAtStart,
-			&i.ProfileImageUrlAtStart,
+			&i.GameID,
+			&i.GameName,
+			&i.Title,
+			&i.FirstSeenAt,
+			&i.LastSeenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -562,69 +783,92 @@ func (q *Queries) GetPopularLiveStreamsByGameId(ctx context.Context, arg GetPopu
 	return items, nil
 }
 
-const getPopularLiveStreamsByLanguage = `-- name: GetPopularLiveStreamsByLanguage :many
+const getStreamViewerSeries = `-- name: GetStreamViewerSeries :many
 SELECT
-  id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start
+  viewer_series
 FROM
   streams
 WHERE
-  language_at_start = $1 AND public = $2
+  stream_id = $1 AND
+  start_time = $2
+LIMIT 1
+`
+
+type GetStreamViewerSeriesParams struct {
+	StreamID  string
+	StartTime time.Time
+}
+
+func (q *Queries) GetStreamViewerSeries(ctx context.Context, arg GetStreamViewerSeriesParams) ([][]byte, error) {
+	rows, err := q.db.Query(ctx, getStreamViewerSeries, arg.StreamID, arg.StartTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items [][]byte
+	for rows.Next() {
+		var viewer_series []byte
+		if err := rows.Scan(&viewer_series); err != nil {
+			return nil, err
+		}
+		items = append(items, viewer_series)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStreamsToRetry = `-- name: GetStreamsToRetry :many
+SELECT
+  stream_id, streamer_id, streamer_login_at_start, game_id_at_start, start_time, max_views, last_updated_at, recording_fetched_at, hls_fetch_attempts
+FROM
+  streams
+WHERE
+  public = TRUE AND
+  bytes_found = FALSE AND
+  last_updated_at >= $1
 ORDER BY
-  max_views DESC, id DESC
-LIMIT $3
+  max_views DESC
+LIMIT $2
 `
 
-type GetPopularLiveStreamsByLanguageParams struct {
-	LanguageAtStart string
-	Public          sql.NullBool
-	Limit           int32
+type GetStreamsToRetryParams struct {
+	LastUpdatedAt time.Time
+	Limit         int32
 }
 
-type GetPopularLiveStreamsByLanguageRow struct {
-	ID                     uuid.UUID
-	MaxViews               int64
-	StartTime              time.Time
-	StreamerID             string
-	StreamID               string
-	StreamerLoginAtStart   string
-	GameNameAtStart        string
-	LanguageAtStart        string
-	TitleAtStart           string
-	IsMatureAtStart        bool
-	GameIDAtStart          string
-	BytesFound             sql.NullBool
-	Public                 sql.NullBool
-	HlsDurationSeconds     sql.NullFloat64
-	BoxArtUrlAtStart       sql.NullString
-	ProfileImageUrlAtStart sql.NullString
+type GetStreamsToRetryRow struct {
+	StreamID             string
+	StreamerID           string
+	StreamerLoginAtStart string
+	GameIDAtStart        string
+	StartTime            time.Time
+	MaxViews             int64
+	LastUpdatedAt        time.Time
+	RecordingFetchedAt   sql.NullTime
+	HlsFetchAttempts     int32
 }
 
-func (q *Queries) GetPopularLiveStreamsByLanguage(ctx context.Context, arg GetPopularLiveStreamsByLanguageParams) ([]*GetPopularLiveStreamsByLanguageRow, error) {
-	rows, err := q.db.Query(ctx, getPopularLiveStreamsByLanguage, arg.LanguageAtStart, arg.Public, arg.Limit)
+func (q *Queries) GetStreamsToRetry(ctx context.Context, arg GetStreamsToRetryParams) ([]*GetStreamsToRetryRow, error) {
+	rows, err := q.db.Query(ctx, getStreamsToRetry, arg.LastUpdatedAt, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []*GetPopularLiveStreamsByLanguageRow
+	var items []*GetStreamsToRetryRow
 	for rows.Next() {
-		var i GetPopularLiveStreamsByLanguageRow
+		var i GetStreamsToRetryRow
 		if err := rows.Scan(
-			&i.ID,
-			&i.MaxViews,
-			&i.StartTime,
-			&i.StreamerID,
 			&i.StreamID,
+			&i.StreamerID,
 			&i.StreamerLoginAtStart,
-			&i.GameNameAtStart,
-			&i.LanguageAtStart,
-			&i.TitleAtStart,
-			&i.IsMatureAtStart,
 			&i.GameIDAtStart,
-			&i.BytesFound,
-			&i.Public,
-			&i.HlsDurationSeconds,
-			&i.BoxArtUrlAtStart,
-			&i.ProfileImageUrlAtStart,
+			&i.StartTime,
+			&i.MaxViews,
+			&i.LastUpdatedAt,
+			&i.RecordingFetchedAt,
+			&i.HlsFetchAttempts,
 		); err != nil {
 			return nil, err
 		}
@@ -636,35 +880,62 @@ func (q *Queries) GetPopularLiveStreamsByLanguage(ctx context.Context, arg GetPo
 	return items, nil
 }
 
-const getStreamGzippedBytes = `-- name: GetStreamGzippedBytes :many
+const getStreamsToVerify = `-- name: GetStreamsToVerify :many
 SELECT
-  gzipped_bytes
+  stream_id, start_time, streamer_login_at_start, hls_domain, gzipped_bytes
 FROM
   streams
 WHERE
-  stream_id = $1 AND
-  start_time = $2
-LIMIT 1
+  bytes_found = TRUE AND
+  gzipped_bytes IS NOT NULL AND
+  (last_verified_at IS NULL OR
+    (hls_gone = FALSE AND hls_gone_checks = 0 AND last_verified_at < $1) OR
+    (hls_gone = FALSE AND hls_gone_checks > 0 AND last_verified_at < $2) OR
+    (hls_gone = TRUE AND last_verified_at < $3))
+ORDER BY
+  last_verified_at NULLS FIRST, start_time
+LIMIT $4
 `
 
-type GetStreamGzippedBytesParams struct {
-	StreamID  string
-	StartTime time.Time
+type GetStreamsToVerifyParams struct {
+	LastVerifiedAt      sql.NullTime
+	GoneCheckVerifiedAt sql.NullTime
+	GoneVerifiedAt      sql.NullTime
+	Limit               int32
 }
 
-func (q *Queries) GetStreamGzippedBytes(ctx context.Context, arg GetStreamGzippedBytesParams) ([][]byte, error) {
-	rows, err := q.db.Query(ctx, getStreamGzippedBytes, arg.StreamID, arg.StartTime)
+type GetStreamsToVerifyRow struct {
+	StreamID             string
+	StartTime            time.Time
+	StreamerLoginAtStart string
+	HlsDomain            sql.NullString
+	GzippedBytes         []byte
+}
+
+func (q *Queries) GetStreamsToVerify(ctx context.Context, arg GetStreamsToVerifyParams) ([]*GetStreamsToVerifyRow, error) {
+	rows, err := q.db.Query(ctx, getStreamsToVerify,
+		arg.LastVerifiedAt,
+		arg.GoneCheckVerifiedAt,
+		arg.GoneVerifiedAt,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items [][]byte
+	var items []*GetStreamsToVerifyRow
 	for rows.Next() {
-		var gzipped_bytes []byte
-		if err := rows.Scan(&gzipped_bytes); err != nil {
+		var i GetStreamsToVerifyRow
+		if err := rows.Scan(
+			&i.StreamID,
+			&i.StartTime,
+			&i.StreamerLoginAtStart,
+			&i.HlsDomain,
+			&i.GzippedBytes,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, gzipped_bytes)
+		items = append(items, &i)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -672,6 +943,32 @@ func (q *Queries) GetStreamGzippedBytes(ctx context.Context, arg GetStreamGzippe
 	return items, nil
 }
 
+const updateManyViewerSeries = `-- name: UpdateManyViewerSeries :exec
+UPDATE
+  streams
+SET
+  viewer_series = data.viewer_series
+FROM (
+  SELECT
+    unnest($1::TEXT[]) AS stream_id,
+    unnest($2::TIMESTAMP(3)[]) AS start_time,
+    unnest($3::BYTEA[]) AS viewer_series) AS data
+WHERE
+  streams.stream_id = data.stream_id AND
+  streams.start_time = data.start_time
+`
+
+type UpdateManyViewerSeriesParams struct {
+	StreamIDArr     []string
+	StartTimeArr    []time.Time
+	ViewerSeriesArr [][]byte
+}
+
+func (q *Queries) UpdateManyViewerSeries(ctx context.Context, arg UpdateManyViewerSeriesParams) error {
+	_, err := q.db.Exec(ctx, updateManyViewerSeries, arg.StreamIDArr, arg.StartTimeArr, arg.ViewerSeriesArr)
+	return err
+}
+
 const updateRecording = `-- name: UpdateRecording :exec
 UPDATE
   streams
@@ -683,7 +980,15 @@ SET
   public = $7,
   hls_duration_seconds = $8,
   profile_image_url_at_start = $9,
-  box_art_url_at_start = $10
+  box_art_url_at_start = $10,
+  hls_last_error = $11,
+  renditions = $12,
+  thumbnail_url = $13,
+  seek_previews_url = $14,
+  seek_preview_sprite_urls = $15,
+  hls_fetch_attempts = hls_fetch_attempts + 1,
+  hls_gone = FALSE,
+  hls_gone_checks = 0
 WHERE
   stream_id = $1 AND
   start_time = $2
@@ -700,6 +1005,11 @@ type UpdateRecordingParams struct {
 	HlsDurationSeconds     sql.NullFloat64
 	ProfileImageUrlAtStart sql.NullString
 	BoxArtUrlAtStart       sql.NullString
+	HlsLastError           sql.NullString
+	Renditions             []string
+	ThumbnailUrl           sql.NullString
+	SeekPreviewsUrl        sql.NullString
+	SeekPreviewSpriteUrls  []string
 }
 
 func (q *Queries) UpdateRecording(ctx context.Context, arg UpdateRecordingParams) error {
@@ -714,10 +1024,71 @@ func (q *Queries) UpdateRecording(ctx context.Context, arg UpdateRecordingParams
 		arg.HlsDurationSeconds,
 		arg.ProfileImageUrlAtStart,
 		arg.BoxArtUrlAtStart,
+		arg.HlsLastError,
+		arg.Renditions,
+		arg.ThumbnailUrl,
+		arg.SeekPreviewsUrl,
+		arg.SeekPreviewSpriteUrls,
 	)
 	return err
 }
 
+const updateRecordingNotFound = `-- name: UpdateRecordingNotFound :many
+UPDATE
+  streams
+SET
+  recording_fetched_at = $3,
+  bytes_found = COALESCE(bytes_found, FALSE),
+  public = $4,
+  profile_image_url_at_start = $5,
+  box_art_url_at_start = $6,
+  hls_last_error = $7,
+  hls_fetch_attempts = hls_fetch_attempts + 1
+WHERE
+  stream_id = $1 AND
+  start_time = $2
+RETURNING
+  bytes_found
+`
+
+type UpdateRecordingNotFoundParams struct {
+	StreamID               string
+	StartTime              time.Time
+	RecordingFetchedAt     sql.NullTime
+	Public                 sql.NullBool
+	ProfileImageUrlAtStart sql.NullString
+	BoxArtUrlAtStart       sql.NullString
+	HlsLastError           sql.NullString
+}
+
+func (q *Queries) UpdateRecordingNotFound(ctx context.Context, arg UpdateRecordingNotFoundParams) ([]sql.NullBool, error) {
+	rows, err := q.db.Query(ctx, updateRecordingNotFound,
+		arg.StreamID,
+		arg.StartTime,
+		arg.RecordingFetchedAt,
+		arg.Public,
+		arg.ProfileImageUrlAtStart,
+		arg.BoxArtUrlAtStart,
+		arg.HlsLastError,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sql.NullBool
+	for rows.Next() {
+		var bytes_found sql.NullBool
+		if err := rows.Scan(&bytes_found); err != nil {
+			return nil, err
+		}
+		items = append(items, bytes_found)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const updateStreamer = `-- name: UpdateStreamer :exec
 UPDATE
   streamers
@@ -737,6 +1108,172 @@ func (q *Queries) UpdateStreamer(ctx context.Context, arg UpdateStreamerParams)
 	return err
 }
 
+const updateVerification = `-- name: UpdateVerification :exec
+UPDATE
+  streams
+SET
+  last_verified_at = $3,
+  hls_gone = FALSE,
+  hls_gone_checks = 0
+WHERE
+  stream_id = $1 AND
+  start_time = $2
+`
+
+type UpdateVerificationParams struct {
+	StreamID       string
+	StartTime      time.Time
+	LastVerifiedAt sql.NullTime
+}
+
+func (q *Queries) UpdateVerification(ctx context.Context, arg UpdateVerificationParams) error {
+	_, err := q.db.Exec(ctx, updateVerification, arg.StreamID, arg.StartTime, arg.LastVerifiedAt)
+	return err
+}
+
+const updateVerificationFailed = `-- name: UpdateVerificationFailed :exec
+UPDATE
+  streams
+SET
+  last_verified_at = $3
+WHERE
+  stream_id = $1 AND
+  start_time = $2
+`
+
+type UpdateVerificationFailedParams struct {
+	StreamID       string
+	StartTime      time.Time
+	LastVerifiedAt sql.NullTime
+}
+
+func (q *Queries) UpdateVerificationFailed(ctx context.Context, arg UpdateVerificationFailedParams) error {
+	_, err := q.db.Exec(ctx, updateVerificationFailed, arg.StreamID, arg.StartTime, arg.LastVerifiedAt)
+	return err
+}
+
+const updateVerificationMissing = `-- name: UpdateVerificationMissing :many
+UPDATE
+  streams
+SET
+  last_verified_at = $1,
+  hls_gone = hls_gone_checks + 1 >= $2::INT,
+  hls_gone_checks = hls_gone_checks + 1
+WHERE
+  stream_id = $3 AND
+  start_time = $4
+RETURNING
+  hls_gone
+`
+
+type UpdateVerificationMissingParams struct {
+	LastVerifiedAt  sql.NullTime
+	GoneAfterChecks int32
+	StreamID        string
+	StartTime       time.Time
+}
+
+func (q *Queries) UpdateVerificationMissing(ctx context.Context, arg UpdateVerificationMissingParams) ([]bool, error) {
+	rows, err := q.db.Query(ctx, updateVerificationMissing,
+		arg.LastVerifiedAt,
+		arg.GoneAfterChecks,
+		arg.StreamID,
+		arg.StartTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []bool
+	for rows.Next() {
+		var hls_gone bool
+		if err := rows.Scan(&hls_gone); err != nil {
+			return nil, err
+		}
+		items = append(items, hls_gone)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateVerifiedRecording = `-- name: UpdateVerifiedRecording :exec
+UPDATE
+  streams
+SET
+  last_verified_at = $1,
+  gzipped_bytes = $2,
+  hls_duration_seconds = $3,
+  hls_remuted_at = CASE WHEN $4::BOOLEAN THEN $1 ELSE hls_remuted_at END,
+  hls_gone = FALSE,
+  hls_gone_checks = 0
+WHERE
+  stream_id = $5 AND
+  start_time = $6
+`
+
+type UpdateVerifiedRecordingParams struct {
+	LastVerifiedAt     sql.NullTime
+	GzippedBytes       []byte
+	HlsDurationSeconds sql.NullFloat64
+	Remuted            bool
+	StreamID           string
+	StartTime          time.Time
+}
+
+func (q *Queries) UpdateVerifiedRecording(ctx context.Context, arg UpdateVerifiedRecordingParams) error {
+	_, err := q.db.Exec(ctx, updateVerifiedRecording,
+		arg.LastVerifiedAt,
+		arg.GzippedBytes,
+		arg.HlsDurationSeconds,
+		arg.Remuted,
+		arg.StreamID,
+		arg.StartTime,
+	)
+	return err
+}
+
+const upsertManyStreamSegments = `-- name: UpsertManyStreamSegments :exec
+INSERT INTO
+  stream_segments (stream_id, start_time, game_id, game_name, title, first_seen_at, last_seen_at)
+SELECT
+  unnest($1::TEXT[]) AS stream_id,
+  unnest($2::TIMESTAMP(3)[]) AS start_time,
+  unnest($3::TEXT[]) AS game_id,
+  unnest($4::TEXT[]) AS game_name,
+  unnest($5::TEXT[]) AS title,
+  unnest($6::TIMESTAMP(3)[]) AS first_seen_at,
+  unnest($6::TIMESTAMP(3)[]) AS last_seen_at
+ON CONFLICT
+  (stream_id, start_time, game_id, title)
+DO
+  UPDATE SET
+    game_name = EXCLUDED.game_name,
+    last_seen_at = GREATEST(stream_segments.last_seen_at, EXCLUDED.last_seen_at)
+`
+
+type UpsertManyStreamSegmentsParams struct {
+	StreamIDArr  []string
+	StartTimeArr []time.Time
+	GameIDArr    []string
+	GameNameArr  []string
+	TitleArr     []string
+	SeenAtArr    []time.Time
+}
+
+func (q *Queries) UpsertManyStreamSegments(ctx context.Context, arg UpsertManyStreamSegmentsParams) error {
+	_, err := q.db.Exec(ctx, upsertManyStreamSegments,
+		arg.StreamIDArr,
+		arg.StartTimeArr,
+		arg.GameIDArr,
+		arg.GameNameArr,
+		arg.TitleArr,
+		arg.SeenAtArr,
+	)
+	return err
+}
+
 const upsertManyStreamers = `-- name: UpsertManyStreamers :exec
 INSERT INTO
   streamers (streamer_id, start_time, streamer_login_at_start)
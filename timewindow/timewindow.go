@@ -0,0 +1,70 @@
+// Package timewindow parses the since, until and window query parameters of the list endpoints
+// into a range of stream start times.
+package timewindow
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidWindow = errors.New("invalid time window")
+
+// Streams that started in [Since, Until).
+type Window struct {
+	Since time.Time
+	Until time.Time
+}
+
+// Every stream started in it, so it is the window if no parameters are given.
+var All = Window{Since: time.Unix(0, 0).UTC(), Until: time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)}
+
+// Returns the start of a preset window that ends now.
+var presets = map[string]func(now time.Time) time.Time{
+	// since midnight UTC
+	"today": func(now time.Time) time.Time {
+		year, month, day := now.UTC().Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	},
+	"24h": func(now time.Time) time.Time { return now.Add(-24 * time.Hour) },
+	"7d":  func(now time.Time) time.Time { return now.Add(-7 * 24 * time.Hour) },
+	"30d": func(now time.Time) time.Time { return now.Add(-30 * 24 * time.Hour) },
+}
+
+func parseUnix(unix string) (time.Time, error) {
+	value, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidWindow
+	}
+	return time.Unix(value, 0).UTC(), nil
+}
+
+// since and until are Unix times in seconds, and preset is one of today, 24h, 7d and 30d.
+// A preset can't be combined with since, but it can be with until. Empty parameters leave that side of the window open.
+func Parse(since, until, preset string, now time.Time) (Window, error) {
+	window := All
+	var err error
+	if preset != "" {
+		start, ok := presets[preset]
+		if !ok || since != "" {
+			return Window{}, ErrInvalidWindow
+		}
+		window.Since = start(now).UTC()
+	}
+	if since != "" {
+		window.Since, err = parseUnix(since)
+		if err != nil {
+			return Window{}, err
+		}
+	}
+	if until != "" {
+		window.Until, err = parseUnix(until)
+		if err != nil {
+			return Window{}, err
+		}
+	}
+	if !window.Since.Before(window.Until) {
+		return Window{}, ErrInvalidWindow
+	}
+	return window, nil
+}
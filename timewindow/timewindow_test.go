@@ -0,0 +1,59 @@
+package timewindow
+
+import (
+	"testing"
+	"time"
+)
+
+func assertEqual[T comparable](t testing.TB, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+var testNow = time.Date(2023, 5, 21, 8, 30, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	window, err := Parse("", "", "", testNow)
+	assertEqual(t, err, nil)
+	assertEqual(t, window, All)
+	window, err = Parse("1684656000", "1684659600", "", testNow)
+	assertEqual(t, err, nil)
+	assertEqual(t, window.Since, time.Unix(1684656000, 0).UTC())
+	assertEqual(t, window.Until, time.Unix(1684659600, 0).UTC())
+	window, err = Parse("1684656000", "", "", testNow)
+	assertEqual(t, err, nil)
+	assertEqual(t, window.Until, All.Until)
+}
+
+func TestParsePresets(t *testing.T) {
+	window, err := Parse("", "", "today", testNow)
+	assertEqual(t, err, nil)
+	assertEqual(t, window.Since, time.Date(2023, 5, 21, 0, 0, 0, 0, time.UTC))
+	assertEqual(t, window.Until, All.Until)
+	window, err = Parse("", "", "24h", testNow)
+	assertEqual(t, err, nil)
+	assertEqual(t, window.Since, testNow.Add(-24*time.Hour))
+	window, err = Parse("", "", "7d", testNow)
+	assertEqual(t, err, nil)
+	assertEqual(t, window.Since, testNow.Add(-7*24*time.Hour))
+	window, err = Parse("", "1684656000", "30d", testNow)
+	assertEqual(t, err, nil)
+	assertEqual(t, window.Since, testNow.Add(-30*24*time.Hour))
+	assertEqual(t, window.Until, time.Unix(1684656000, 0).UTC())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, params := range [][3]string{
+		{"", "", "yesterday"},
+		{"1684656000", "", "24h"},
+		{"soon", "", ""},
+		{"", "later", ""},
+		{"1684659600", "1684656000", ""},
+		{"1684656000", "1684656000", ""},
+	} {
+		_, err := Parse(params[0], params[1], params[2], testNow)
+		assertEqual(t, err, ErrInvalidWindow)
+	}
+}
@@ -0,0 +1,81 @@
+// Package trigram computes the similarity functions of the Postgres pg_trgm extension, so that the in-memory store
+// ranks text searches like Postgres does (see "Text Search" in NOTES.md).
+package trigram
+
+import (
+	"strings"
+	"unicode"
+)
+
+// The defaults of pg_trgm.similarity_threshold and pg_trgm.word_similarity_threshold,
+// i.e. the thresholds of the % and <% operators.
+const (
+	SimilarityThreshold     = 0.3
+	WordSimilarityThreshold = 0.6
+)
+
+// Returns the trigrams of s in order. Like pg_trgm, s is lowercased and split into words of letters and digits,
+// and each word is padded with two spaces in front and one behind, so "Cat" has the trigrams "  c", " ca", "cat" and "at ".
+func Trigrams(s string) []string {
+	trigrams := []string{}
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			trigrams = append(trigrams, string(padded[i:i+3]))
+		}
+	}
+	return trigrams
+}
+
+func toSet(trigrams []string) map[string]bool {
+	set := map[string]bool{}
+	for _, trigram := range trigrams {
+		set[trigram] = true
+	}
+	return set
+}
+
+// The number of shared trigrams divided by the number of distinct trigrams of both, like similarity(a, b).
+func Similarity(a, b string) float64 {
+	setA := toSet(Trigrams(a))
+	setB := toSet(Trigrams(b))
+	shared := 0
+	for trigram := range setA {
+		if setB[trigram] {
+			shared++
+		}
+	}
+	if len(setA)+len(setB) == 0 {
+		return 0
+	}
+	return float64(shared) / float64(len(setA)+len(setB)-shared)
+}
+
+// The greatest similarity between the trigrams of a and any run of consecutive trigrams of b, like word_similarity(a, b).
+// It is high if a matches a part of b, e.g. a word of a long title.
+func WordSimilarity(a, b string) float64 {
+	setA := toSet(Trigrams(a))
+	trigramsB := Trigrams(b)
+	best := 0.0
+	for start := range trigramsB {
+		extent := map[string]bool{}
+		shared := 0
+		for _, trigram := range trigramsB[start:] {
+			if extent[trigram] {
+				continue
+			}
+			extent[trigram] = true
+			if setA[trigram] {
+				shared++
+			}
+			similarity := float64(shared) / float64(len(setA)+len(extent)-shared)
+			if similarity > best {
+				best = similarity
+			}
+		}
+	}
+	return best
+}
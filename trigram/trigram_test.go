@@ -0,0 +1,43 @@
+package trigram
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func assertEqual[T comparable](t testing.TB, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+func assertAbout(t testing.TB, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-6 {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+func TestTrigrams(t *testing.T) {
+	assertEqual(t, strings.Join(Trigrams("Cat"), "|"), "  c| ca|cat|at ")
+	assertEqual(t, strings.Join(Trigrams("a-B"), "|"), "  a| a |  b| b ")
+	assertEqual(t, len(Trigrams("!!!")), 0)
+}
+
+// The examples of the pg_trgm documentation.
+func TestSimilarity(t *testing.T) {
+	assertAbout(t, Similarity("word", "two words"), 4.0/11)
+	assertAbout(t, WordSimilarity("word", "two words"), 0.8)
+	assertAbout(t, Similarity("word", "word"), 1)
+	assertAbout(t, Similarity("", ""), 0)
+}
+
+func TestWordSimilarityFindsWordsInLongText(t *testing.T) {
+	title := "NEW SPEEDRUN WORLD RECORD ATTEMPTS | !discord !socials"
+	assertEqual(t, WordSimilarity("speedrun", title) >= WordSimilarityThreshold, true)
+	assertEqual(t, WordSimilarity("speedrn", title) >= WordSimilarityThreshold, true)
+	assertEqual(t, Similarity("speedrun", title) >= SimilarityThreshold, false)
+	assertEqual(t, WordSimilarity("minecraft", title) >= WordSimilarityThreshold, false)
+}
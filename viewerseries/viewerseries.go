@@ -0,0 +1,169 @@
+// Package viewerseries records the viewer counts, games and titles of a live stream over time
+// and encodes them compactly for the streams.viewer_series column.
+package viewerseries
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The viewer count of a stream from Unix until the next sample.
+// It is the highest viewer count observed in that interval.
+type Sample struct {
+	Unix    int64
+	Viewers int
+}
+
+// The game and title of a stream from Unix until the next change.
+type Change struct {
+	Unix     int64
+	GameId   string
+	GameName string
+	Title    string
+}
+
+type Series struct {
+	Samples []Sample
+	Changes []Change
+}
+
+// Records an observation of the stream at unix.
+// A new sample is started if the last one started at least interval ago. Otherwise the last sample keeps the highest viewer count.
+// A change is recorded only if the game or title differs from the last change.
+func (series *Series) Observe(unix int64, viewers int, gameId, gameName, title string, interval time.Duration) {
+	numSamples := len(series.Samples)
+	if numSamples == 0 || unix-series.Samples[numSamples-1].Unix >= int64(interval/time.Second) {
+		series.Samples = append(series.Samples, Sample{Unix: unix, Viewers: viewers})
+	} else if viewers > series.Samples[numSamples-1].Viewers {
+		series.Samples[numSamples-1].Viewers = viewers
+	}
+	numChanges := len(series.Changes)
+	if numChanges == 0 || series.Changes[numChanges-1].GameId != gameId || series.Changes[numChanges-1].Title != title {
+		series.Changes = append(series.Changes, Change{Unix: unix, GameId: gameId, GameName: gameName, Title: title})
+	}
+}
+
+func (series *Series) Clone() *Series {
+	return &Series{
+		Samples: append([]Sample{}, series.Samples...),
+		Changes: append([]Change{}, series.Changes...),
+	}
+}
+
+const version = 1
+
+var ErrInvalid = errors.New("invalid viewer series")
+
+// EncodeAll and DecodeAll are safe for concurrent use.
+var (
+	encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	decoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+)
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Times and viewer counts are stored as varint deltas from the previous sample or change, then the whole series is zstd compressed.
+func Encode(series *Series) []byte {
+	buf := []byte{version}
+	buf = binary.AppendUvarint(buf, uint64(len(series.Samples)))
+	prev := Sample{}
+	for _, sample := range series.Samples {
+		buf = binary.AppendVarint(buf, sample.Unix-prev.Unix)
+		buf = binary.AppendVarint(buf, int64(sample.Viewers-prev.Viewers))
+		prev = sample
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(series.Changes)))
+	prevUnix := int64(0)
+	for _, change := range series.Changes {
+		buf = binary.AppendVarint(buf, change.Unix-prevUnix)
+		buf = appendString(buf, change.GameId)
+		buf = appendString(buf, change.GameName)
+		buf = appendString(buf, change.Title)
+		prevUnix = change.Unix
+	}
+	return encoder.EncodeAll(buf, nil)
+}
+
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) varint() int64 {
+	value, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = ErrInvalid
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return value
+}
+
+func (r *reader) uvarint() uint64 {
+	value, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = ErrInvalid
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return value
+}
+
+// Every element takes at least one byte, so a length longer than the rest of the buffer is invalid.
+func (r *reader) length() int {
+	length := r.uvarint()
+	if length > uint64(len(r.buf)) {
+		r.err = ErrInvalid
+		return 0
+	}
+	return int(length)
+}
+
+func (r *reader) string() string {
+	length := r.length()
+	s := string(r.buf[:length])
+	r.buf = r.buf[length:]
+	return s
+}
+
+func Decode(compressed []byte) (*Series, error) {
+	buf, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 || buf[0] != version {
+		return nil, ErrInvalid
+	}
+	r := &reader{buf: buf[1:]}
+	series := &Series{Samples: []Sample{}, Changes: []Change{}}
+	numSamples := r.length()
+	prev := Sample{}
+	for i := 0; i < numSamples && r.err == nil; i++ {
+		prev.Unix += r.varint()
+		prev.Viewers += int(r.varint())
+		series.Samples = append(series.Samples, prev)
+	}
+	numChanges := r.length()
+	prevUnix := int64(0)
+	for i := 0; i < numChanges && r.err == nil; i++ {
+		prevUnix += r.varint()
+		change := Change{Unix: prevUnix}
+		change.GameId = r.string()
+		change.GameName = r.string()
+		change.Title = r.string()
+		series.Changes = append(series.Changes, change)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.buf) != 0 {
+		return nil, ErrInvalid
+	}
+	return series, nil
+}
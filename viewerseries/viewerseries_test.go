@@ -0,0 +1,76 @@
+package viewerseries
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestObserveDownsamples(t *testing.T) {
+	series := &Series{}
+	series.Observe(1000, 10, "g1", "Game 1", "title", time.Minute)
+	series.Observe(1030, 30, "g1", "Game 1", "title", time.Minute)
+	series.Observe(1040, 20, "g1", "Game 1", "new title", time.Minute)
+	series.Observe(1060, 5, "g2", "Game 2", "new title", time.Minute)
+	want := &Series{
+		Samples: []Sample{{Unix: 1000, Viewers: 30}, {Unix: 1060, Viewers: 5}},
+		Changes: []Change{
+			{Unix: 1000, GameId: "g1", GameName: "Game 1", Title: "title"},
+			{Unix: 1040, GameId: "g1", GameName: "Game 1", Title: "new title"},
+			{Unix: 1060, GameId: "g2", GameName: "Game 2", Title: "new title"},
+		},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Fatalf("got %+v want %+v", series, want)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	series := &Series{}
+	for i := 0; i < 500; i++ {
+		series.Observe(1684656000+int64(i)*300, 20000+(i%7)*100-i, "509658", "Just Chatting", "title", 5*time.Minute)
+	}
+	series.Observe(1684656000+500*300, 0, "32982", "Grand Theft Auto V", "ünïcode title", 5*time.Minute)
+	encoded := Encode(series)
+	if len(encoded) > 1000 {
+		t.Fatalf("encoded %v samples in %v bytes", len(series.Samples), len(encoded))
+	}
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, series) {
+		t.Fatalf("got %+v want %+v", decoded, series)
+	}
+}
+
+func TestDecodeEmpty(t *testing.T) {
+	decoded, err := Decode(Encode(&Series{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Samples) != 0 || len(decoded.Changes) != 0 {
+		t.Fatalf("got %+v want empty series", decoded)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	valid := &Series{}
+	valid.Observe(1000, 10, "g1", "Game 1", "title", time.Minute)
+	for _, raw := range [][]byte{
+		{},
+		{2, 0, 0},
+		{version, 5},
+		{version, 0, 1, 2, 10},
+		{version, 0, 0, 0},
+	} {
+		_, err := Decode(encoder.EncodeAll(raw, nil))
+		if err != ErrInvalid {
+			t.Fatalf("decoding %v: got %v want %v", raw, err, ErrInvalid)
+		}
+	}
+	_, err := Decode([]byte("not zstd"))
+	if err == nil {
+		t.Fatal("expected an error for bytes that are not zstd")
+	}
+}
@@ -0,0 +1,851 @@
+package vodstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/trigram"
+	"github.com/google/uuid"
+)
+
+type streamKey struct {
+	streamId           string
+	startTimeUnixMilli int64
+}
+
+func getStreamKey(streamId string, startTime time.Time) streamKey {
+	// The columns are TIMESTAMP(3), so Postgres only distinguishes times up to the millisecond.
+	return streamKey{streamId: streamId, startTimeUnixMilli: startTime.UnixMilli()}
+}
+
+// Memory is a Store that keeps every row in memory.
+// It is meant for tests and for running the scraper without Postgres.
+// The queries mimic the SQL in ./sqlc/queries.sql closely enough for the scraper and the string API,
+// but they are not a complete emulation of Postgres (e.g. ties are broken arbitrarily).
+type Memory struct {
+	mu        sync.RWMutex
+	streams   map[streamKey]*sqlvods.Stream
+	streamers map[string]*sqlvods.Streamer // keyed by streamer_login_at_start
+	segments  map[streamKey]map[segmentKey]*sqlvods.StreamSegment
+	now       func() time.Time
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		streams:   map[streamKey]*sqlvods.Stream{},
+		streamers: map[string]*sqlvods.Streamer{},
+		segments:  map[streamKey]map[segmentKey]*sqlvods.StreamSegment{},
+		now:       time.Now,
+	}
+}
+
+type segmentKey struct {
+	gameId string
+	title  string
+}
+
+// Reports whether the stream was seen playing the game.
+func (m *Memory) playedGame(stream *sqlvods.Stream, gameId string) bool {
+	for key := range m.segments[getStreamKey(stream.StreamID, stream.StartTime)] {
+		if key.gameId == gameId {
+			return true
+		}
+	}
+	return false
+}
+
+func compareUUID(a, b uuid.UUID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+func nullBoolEquals(column sql.NullBool, arg sql.NullBool) bool {
+	// NULL = x is never true in SQL.
+	return column.Valid && arg.Valid && column.Bool == arg.Bool
+}
+
+// start_time >= since AND start_time < until in SQL.
+func startedBetween(stream *sqlvods.Stream, since time.Time, until time.Time) bool {
+	return !stream.StartTime.Before(since) && stream.StartTime.Before(until)
+}
+
+// (max_views, id) < (maxViews, id) in SQL.
+func viewsBefore(stream *sqlvods.Stream, maxViews int64, id uuid.UUID) bool {
+	if stream.MaxViews != maxViews {
+		return stream.MaxViews < maxViews
+	}
+	return compareUUID(stream.ID, id) < 0
+}
+
+// Returns copies of the streams matching keep ordered by (max_views, id) DESC.
+func (m *Memory) popularStreams(keep func(*sqlvods.Stream) bool, limit int32) []sqlvods.Stream {
+	results := []sqlvods.Stream{}
+	for _, stream := range m.streams {
+		if keep(stream) {
+			results = append(results, *stream)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].MaxViews != results[j].MaxViews {
+			return results[i].MaxViews > results[j].MaxViews
+		}
+		return compareUUID(results[i].ID, results[j].ID) > 0
+	})
+	return truncate(results, limit)
+}
+
+// The list queries select the same columns as SearchStreams, so their row types are convertible to SearchStreamsRow.
+func toSearchStreamsRow(stream *sqlvods.Stream) SearchStreamsRow {
+	return SearchStreamsRow{
+		ID:                     stream.ID,
+		MaxViews:               stream.MaxViews,
+		StartTime:              stream.StartTime,
+		StreamerID:             stream.StreamerID,
+		StreamID:               stream.StreamID,
+		StreamerLoginAtStart:   stream.StreamerLoginAtStart,
+		GameNameAtStart:        stream.GameNameAtStart,
+		LanguageAtStart:        stream.LanguageAtStart,
+		TitleAtStart:           stream.TitleAtStart,
+		IsMatureAtStart:        stream.IsMatureAtStart,
+		GameIDAtStart:          stream.GameIDAtStart,
+		BytesFound:             stream.BytesFound,
+		Public:                 stream.Public,
+		HlsDurationSeconds:     stream.HlsDurationSeconds,
+		BoxArtUrlAtStart:       stream.BoxArtUrlAtStart,
+		ProfileImageUrlAtStart: stream.ProfileImageUrlAtStart,
+		ThumbnailUrl:           stream.ThumbnailUrl,
+		HlsGone:                stream.HlsGone,
+	}
+}
+
+func truncate[T any](vals []T, limit int32) []T {
+	if limit >= 0 && int(limit) < len(vals) {
+		return vals[:limit]
+	}
+	return vals
+}
+
+// Returns the keys of the oldest limit streams matching keep ordered by start_time.
+func (m *Memory) oldestStreamKeys(keep func(*sqlvods.Stream) bool, limit int32) []streamKey {
+	keys := []streamKey{}
+	for key, stream := range m.streams {
+		if keep(stream) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].startTimeUnixMilli < keys[j].startTimeUnixMilli
+	})
+	return truncate(keys, limit)
+}
+
+func (m *Memory) ClearOldRecordings(ctx context.Context, arg sqlvods.ClearOldRecordingsParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := m.oldestStreamKeys(func(stream *sqlvods.Stream) bool {
+		return stream.StartTime.Before(arg.StartTime) && stream.GzippedBytes != nil
+	}, arg.Limit)
+	for _, key := range keys {
+		m.streams[key].GzippedBytes = nil
+		m.streams[key].BytesFound = sql.NullBool{Bool: false, Valid: true}
+		m.streams[key].Renditions = nil
+	}
+	return int64(len(keys)), nil
+}
+
+func (m *Memory) DeleteOldStreamers(ctx context.Context, arg sqlvods.DeleteOldStreamersParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	logins := []string{}
+	for login, streamer := range m.streamers {
+		if streamer.StartTime.Before(arg.StartTime) {
+			logins = append(logins, login)
+		}
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		return m.streamers[logins[i]].StartTime.Before(m.streamers[logins[j]].StartTime)
+	})
+	logins = truncate(logins, arg.Limit)
+	for _, login := range logins {
+		delete(m.streamers, login)
+	}
+	return int64(len(logins)), nil
+}
+
+func (m *Memory) DeleteOldStreams(ctx context.Context, arg sqlvods.DeleteOldStreamsParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := m.oldestStreamKeys(func(stream *sqlvods.Stream) bool {
+		return stream.StartTime.Before(arg.StartTime)
+	}, arg.Limit)
+	for _, key := range keys {
+		delete(m.streams, key)
+		// The foreign key of stream_segments cascades.
+		delete(m.segments, key)
+	}
+	return int64(len(keys)), nil
+}
+
+func (m *Memory) DeleteStreams(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.streams = map[streamKey]*sqlvods.Stream{}
+	m.segments = map[streamKey]map[segmentKey]*sqlvods.StreamSegment{}
+	return nil
+}
+
+func (m *Memory) GetEverything(ctx context.Context) ([]*sqlvods.Stream, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	items := []*sqlvods.Stream{}
+	for _, stream := range m.streams {
+		streamCopy := *stream
+		items = append(items, &streamCopy)
+	}
+	return items, nil
+}
+
+func (m *Memory) GetLanguages(ctx context.Context) ([]*sqlvods.GetLanguagesRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	oneDayAgo := m.now().UTC().Add(-24 * time.Hour)
+	counts := map[string]int64{}
+	for _, stream := range m.streams {
+		if stream.LastUpdatedAt.After(oneDayAgo) {
+			counts[stream.LanguageAtStart]++
+		}
+	}
+	items := []*sqlvods.GetLanguagesRow{}
+	for language, count := range counts {
+		items = append(items, &sqlvods.GetLanguagesRow{Count: count, LanguageAtStart: language})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].LanguageAtStart < items[j].LanguageAtStart
+	})
+	return items, nil
+}
+
+func (m *Memory) GetLatestLiveStreams(ctx context.Context, lastUpdatedAt time.Time) ([]*sqlvods.GetLatestLiveStreamsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	items := []*sqlvods.GetLatestLiveStreamsRow{}
+	for _, stream := range m.streams {
+		if stream.LastUpdatedAt.Before(lastUpdatedAt) || stream.BytesFound.Valid {
+			continue
+		}
+		items = append(items, &sqlvods.GetLatestLiveStreamsRow{
+			ID:                   stream.ID,
+			StreamID:             stream.StreamID,
+			StreamerID:           stream.StreamerID,
+			StreamerLoginAtStart: stream.StreamerLoginAtStart,
+			GameIDAtStart:        stream.GameIDAtStart,
+			StartTime:            stream.StartTime,
+			MaxViews:             stream.MaxViews,
+			LastUpdatedAt:        stream.LastUpdatedAt,
+		})
+	}
+	return items, nil
+}
+
+func (m *Memory) GetLatestStreams(ctx context.Context, limit int32) ([]*sqlvods.GetLatestStreamsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	items := []*sqlvods.GetLatestStreamsRow{}
+	for _, stream := range m.streams {
+		items = append(items, &sqlvods.GetLatestStreamsRow{
+			ID:            stream.ID,
+			StreamID:      stream.StreamID,
+			StreamerID:    stream.StreamerID,
+			StartTime:     stream.StartTime,
+			MaxViews:      stream.MaxViews,
+			LastUpdatedAt: stream.LastUpdatedAt,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].LastUpdatedAt.After(items[j].LastUpdatedAt)
+	})
+	return truncate(items, limit), nil
+}
+
+func (m *Memory) GetLatestStreamsFromStreamerLogin(ctx context.Context, arg sqlvods.GetLatestStreamsFromStreamerLoginParams) ([]*sqlvods.GetLatestStreamsFromStreamerLoginRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var latest *sqlvods.Stream
+	for _, stream := range m.streams {
+		if stream.StreamerLoginAtStart != arg.StreamerLoginAtStart {
+			continue
+		}
+		if latest == nil || stream.StartTime.After(latest.StartTime) {
+			latest = stream
+		}
+	}
+	items := []*sqlvods.GetLatestStreamsFromStreamerLoginRow{}
+	if latest == nil {
+		return items, nil
+	}
+	streams := []sqlvods.Stream{}
+	for _, stream := range m.streams {
+		if stream.StreamerID == latest.StreamerID && startedBetween(stream, arg.Since, arg.CursorStartTime) {
+			streams = append(streams, *stream)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].StartTime.After(streams[j].StartTime)
+	})
+	for _, stream := range truncate(streams, arg.Limit) {
+		row := sqlvods.GetLatestStreamsFromStreamerLoginRow(toSearchStreamsRow(&stream))
+		items = append(items, &row)
+	}
+	return items, nil
+}
+
+// Translates an ILIKE pattern into a case insensitive regular expression.
+func iLikeToRegexp(pattern string) (*regexp.Regexp, error) {
+	builder := strings.Builder{}
+	builder.WriteString("(?is)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			builder.WriteString(".*")
+		case r == '_':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
+// Ranks like Postgres with the similarity functions of ./trigram.
+func (m *Memory) GetMatchingStreamers(ctx context.Context, arg sqlvods.GetMatchingStreamersParams) ([]*sqlvods.GetMatchingStreamersRow, error) {
+	pattern, err := iLikeToRegexp(arg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	streamCounts := map[string]int64{}
+	for _, stream := range m.streams {
+		streamCounts[stream.StreamerLoginAtStart]++
+	}
+	type match struct {
+		streamer       *sqlvods.Streamer
+		wordSimilarity float64
+		similarity     float64
+	}
+	matches := []match{}
+	for login, streamer := range m.streamers {
+		wordSimilarity := trigram.WordSimilarity(arg.Query, login)
+		if wordSimilarity >= trigram.WordSimilarityThreshold || pattern.MatchString(login) {
+			matches = append(matches, match{streamer: streamer, wordSimilarity: wordSimilarity, similarity: trigram.Similarity(arg.Query, login)})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		loginA, loginB := a.streamer.StreamerLoginAtStart, b.streamer.StreamerLoginAtStart
+		if (loginA == arg.Query) != (loginB == arg.Query) {
+			return loginA == arg.Query
+		}
+		if a.wordSimilarity != b.wordSimilarity {
+			return a.wordSimilarity > b.wordSimilarity
+		}
+		if a.similarity != b.similarity {
+			return a.similarity > b.similarity
+		}
+		if streamCounts[loginA] != streamCounts[loginB] {
+			return streamCounts[loginA] > streamCounts[loginB]
+		}
+		return loginA < loginB
+	})
+	items := []*sqlvods.GetMatchingStreamersRow{}
+	for _, match := range truncate(matches, arg.Limit) {
+		items = append(items, &sqlvods.GetMatchingStreamersRow{
+			ProfileImageUrlAtStart: match.streamer.ProfileImageUrlAtStart,
+			StreamerLoginAtStart:   match.streamer.StreamerLoginAtStart,
+			LastStreamStartTime:    match.streamer.StartTime,
+			StreamCount:            streamCounts[match.streamer.StreamerLoginAtStart],
+		})
+	}
+	return items, nil
+}
+
+// Ranks like Postgres with the similarity functions of ./trigram.
+func (m *Memory) GetMatchingTitles(ctx context.Context, arg sqlvods.GetMatchingTitlesParams) ([]*sqlvods.GetMatchingTitlesRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ranks := map[uuid.UUID]float64{}
+	streams := []*sqlvods.Stream{}
+	for _, stream := range m.streams {
+		rank := max(trigram.WordSimilarity(arg.Query, stream.TitleAtStart), trigram.WordSimilarity(arg.Query, stream.GameNameAtStart))
+		if rank >= trigram.WordSimilarityThreshold && startedBetween(stream, arg.Since, arg.Until) {
+			ranks[stream.ID] = rank
+			streams = append(streams, stream)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if ranks[streams[i].ID] != ranks[streams[j].ID] {
+			return ranks[streams[i].ID] > ranks[streams[j].ID]
+		}
+		if streams[i].MaxViews != streams[j].MaxViews {
+			return streams[i].MaxViews > streams[j].MaxViews
+		}
+		return compareUUID(streams[i].ID, streams[j].ID) > 0
+	})
+	items := []*sqlvods.GetMatchingTitlesRow{}
+	for _, stream := range truncate(streams, arg.Limit) {
+		row := sqlvods.GetMatchingTitlesRow(toSearchStreamsRow(stream))
+		items = append(items, &row)
+	}
+	return items, nil
+}
+
+func (m *Memory) GetPopularCategories(ctx context.Context, limit int32) ([]*sqlvods.GetPopularCategoriesRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	type category struct {
+		gameName string
+		gameId   string
+	}
+	oneDayAgo := m.now().UTC().Add(-24 * time.Hour)
+	counts := map[category]int64{}
+	for _, segments := range m.segments {
+		// A stream is counted once per category even if it was seen with several titles.
+		seen := map[category]bool{}
+		for _, segment := range segments {
+			if segment.LastSeenAt.After(oneDayAgo) {
+				seen[category{gameName: segment.GameName, gameId: segment.GameID}] = true
+			}
+		}
+		for category := range seen {
+			counts[category]++
+		}
+	}
+	items := []*sqlvods.GetPopularCategoriesRow{}
+	for category, count := range counts {
+		items = append(items, &sqlvods.GetPopularCategoriesRow{Count: count, GameNameAtStart: category.gameName, GameIDAtStart: category.gameId})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].GameIDAtStart < items[j].GameIDAtStart
+	})
+	return truncate(items, limit), nil
+}
+
+func (m *Memory) GetStream(ctx context.Context, arg sqlvods.GetStreamParams) ([]*sqlvods.GetStreamRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok {
+		return []*sqlvods.GetStreamRow{}, nil
+	}
+	return []*sqlvods.GetStreamRow{{
+		StreamID:             stream.StreamID,
+		StreamerID:           stream.StreamerID,
+		StreamerLoginAtStart: stream.StreamerLoginAtStart,
+		GameIDAtStart:        stream.GameIDAtStart,
+		StartTime:            stream.StartTime,
+		MaxViews:             stream.MaxViews,
+		LastUpdatedAt:        stream.LastUpdatedAt,
+		RecordingFetchedAt:   stream.RecordingFetchedAt,
+		HlsFetchAttempts:     stream.HlsFetchAttempts,
+	}}, nil
+}
+
+func (m *Memory) GetStreamGzippedBytes(ctx context.Context, arg sqlvods.GetStreamGzippedBytesParams) ([][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok || stream.HlsGone {
+		return [][]byte{}, nil
+	}
+	return [][]byte{stream.GzippedBytes}, nil
+}
+
+func (m *Memory) GetStreamRenditions(ctx context.Context, arg sqlvods.GetStreamRenditionsParams) ([][]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok || stream.HlsGone {
+		return [][]string{}, nil
+	}
+	return [][]string{stream.Renditions}, nil
+}
+
+func (m *Memory) GetStreamSegments(ctx context.Context, arg sqlvods.GetStreamSegmentsParams) ([]*sqlvods.GetStreamSegmentsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	items := []*sqlvods.GetStreamSegmentsRow{}
+	for _, segment := range m.segments[getStreamKey(arg.StreamID, arg.StartTime)] {
+		items = append(items, &sqlvods.GetStreamSegmentsRow{
+			GameID:      segment.GameID,
+			GameName:    segment.GameName,
+			Title:       segment.Title,
+			FirstSeenAt: segment.FirstSeenAt,
+			LastSeenAt:  segment.LastSeenAt,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].FirstSeenAt.Equal(items[j].FirstSeenAt) {
+			return items[i].FirstSeenAt.Before(items[j].FirstSeenAt)
+		}
+		if items[i].GameID != items[j].GameID {
+			return items[i].GameID < items[j].GameID
+		}
+		return items[i].Title < items[j].Title
+	})
+	return items, nil
+}
+
+func (m *Memory) GetStreamViewerSeries(ctx context.Context, arg sqlvods.GetStreamViewerSeriesParams) ([][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok {
+		return [][]byte{}, nil
+	}
+	return [][]byte{stream.ViewerSeries}, nil
+}
+
+func (m *Memory) GetStreamsToRetry(ctx context.Context, arg sqlvods.GetStreamsToRetryParams) ([]*sqlvods.GetStreamsToRetryRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	streams := []*sqlvods.Stream{}
+	for _, stream := range m.streams {
+		if !nullBoolEquals(stream.Public, sql.NullBool{Bool: true, Valid: true}) || !nullBoolEquals(stream.BytesFound, sql.NullBool{Bool: false, Valid: true}) || stream.LastUpdatedAt.Before(arg.LastUpdatedAt) {
+			continue
+		}
+		streams = append(streams, stream)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].MaxViews > streams[j].MaxViews
+	})
+	items := []*sqlvods.GetStreamsToRetryRow{}
+	for _, stream := range truncate(streams, arg.Limit) {
+		items = append(items, &sqlvods.GetStreamsToRetryRow{
+			StreamID:             stream.StreamID,
+			StreamerID:           stream.StreamerID,
+			StreamerLoginAtStart: stream.StreamerLoginAtStart,
+			GameIDAtStart:        stream.GameIDAtStart,
+			StartTime:            stream.StartTime,
+			MaxViews:             stream.MaxViews,
+			LastUpdatedAt:        stream.LastUpdatedAt,
+			RecordingFetchedAt:   stream.RecordingFetchedAt,
+			HlsFetchAttempts:     stream.HlsFetchAttempts,
+		})
+	}
+	return items, nil
+}
+
+func (m *Memory) GetStreamsToVerify(ctx context.Context, arg sqlvods.GetStreamsToVerifyParams) ([]*sqlvods.GetStreamsToVerifyRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	streams := []*sqlvods.Stream{}
+	for _, stream := range m.streams {
+		if !nullBoolEquals(stream.BytesFound, sql.NullBool{Bool: true, Valid: true}) || stream.GzippedBytes == nil {
+			continue
+		}
+		before := arg.LastVerifiedAt
+		if stream.HlsGone {
+			before = arg.GoneVerifiedAt
+		} else if stream.HlsGoneChecks > 0 {
+			before = arg.GoneCheckVerifiedAt
+		}
+		// last_verified_at < NULL is never true in SQL.
+		if stream.LastVerifiedAt.Valid && !(before.Valid && stream.LastVerifiedAt.Time.Before(before.Time)) {
+			continue
+		}
+		streams = append(streams, stream)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		a, b := streams[i].LastVerifiedAt, streams[j].LastVerifiedAt
+		if a.Valid != b.Valid {
+			return !a.Valid
+		}
+		if a.Valid && !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return streams[i].StartTime.Before(streams[j].StartTime)
+	})
+	items := []*sqlvods.GetStreamsToVerifyRow{}
+	for _, stream := range truncate(streams, arg.Limit) {
+		items = append(items, &sqlvods.GetStreamsToVerifyRow{
+			StreamID:             stream.StreamID,
+			StartTime:            stream.StartTime,
+			StreamerLoginAtStart: stream.StreamerLoginAtStart,
+			HlsDomain:            stream.HlsDomain,
+			GzippedBytes:         stream.GzippedBytes,
+		})
+	}
+	return items, nil
+}
+
+// Mirrors buildSearchStreamsQuery. A stream without a duration doesn't match a duration filter, like NULL in Postgres.
+func (m *Memory) SearchStreams(ctx context.Context, arg SearchStreamsParams) ([]*SearchStreamsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	streams := m.popularStreams(func(stream *sqlvods.Stream) bool {
+		if arg.LanguageAtStart.Valid && stream.LanguageAtStart != arg.LanguageAtStart.String {
+			return false
+		}
+		if arg.GameID.Valid && !m.playedGame(stream, arg.GameID.String) {
+			return false
+		}
+		if arg.Public.Valid && !nullBoolEquals(stream.Public, arg.Public) {
+			return false
+		}
+		if arg.IsMatureAtStart.Valid && stream.IsMatureAtStart != arg.IsMatureAtStart.Bool {
+			return false
+		}
+		duration := stream.HlsDurationSeconds
+		if arg.MinDurationSeconds.Valid && !(duration.Valid && duration.Float64 >= arg.MinDurationSeconds.Float64) {
+			return false
+		}
+		if arg.MaxDurationSeconds.Valid && !(duration.Valid && duration.Float64 <= arg.MaxDurationSeconds.Float64) {
+			return false
+		}
+		if arg.MinViews.Valid && stream.MaxViews < arg.MinViews.Int64 {
+			return false
+		}
+		return startedBetween(stream, arg.Since, arg.Until) && viewsBefore(stream, arg.CursorMaxViews, arg.CursorID)
+	}, arg.Limit)
+	items := []*SearchStreamsRow{}
+	for _, stream := range streams {
+		row := toSearchStreamsRow(&stream)
+		items = append(items, &row)
+	}
+	return items, nil
+}
+
+func (m *Memory) UpdateManyViewerSeries(ctx context.Context, arg sqlvods.UpdateManyViewerSeriesParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range arg.StreamIDArr {
+		stream, ok := m.streams[getStreamKey(arg.StreamIDArr[i], arg.StartTimeArr[i])]
+		if ok {
+			stream.ViewerSeries = arg.ViewerSeriesArr[i]
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UpdateRecording(ctx context.Context, arg sqlvods.UpdateRecordingParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok {
+		return nil
+	}
+	stream.RecordingFetchedAt = arg.RecordingFetchedAt
+	stream.HlsDomain = arg.HlsDomain
+	stream.GzippedBytes = arg.GzippedBytes
+	stream.BytesFound = arg.BytesFound
+	stream.Public = arg.Public
+	stream.HlsDurationSeconds = arg.HlsDurationSeconds
+	stream.ProfileImageUrlAtStart = arg.ProfileImageUrlAtStart
+	stream.BoxArtUrlAtStart = arg.BoxArtUrlAtStart
+	stream.HlsLastError = arg.HlsLastError
+	stream.Renditions = arg.Renditions
+	stream.ThumbnailUrl = arg.ThumbnailUrl
+	stream.SeekPreviewsUrl = arg.SeekPreviewsUrl
+	stream.SeekPreviewSpriteUrls = arg.SeekPreviewSpriteUrls
+	stream.HlsFetchAttempts++
+	stream.HlsGone = false
+	stream.HlsGoneChecks = 0
+	return nil
+}
+
+func (m *Memory) UpdateRecordingNotFound(ctx context.Context, arg sqlvods.UpdateRecordingNotFoundParams) ([]sql.NullBool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok {
+		return []sql.NullBool{}, nil
+	}
+	stream.RecordingFetchedAt = arg.RecordingFetchedAt
+	if !stream.BytesFound.Valid {
+		stream.BytesFound = sql.NullBool{Bool: false, Valid: true}
+	}
+	stream.Public = arg.Public
+	stream.ProfileImageUrlAtStart = arg.ProfileImageUrlAtStart
+	stream.BoxArtUrlAtStart = arg.BoxArtUrlAtStart
+	stream.HlsLastError = arg.HlsLastError
+	stream.HlsFetchAttempts++
+	return []sql.NullBool{stream.BytesFound}, nil
+}
+
+func (m *Memory) UpdateStreamer(ctx context.Context, arg sqlvods.UpdateStreamerParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	streamer, ok := m.streamers[arg.StreamerLoginAtStart]
+	if !ok {
+		return nil
+	}
+	streamer.ProfileImageUrlAtStart = arg.ProfileImageUrlAtStart
+	return nil
+}
+
+func (m *Memory) UpdateVerification(ctx context.Context, arg sqlvods.UpdateVerificationParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok {
+		return nil
+	}
+	stream.LastVerifiedAt = arg.LastVerifiedAt
+	stream.HlsGone = false
+	stream.HlsGoneChecks = 0
+	return nil
+}
+
+func (m *Memory) UpdateVerificationFailed(ctx context.Context, arg sqlvods.UpdateVerificationFailedParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok {
+		return nil
+	}
+	stream.LastVerifiedAt = arg.LastVerifiedAt
+	return nil
+}
+
+func (m *Memory) UpdateVerificationMissing(ctx context.Context, arg sqlvods.UpdateVerificationMissingParams) ([]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok {
+		return []bool{}, nil
+	}
+	stream.LastVerifiedAt = arg.LastVerifiedAt
+	stream.HlsGoneChecks++
+	stream.HlsGone = stream.HlsGoneChecks >= arg.GoneAfterChecks
+	return []bool{stream.HlsGone}, nil
+}
+
+func (m *Memory) UpdateVerifiedRecording(ctx context.Context, arg sqlvods.UpdateVerifiedRecordingParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[getStreamKey(arg.StreamID, arg.StartTime)]
+	if !ok {
+		return nil
+	}
+	stream.LastVerifiedAt = arg.LastVerifiedAt
+	stream.GzippedBytes = arg.GzippedBytes
+	stream.HlsDurationSeconds = arg.HlsDurationSeconds
+	if arg.Remuted {
+		stream.HlsRemutedAt = arg.LastVerifiedAt
+	}
+	stream.HlsGone = false
+	stream.HlsGoneChecks = 0
+	return nil
+}
+
+func (m *Memory) UpsertManyStreamSegments(ctx context.Context, arg sqlvods.UpsertManyStreamSegmentsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, streamId := range arg.StreamIDArr {
+		key := getStreamKey(streamId, arg.StartTimeArr[i])
+		segments, ok := m.segments[key]
+		if !ok {
+			segments = map[segmentKey]*sqlvods.StreamSegment{}
+			m.segments[key] = segments
+		}
+		segKey := segmentKey{gameId: arg.GameIDArr[i], title: arg.TitleArr[i]}
+		segment, ok := segments[segKey]
+		if ok {
+			segment.GameName = arg.GameNameArr[i]
+			if arg.SeenAtArr[i].After(segment.LastSeenAt) {
+				segment.LastSeenAt = arg.SeenAtArr[i]
+			}
+			continue
+		}
+		segments[segKey] = &sqlvods.StreamSegment{
+			ID:          uuid.New(),
+			StreamID:    streamId,
+			StartTime:   arg.StartTimeArr[i],
+			GameID:      arg.GameIDArr[i],
+			GameName:    arg.GameNameArr[i],
+			Title:       arg.TitleArr[i],
+			FirstSeenAt: arg.SeenAtArr[i],
+			LastSeenAt:  arg.SeenAtArr[i],
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UpsertManyStreamers(ctx context.Context, arg sqlvods.UpsertManyStreamersParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, login := range arg.StreamerLoginAtStartArr {
+		streamer, ok := m.streamers[login]
+		if ok {
+			streamer.StreamerID = arg.StreamerIDArr[i]
+			streamer.StartTime = arg.StartTimeArr[i]
+			continue
+		}
+		m.streamers[login] = &sqlvods.Streamer{
+			ID:                   uuid.New(),
+			StartTime:            arg.StartTimeArr[i],
+			StreamerLoginAtStart: login,
+			StreamerID:           arg.StreamerIDArr[i],
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UpsertManyStreams(ctx context.Context, arg sqlvods.UpsertManyStreamsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, streamId := range arg.StreamIDArr {
+		key := getStreamKey(streamId, arg.StartTimeArr[i])
+		stream, ok := m.streams[key]
+		if ok {
+			stream.LastUpdatedAt = arg.LastUpdatedAtArr[i]
+			stream.LastUpdatedMinusStartTimeSeconds = arg.LastUpdatedMinusStartTimeSecondsArr[i]
+			if arg.MaxViewsArr[i] > stream.MaxViews {
+				stream.MaxViews = arg.MaxViewsArr[i]
+			}
+			continue
+		}
+		m.streams[key] = &sqlvods.Stream{
+			ID:                               uuid.New(),
+			StreamerID:                       arg.StreamerIDArr[i],
+			StreamID:                         streamId,
+			StartTime:                        arg.StartTimeArr[i],
+			MaxViews:                         arg.MaxViewsArr[i],
+			LastUpdatedAt:                    arg.LastUpdatedAtArr[i],
+			StreamerLoginAtStart:             arg.StreamerLoginAtStartArr[i],
+			LanguageAtStart:                  arg.LanguageAtStartArr[i],
+			TitleAtStart:                     arg.TitleAtStartArr[i],
+			GameNameAtStart:                  arg.GameNameAtStartArr[i],
+			GameIDAtStart:                    arg.GameIDAtStartArr[i],
+			IsMatureAtStart:                  arg.IsMatureAtStartArr[i],
+			LastUpdatedMinusStartTimeSeconds: arg.LastUpdatedMinusStartTimeSecondsArr[i],
+		}
+	}
+	return nil
+}
+
+// The writes never fail, so there is nothing to roll back. Readers can see one upsert without the other.
+func (m *Memory) WritePolledStreams(ctx context.Context, arg WritePolledStreamsParams) error {
+	return writePolledStreams(ctx, m, arg)
+}
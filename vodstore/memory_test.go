@@ -0,0 +1,723 @@
+package vodstore
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/auoie/twitch-vods/timewindow"
+	"github.com/google/uuid"
+)
+
+func assertEqual[T comparable](t testing.TB, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf(`got %v want %v`, got, want)
+	}
+}
+
+func assertNoError(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+type testStream struct {
+	streamId  string
+	login     string
+	startTime time.Time
+	views     int64
+}
+
+func makeUpsertParams(lastUpdated time.Time, streams ...testStream) (sqlvods.UpsertManyStreamsParams, sqlvods.UpsertManyStreamersParams) {
+	params := sqlvods.UpsertManyStreamsParams{}
+	streamerParams := sqlvods.UpsertManyStreamersParams{}
+	for _, stream := range streams {
+		params.LastUpdatedAtArr = append(params.LastUpdatedAtArr, lastUpdated)
+		params.MaxViewsArr = append(params.MaxViewsArr, stream.views)
+		params.StartTimeArr = append(params.StartTimeArr, stream.startTime)
+		params.StreamerIDArr = append(params.StreamerIDArr, "id-"+stream.login)
+		params.StreamIDArr = append(params.StreamIDArr, stream.streamId)
+		params.StreamerLoginAtStartArr = append(params.StreamerLoginAtStartArr, stream.login)
+		params.GameNameAtStartArr = append(params.GameNameAtStartArr, "Just Chatting")
+		params.LanguageAtStartArr = append(params.LanguageAtStartArr, "en")
+		params.TitleAtStartArr = append(params.TitleAtStartArr, "title")
+		params.GameIDAtStartArr = append(params.GameIDAtStartArr, "509658")
+		params.IsMatureAtStartArr = append(params.IsMatureAtStartArr, false)
+		params.LastUpdatedMinusStartTimeSecondsArr = append(params.LastUpdatedMinusStartTimeSecondsArr, lastUpdated.Sub(stream.startTime).Seconds())
+		streamerParams.StreamerIDArr = append(streamerParams.StreamerIDArr, "id-"+stream.login)
+		streamerParams.StartTimeArr = append(streamerParams.StartTimeArr, stream.startTime)
+		streamerParams.StreamerLoginAtStartArr = append(streamerParams.StreamerLoginAtStartArr, stream.login)
+	}
+	return params, streamerParams
+}
+
+func upsertTestStreams(t testing.TB, store Store, lastUpdated time.Time, streams ...testStream) {
+	t.Helper()
+	params, streamerParams := makeUpsertParams(lastUpdated, streams...)
+	assertNoError(t, store.UpsertManyStreams(context.Background(), params))
+	assertNoError(t, store.UpsertManyStreamers(context.Background(), streamerParams))
+}
+
+func setPublic(t testing.TB, store Store, streamId string, startTime time.Time, public bool) {
+	t.Helper()
+	assertNoError(t, store.UpdateRecording(context.Background(), sqlvods.UpdateRecordingParams{
+		StreamID:   streamId,
+		StartTime:  startTime,
+		BytesFound: sql.NullBool{Bool: true, Valid: true},
+		Public:     sql.NullBool{Bool: public, Valid: true},
+	}))
+}
+
+func TestMemoryUpsertManyStreamsKeepsMaxViews(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Minute), testStream{streamId: "1", login: "a", startTime: start, views: 100})
+	upsertTestStreams(t, store, start.Add(2*time.Minute), testStream{streamId: "1", login: "a", startTime: start, views: 50})
+	streams, err := store.GetEverything(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, streams[0].MaxViews, int64(100))
+	assertEqual(t, streams[0].LastUpdatedAt, start.Add(2*time.Minute))
+}
+
+func TestMemorySearchStreamsPages(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "1", login: "a", startTime: start, views: 10},
+		testStream{streamId: "2", login: "b", startTime: start, views: 30},
+		testStream{streamId: "3", login: "c", startTime: start, views: 20},
+		testStream{streamId: "4", login: "d", startTime: start, views: 40},
+	)
+	setPublic(t, store, "1", start, true)
+	setPublic(t, store, "2", start, true)
+	setPublic(t, store, "3", start, true)
+	setPublic(t, store, "4", start, false)
+	results, err := store.SearchStreams(ctx, SearchStreamsParams{
+		Public:         sql.NullBool{Bool: true, Valid: true},
+		Since:          timewindow.All.Since,
+		Until:          timewindow.All.Until,
+		CursorMaxViews: math.MaxInt64,
+		CursorID:       uuid.Max,
+		Limit:          2,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 2)
+	assertEqual(t, results[0].StreamID, "2")
+	assertEqual(t, results[1].StreamID, "3")
+	// The next page starts after the last stream of this one.
+	results, err = store.SearchStreams(ctx, SearchStreamsParams{
+		Public:         sql.NullBool{Bool: true, Valid: true},
+		Since:          timewindow.All.Since,
+		Until:          timewindow.All.Until,
+		CursorMaxViews: results[1].MaxViews,
+		CursorID:       results[1].ID,
+		Limit:          2,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 1)
+	assertEqual(t, results[0].StreamID, "1")
+}
+
+func TestMemorySearchStreamsBreaksTiesById(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "1", login: "a", startTime: start, views: 10},
+		testStream{streamId: "2", login: "b", startTime: start, views: 10},
+		testStream{streamId: "3", login: "c", startTime: start, views: 10},
+	)
+	for _, streamId := range []string{"1", "2", "3"} {
+		setPublic(t, store, streamId, start, true)
+	}
+	params := SearchStreamsParams{
+		Public:         sql.NullBool{Bool: true, Valid: true},
+		Since:          timewindow.All.Since,
+		Until:          timewindow.All.Until,
+		CursorMaxViews: math.MaxInt64,
+		CursorID:       uuid.Max,
+		Limit:          1,
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		results, err := store.SearchStreams(ctx, params)
+		assertNoError(t, err)
+		assertEqual(t, len(results), 1)
+		seen[results[0].StreamID] = true
+		params.CursorMaxViews = results[0].MaxViews
+		params.CursorID = results[0].ID
+	}
+	assertEqual(t, len(seen), 3)
+	results, err := store.SearchStreams(ctx, params)
+	assertNoError(t, err)
+	assertEqual(t, len(results), 0)
+}
+
+func TestMemorySearchStreamsInWindow(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(72*time.Hour),
+		testStream{streamId: "old", login: "a", startTime: start, views: 1000},
+		testStream{streamId: "yesterday", login: "b", startTime: start.Add(24 * time.Hour), views: 100},
+		testStream{streamId: "today", login: "c", startTime: start.Add(48 * time.Hour), views: 10},
+	)
+	for _, stream := range []struct {
+		streamId  string
+		startTime time.Time
+	}{{"old", start}, {"yesterday", start.Add(24 * time.Hour)}, {"today", start.Add(48 * time.Hour)}} {
+		setPublic(t, store, stream.streamId, stream.startTime, true)
+	}
+	params := SearchStreamsParams{
+		Public:         sql.NullBool{Bool: true, Valid: true},
+		Since:          start.Add(24 * time.Hour),
+		Until:          timewindow.All.Until,
+		CursorMaxViews: math.MaxInt64,
+		CursorID:       uuid.Max,
+		Limit:          10,
+	}
+	results, err := store.SearchStreams(ctx, params)
+	assertNoError(t, err)
+	assertEqual(t, len(results), 2)
+	assertEqual(t, results[0].StreamID, "yesterday")
+	assertEqual(t, results[1].StreamID, "today")
+	// The window includes its start and excludes its end.
+	params.Until = start.Add(48 * time.Hour)
+	results, err = store.SearchStreams(ctx, params)
+	assertNoError(t, err)
+	assertEqual(t, len(results), 1)
+	assertEqual(t, results[0].StreamID, "yesterday")
+}
+
+func TestMemoryGetLatestStreamsFromStreamerLoginPages(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(3*time.Hour),
+		testStream{streamId: "1", login: "a", startTime: start, views: 10},
+		testStream{streamId: "2", login: "a", startTime: start.Add(time.Hour), views: 10},
+		testStream{streamId: "3", login: "a", startTime: start.Add(2 * time.Hour), views: 10},
+	)
+	results, err := store.GetLatestStreamsFromStreamerLogin(ctx, sqlvods.GetLatestStreamsFromStreamerLoginParams{
+		StreamerLoginAtStart: "a",
+		CursorStartTime:      start.Add(2 * time.Hour),
+		Limit:                10,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 2)
+	assertEqual(t, results[0].StreamID, "2")
+	assertEqual(t, results[1].StreamID, "1")
+}
+
+func TestMemoryGetLatestLiveStreamsSkipsRecorded(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "1", login: "a", startTime: start, views: 10},
+		testStream{streamId: "2", login: "b", startTime: start, views: 30},
+	)
+	setPublic(t, store, "1", start, true)
+	results, err := store.GetLatestLiveStreams(ctx, start)
+	assertNoError(t, err)
+	assertEqual(t, len(results), 1)
+	assertEqual(t, results[0].StreamID, "2")
+}
+
+func TestMemoryGetMatchingStreamers(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start,
+		testStream{streamId: "1", login: "xqc", startTime: start, views: 10},
+		testStream{streamId: "2", login: "xqc_fan", startTime: start, views: 10},
+		testStream{streamId: "3", login: "shroud", startTime: start, views: 10},
+	)
+	results, err := store.GetMatchingStreamers(ctx, sqlvods.GetMatchingStreamersParams{
+		Query:   "xqc",
+		Pattern: "%XQC%",
+		Limit:   20,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 2)
+	assertEqual(t, results[0].StreamerLoginAtStart, "xqc")
+	assertEqual(t, results[1].StreamerLoginAtStart, "xqc_fan")
+}
+
+func TestMemoryGetMatchingStreamersRanksBySimilarity(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start,
+		testStream{streamId: "1", login: "pokimane", startTime: start, views: 10},
+		testStream{streamId: "2", login: "pokelawls", startTime: start, views: 10},
+		testStream{streamId: "3", login: "pokelawls", startTime: start.Add(24 * time.Hour), views: 10},
+		testStream{streamId: "4", login: "poke", startTime: start, views: 10},
+		testStream{streamId: "5", login: "shroud", startTime: start, views: 10},
+		testStream{streamId: "6", login: "spoke", startTime: start, views: 10},
+	)
+	results, err := store.GetMatchingStreamers(ctx, sqlvods.GetMatchingStreamersParams{
+		Query:   "poke",
+		Pattern: "%poke%",
+		Limit:   20,
+	})
+	assertNoError(t, err)
+	logins := []string{}
+	for _, result := range results {
+		logins = append(logins, result.StreamerLoginAtStart)
+	}
+	// The exact match comes first, then the logins sharing the most trigrams with the query.
+	assertEqual(t, strings.Join(logins, ","), "poke,pokelawls,spoke,pokimane")
+	assertEqual(t, results[1].StreamCount, int64(2))
+	assertEqual(t, results[1].LastStreamStartTime, start.Add(24*time.Hour))
+	// Typos still match.
+	results, err = store.GetMatchingStreamers(ctx, sqlvods.GetMatchingStreamersParams{
+		Query:   "shrod",
+		Pattern: "%shrod%",
+		Limit:   20,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 1)
+	assertEqual(t, results[0].StreamerLoginAtStart, "shroud")
+}
+
+func TestMemoryDeleteOldStreams(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "1", login: "a", startTime: start, views: 10},
+		testStream{streamId: "2", login: "b", startTime: start.Add(time.Hour), views: 10},
+	)
+	numStreams, err := store.DeleteOldStreams(ctx, sqlvods.DeleteOldStreamsParams{StartTime: start.Add(time.Minute), Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, numStreams, int64(1))
+	numStreamers, err := store.DeleteOldStreamers(ctx, sqlvods.DeleteOldStreamersParams{StartTime: start.Add(time.Minute), Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, numStreamers, int64(1))
+	streams, err := store.GetEverything(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, streams[0].StreamID, "2")
+	bytes, err := store.GetStreamGzippedBytes(ctx, sqlvods.GetStreamGzippedBytesParams{StreamID: "1", StartTime: start})
+	assertNoError(t, err)
+	assertEqual(t, len(bytes), 0)
+}
+
+func TestMemoryGetStreamsToRetry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "found", login: "a", startTime: start, views: 10},
+		testStream{streamId: "private", login: "b", startTime: start, views: 10},
+		testStream{streamId: "low", login: "c", startTime: start, views: 10},
+		testStream{streamId: "high", login: "d", startTime: start, views: 30},
+		testStream{streamId: "unfetched", login: "e", startTime: start, views: 10},
+	)
+	setPublic(t, store, "found", start, true)
+	for _, recording := range []struct {
+		streamId string
+		public   bool
+	}{{"private", false}, {"low", true}, {"high", true}, {"high", true}} {
+		assertNoError(t, store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+			StreamID:     recording.streamId,
+			StartTime:    start,
+			BytesFound:   sql.NullBool{Bool: false, Valid: true},
+			Public:       sql.NullBool{Bool: recording.public, Valid: true},
+			HlsLastError: sql.NullString{String: "not found", Valid: true},
+		}))
+	}
+	results, err := store.GetStreamsToRetry(ctx, sqlvods.GetStreamsToRetryParams{LastUpdatedAt: start, Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 2)
+	assertEqual(t, results[0].StreamID, "high")
+	assertEqual(t, results[0].HlsFetchAttempts, int32(2))
+	assertEqual(t, results[1].StreamID, "low")
+	results, err = store.GetStreamsToRetry(ctx, sqlvods.GetStreamsToRetryParams{LastUpdatedAt: start.Add(2 * time.Hour), Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 0)
+}
+
+func TestMemoryGetStreamsToVerify(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "recent", login: "a", startTime: start, views: 10},
+		testStream{streamId: "stale", login: "b", startTime: start, views: 10},
+		testStream{streamId: "never", login: "c", startTime: start.Add(time.Minute), views: 10},
+		testStream{streamId: "gone", login: "d", startTime: start, views: 10},
+		testStream{streamId: "unfetched", login: "e", startTime: start, views: 10},
+		testStream{streamId: "missing", login: "f", startTime: start, views: 10},
+	)
+	for _, streamId := range []string{"recent", "stale", "gone", "missing"} {
+		assertNoError(t, store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+			StreamID:     streamId,
+			StartTime:    start,
+			GzippedBytes: []byte("m3u8"),
+			BytesFound:   sql.NullBool{Bool: true, Valid: true},
+		}))
+	}
+	assertNoError(t, store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+		StreamID:     "never",
+		StartTime:    start.Add(time.Minute),
+		GzippedBytes: []byte("m3u8"),
+		BytesFound:   sql.NullBool{Bool: true, Valid: true},
+	}))
+	verify := func(streamId string, verifiedAt time.Time) {
+		assertNoError(t, store.UpdateVerification(ctx, sqlvods.UpdateVerificationParams{
+			StreamID:       streamId,
+			StartTime:      start,
+			LastVerifiedAt: sql.NullTime{Time: verifiedAt, Valid: true},
+		}))
+	}
+	missing := func(streamId string, verifiedAt time.Time, goneAfterChecks int32) bool {
+		gone, err := store.UpdateVerificationMissing(ctx, sqlvods.UpdateVerificationMissingParams{
+			LastVerifiedAt:  sql.NullTime{Time: verifiedAt, Valid: true},
+			GoneAfterChecks: goneAfterChecks,
+			StreamID:        streamId,
+			StartTime:       start,
+		})
+		assertNoError(t, err)
+		assertEqual(t, len(gone), 1)
+		return gone[0]
+	}
+	verify("recent", start.Add(3*time.Hour))
+	verify("stale", start.Add(time.Hour))
+	assertEqual(t, missing("missing", start.Add(70*time.Minute), 2), false)
+	assertEqual(t, missing("gone", start.Add(time.Hour), 2), false)
+	assertEqual(t, missing("gone", start.Add(80*time.Minute), 2), true)
+	// Streams whose .m3u8 was not found wait for their own cutoffs.
+	results, err := store.GetStreamsToVerify(ctx, sqlvods.GetStreamsToVerifyParams{
+		LastVerifiedAt:      sql.NullTime{Time: start.Add(2 * time.Hour), Valid: true},
+		GoneCheckVerifiedAt: sql.NullTime{Time: start.Add(time.Hour), Valid: true},
+		GoneVerifiedAt:      sql.NullTime{Time: start.Add(time.Hour), Valid: true},
+		Limit:               10,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 2)
+	assertEqual(t, results[0].StreamID, "never")
+	assertEqual(t, results[1].StreamID, "stale")
+	results, err = store.GetStreamsToVerify(ctx, sqlvods.GetStreamsToVerifyParams{
+		LastVerifiedAt:      sql.NullTime{Time: start.Add(2 * time.Hour), Valid: true},
+		GoneCheckVerifiedAt: sql.NullTime{Time: start.Add(2 * time.Hour), Valid: true},
+		GoneVerifiedAt:      sql.NullTime{Time: start.Add(2 * time.Hour), Valid: true},
+		Limit:               10,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 4)
+	assertEqual(t, results[2].StreamID, "missing")
+	assertEqual(t, results[3].StreamID, "gone")
+
+	assertNoError(t, store.UpdateVerifiedRecording(ctx, sqlvods.UpdateVerifiedRecordingParams{
+		LastVerifiedAt: sql.NullTime{Time: start.Add(4 * time.Hour), Valid: true},
+		GzippedBytes:   []byte("muted"),
+		Remuted:        true,
+		StreamID:       "stale",
+		StartTime:      start,
+	}))
+	bytes, err := store.GetStreamGzippedBytes(ctx, sqlvods.GetStreamGzippedBytesParams{StreamID: "stale", StartTime: start})
+	assertNoError(t, err)
+	assertEqual(t, string(bytes[0]), "muted")
+	bytes, err = store.GetStreamGzippedBytes(ctx, sqlvods.GetStreamGzippedBytesParams{StreamID: "gone", StartTime: start})
+	assertNoError(t, err)
+	assertEqual(t, len(bytes), 0)
+}
+
+func TestMemoryDeleteOldStreamsInBatches(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "1", login: "a", startTime: start, views: 10},
+		testStream{streamId: "2", login: "b", startTime: start.Add(time.Minute), views: 10},
+		testStream{streamId: "3", login: "c", startTime: start.Add(2 * time.Minute), views: 10},
+	)
+	numDeleted, err := store.DeleteOldStreams(ctx, sqlvods.DeleteOldStreamsParams{StartTime: start.Add(time.Hour), Limit: 2})
+	assertNoError(t, err)
+	assertEqual(t, numDeleted, int64(2))
+	streams, err := store.GetEverything(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, streams[0].StreamID, "3")
+}
+
+func TestMemoryClearOldRecordings(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "old", login: "a", startTime: start, views: 10},
+		testStream{streamId: "new", login: "b", startTime: start.Add(time.Hour), views: 10},
+	)
+	for _, stream := range []struct {
+		streamId  string
+		startTime time.Time
+	}{{"old", start}, {"new", start.Add(time.Hour)}} {
+		assertNoError(t, store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+			StreamID:     stream.streamId,
+			StartTime:    stream.startTime,
+			GzippedBytes: []byte("m3u8"),
+			BytesFound:   sql.NullBool{Bool: true, Valid: true},
+			Renditions:   []string{"chunked", "360p30"},
+		}))
+	}
+	numCleared, err := store.ClearOldRecordings(ctx, sqlvods.ClearOldRecordingsParams{StartTime: start.Add(time.Minute), Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, numCleared, int64(1))
+	numCleared, err = store.ClearOldRecordings(ctx, sqlvods.ClearOldRecordingsParams{StartTime: start.Add(time.Minute), Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, numCleared, int64(0))
+	bytes, err := store.GetStreamGzippedBytes(ctx, sqlvods.GetStreamGzippedBytesParams{StreamID: "old", StartTime: start})
+	assertNoError(t, err)
+	assertEqual(t, len(bytes), 1)
+	assertEqual(t, bytes[0] == nil, true)
+	bytes, err = store.GetStreamGzippedBytes(ctx, sqlvods.GetStreamGzippedBytesParams{StreamID: "new", StartTime: start.Add(time.Hour)})
+	assertNoError(t, err)
+	assertEqual(t, string(bytes[0]), "m3u8")
+	renditions, err := store.GetStreamRenditions(ctx, sqlvods.GetStreamRenditionsParams{StreamID: "old", StartTime: start})
+	assertNoError(t, err)
+	assertEqual(t, len(renditions[0]), 0)
+	// Cleared recordings are no longer found, so the lists don't link to them.
+	streams, err := store.GetEverything(ctx)
+	assertNoError(t, err)
+	for _, stream := range streams {
+		assertEqual(t, stream.BytesFound, sql.NullBool{Bool: stream.StreamID == "new", Valid: true})
+	}
+	renditions, err = store.GetStreamRenditions(ctx, sqlvods.GetStreamRenditionsParams{StreamID: "new", StartTime: start.Add(time.Hour)})
+	assertNoError(t, err)
+	assertEqual(t, len(renditions[0]), 2)
+}
+
+func TestMemoryWritePolledStreams(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	params, streamerParams := makeUpsertParams(start, testStream{streamId: "1", login: "a", startTime: start, views: 100})
+	assertNoError(t, store.WritePolledStreams(ctx, WritePolledStreamsParams{Streams: params, Streamers: streamerParams}))
+	streams, err := store.GetEverything(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(streams), 1)
+	assertEqual(t, streams[0].StreamID, "1")
+	streamers, err := store.GetMatchingStreamers(ctx, sqlvods.GetMatchingStreamersParams{Query: "a", Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, len(streamers), 1)
+}
+
+func TestMemoryUpdateManyViewerSeries(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start, testStream{streamId: "1", login: "a", startTime: start, views: 100})
+	assertNoError(t, store.UpdateManyViewerSeries(ctx, sqlvods.UpdateManyViewerSeriesParams{
+		StreamIDArr:     []string{"1", "missing"},
+		StartTimeArr:    []time.Time{start, start},
+		ViewerSeriesArr: [][]byte{[]byte("series"), []byte("ignored")},
+	}))
+	results, err := store.GetStreamViewerSeries(ctx, sqlvods.GetStreamViewerSeriesParams{StreamID: "1", StartTime: start})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 1)
+	assertEqual(t, string(results[0]), "series")
+	results, err = store.GetStreamViewerSeries(ctx, sqlvods.GetStreamViewerSeriesParams{StreamID: "missing", StartTime: start})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 0)
+}
+
+func TestMemoryStreamSegmentsMatchAnyGame(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+	upsertTestStreams(t, store, start,
+		testStream{streamId: "1", login: "a", startTime: start, views: 10},
+		testStream{streamId: "2", login: "b", startTime: start.Add(time.Minute), views: 20},
+	)
+	setPublic(t, store, "1", start, true)
+	setPublic(t, store, "2", start.Add(time.Minute), true)
+	// Stream 1 switches from Just Chatting to GTA V and changes its title while playing GTA V.
+	assertNoError(t, store.UpsertManyStreamSegments(ctx, sqlvods.UpsertManyStreamSegmentsParams{
+		StreamIDArr:  []string{"1", "2", "1", "1", "1"},
+		StartTimeArr: []time.Time{start, start.Add(time.Minute), start, start, start},
+		GameIDArr:    []string{"509658", "509658", "32982", "32982", "32982"},
+		GameNameArr:  []string{"Just Chatting", "Just Chatting", "GTA V", "GTA V", "GTA V"},
+		TitleArr:     []string{"title", "title", "title", "rp", "rp"},
+		SeenAtArr:    []time.Time{start, start, start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)},
+	}))
+	results, err := store.SearchStreams(ctx, SearchStreamsParams{
+		GameID:         sql.NullString{String: "32982", Valid: true},
+		Public:         sql.NullBool{Bool: true, Valid: true},
+		Since:          timewindow.All.Since,
+		Until:          timewindow.All.Until,
+		CursorMaxViews: math.MaxInt64,
+		CursorID:       uuid.Max,
+		Limit:          10,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 1)
+	assertEqual(t, results[0].StreamID, "1")
+	results, err = store.SearchStreams(ctx, SearchStreamsParams{
+		GameID:         sql.NullString{String: "509658", Valid: true},
+		Public:         sql.NullBool{Bool: true, Valid: true},
+		Since:          timewindow.All.Since,
+		Until:          timewindow.All.Until,
+		CursorMaxViews: math.MaxInt64,
+		CursorID:       uuid.Max,
+		Limit:          10,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 2)
+	categories, err := store.GetPopularCategories(ctx, 10)
+	assertNoError(t, err)
+	assertEqual(t, len(categories), 2)
+	assertEqual(t, categories[0].GameIDAtStart, "509658")
+	assertEqual(t, categories[0].Count, int64(2))
+	// The stream is counted once even though it was seen with two titles.
+	assertEqual(t, categories[1].GameIDAtStart, "32982")
+	assertEqual(t, categories[1].Count, int64(1))
+	// Deleting a stream deletes its segments.
+	numStreams, err := store.DeleteOldStreams(ctx, sqlvods.DeleteOldStreamsParams{StartTime: start.Add(time.Second), Limit: 10})
+	assertNoError(t, err)
+	assertEqual(t, numStreams, int64(1))
+	categories, err = store.GetPopularCategories(ctx, 10)
+	assertNoError(t, err)
+	assertEqual(t, len(categories), 1)
+	assertEqual(t, categories[0].GameIDAtStart, "509658")
+	assertEqual(t, categories[0].Count, int64(1))
+}
+
+func TestMemoryGetStreamSegments(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start, testStream{streamId: "1", login: "a", startTime: start, views: 10})
+	assertNoError(t, store.UpsertManyStreamSegments(ctx, sqlvods.UpsertManyStreamSegmentsParams{
+		StreamIDArr:  []string{"1", "1", "1"},
+		StartTimeArr: []time.Time{start, start, start},
+		GameIDArr:    []string{"32982", "509658", "509658"},
+		GameNameArr:  []string{"GTA V", "Just Chatting", "Just Chatting"},
+		TitleArr:     []string{"title", "title", "title"},
+		SeenAtArr:    []time.Time{start.Add(time.Hour), start.Add(time.Minute), start.Add(2 * time.Hour)},
+	}))
+	segments, err := store.GetStreamSegments(ctx, sqlvods.GetStreamSegmentsParams{StreamID: "1", StartTime: start})
+	assertNoError(t, err)
+	assertEqual(t, len(segments), 2)
+	assertEqual(t, segments[0].GameID, "509658")
+	assertEqual(t, segments[0].FirstSeenAt, start.Add(time.Minute))
+	assertEqual(t, segments[0].LastSeenAt, start.Add(2*time.Hour))
+	assertEqual(t, segments[1].GameID, "32982")
+}
+
+func TestMemorySearchStreams(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	upsertTestStreams(t, store, start.Add(time.Hour),
+		testStream{streamId: "short", login: "a", startTime: start, views: 10},
+		testStream{streamId: "long", login: "b", startTime: start, views: 20},
+		testStream{streamId: "private", login: "c", startTime: start, views: 30},
+		testStream{streamId: "missing", login: "d", startTime: start, views: 40},
+	)
+	for streamId, seconds := range map[string]float64{"short": 60, "long": 7200, "private": 7200} {
+		assertNoError(t, store.UpdateRecording(ctx, sqlvods.UpdateRecordingParams{
+			StreamID:           streamId,
+			StartTime:          start,
+			BytesFound:         sql.NullBool{Bool: true, Valid: true},
+			Public:             sql.NullBool{Bool: streamId != "private", Valid: true},
+			HlsDurationSeconds: sql.NullFloat64{Float64: seconds, Valid: true},
+		}))
+	}
+	assertNoError(t, store.UpsertManyStreamSegments(ctx, sqlvods.UpsertManyStreamSegmentsParams{
+		StreamIDArr:  []string{"long", "private", "missing"},
+		StartTimeArr: []time.Time{start, start, start},
+		GameIDArr:    []string{"509658", "32982", "509658"},
+		GameNameArr:  []string{"Just Chatting", "GTA V", "Just Chatting"},
+		TitleArr:     []string{"title", "title", "title"},
+		SeenAtArr:    []time.Time{start, start, start},
+	}))
+	search := func(params SearchStreamsParams) []string {
+		t.Helper()
+		params.Since = timewindow.All.Since
+		params.Until = timewindow.All.Until
+		params.CursorMaxViews = math.MaxInt64
+		params.CursorID = uuid.Max
+		params.Limit = 10
+		results, err := store.SearchStreams(ctx, params)
+		assertNoError(t, err)
+		streamIds := []string{}
+		for _, result := range results {
+			streamIds = append(streamIds, result.StreamID)
+		}
+		return streamIds
+	}
+	// Without filters every stream matches, including the ones whose .m3u8 was not found.
+	assertEqual(t, strings.Join(search(SearchStreamsParams{}), ","), "missing,private,long,short")
+	assertEqual(t, strings.Join(search(SearchStreamsParams{
+		Public: sql.NullBool{Bool: true, Valid: true},
+	}), ","), "long,short")
+	assertEqual(t, strings.Join(search(SearchStreamsParams{
+		Public:             sql.NullBool{Bool: true, Valid: true},
+		MinDurationSeconds: sql.NullFloat64{Float64: 3600, Valid: true},
+	}), ","), "long")
+	// Streams without a duration don't match a duration filter.
+	assertEqual(t, strings.Join(search(SearchStreamsParams{
+		MaxDurationSeconds: sql.NullFloat64{Float64: 3600, Valid: true},
+	}), ","), "short")
+	assertEqual(t, strings.Join(search(SearchStreamsParams{
+		MinViews:        sql.NullInt64{Int64: 20, Valid: true},
+		LanguageAtStart: sql.NullString{String: "en", Valid: true},
+		GameID:          sql.NullString{String: "509658", Valid: true},
+		IsMatureAtStart: sql.NullBool{Bool: false, Valid: true},
+	}), ","), "missing,long")
+	assertEqual(t, len(search(SearchStreamsParams{
+		LanguageAtStart: sql.NullString{String: "de", Valid: true},
+	})), 0)
+	assertEqual(t, len(search(SearchStreamsParams{
+		IsMatureAtStart: sql.NullBool{Bool: true, Valid: true},
+	})), 0)
+}
+
+func TestMemoryGetMatchingTitles(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	params, _ := makeUpsertParams(start.Add(time.Hour),
+		testStream{streamId: "1", login: "a", startTime: start, views: 10},
+		testStream{streamId: "2", login: "b", startTime: start, views: 20},
+		testStream{streamId: "3", login: "c", startTime: start, views: 30},
+		testStream{streamId: "4", login: "d", startTime: start.Add(24 * time.Hour), views: 40},
+	)
+	params.TitleAtStartArr = []string{"Speedrun world record attempts", "speedrun", "chill stream", "speedrun"}
+	params.GameNameAtStartArr = []string{"Minecraft", "Minecraft", "Just Chatting", "Minecraft"}
+	assertNoError(t, store.UpsertManyStreams(ctx, params))
+	results, err := store.GetMatchingTitles(ctx, sqlvods.GetMatchingTitlesParams{
+		Query: "speedrun",
+		Since: timewindow.All.Since,
+		Until: start.Add(time.Hour),
+		Limit: 10,
+	})
+	assertNoError(t, err)
+	// Exact matches are ranked by views, and streams outside the window don't match.
+	assertEqual(t, len(results), 2)
+	assertEqual(t, results[0].StreamID, "2")
+	assertEqual(t, results[1].StreamID, "1")
+	// Game names match too, with typos.
+	results, err = store.GetMatchingTitles(ctx, sqlvods.GetMatchingTitlesParams{
+		Query: "minecrat",
+		Since: timewindow.All.Since,
+		Until: timewindow.All.Until,
+		Limit: 2,
+	})
+	assertNoError(t, err)
+	assertEqual(t, len(results), 2)
+	assertEqual(t, results[0].StreamID, "4")
+	assertEqual(t, results[1].StreamID, "2")
+}
@@ -0,0 +1,158 @@
+package vodstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The filters of SearchStreams. Invalid fields don't filter. The rest are always applied,
+// like the window and cursor of the list queries in ./sqlc/queries.sql.
+type SearchStreamsParams struct {
+	LanguageAtStart sql.NullString
+	// matches every game the stream played
+	GameID          sql.NullString
+	Public          sql.NullBool
+	IsMatureAtStart sql.NullBool
+	// Streams whose .m3u8 was not found have no duration, so they never match a duration filter.
+	MinDurationSeconds sql.NullFloat64
+	MaxDurationSeconds sql.NullFloat64
+	MinViews           sql.NullInt64
+	Since              time.Time
+	Until              time.Time
+	CursorMaxViews     int64
+	CursorID           uuid.UUID
+	Limit              int32
+}
+
+// A stream returned by SearchStreams. It has the columns of the list queries in ./sqlc/queries.sql,
+// so their row types, e.g. sqlvods.GetLatestStreamsFromStreamerLoginRow, are convertible to it.
+type SearchStreamsRow struct {
+	ID                     uuid.UUID
+	MaxViews               int64
+	StartTime              time.Time
+	StreamerID             string
+	StreamID               string
+	StreamerLoginAtStart   string
+	GameNameAtStart        string
+	LanguageAtStart        string
+	TitleAtStart           string
+	IsMatureAtStart        bool
+	GameIDAtStart          string
+	BytesFound             sql.NullBool
+	Public                 sql.NullBool
+	HlsDurationSeconds     sql.NullFloat64
+	BoxArtUrlAtStart       sql.NullString
+	ProfileImageUrlAtStart sql.NullString
+	ThumbnailUrl           sql.NullString
+	HlsGone                bool
+}
+
+// The columns of SearchStreamsRow in order.
+const searchStreamsColumns = "id, max_views, start_time, streamer_id, stream_id, streamer_login_at_start, game_name_at_start, language_at_start, title_at_start, is_mature_at_start, game_id_at_start, bytes_found, public, hls_duration_seconds, box_art_url_at_start, profile_image_url_at_start, thumbnail_url, hls_gone"
+
+// sqlc can't leave out the conditions of missing filters, and a condition like ($1 IS NULL OR public = $1)
+// keeps Postgres from using an index once it switches to a generic plan, so the query is built for the filters that are set.
+// Only fixed SQL is concatenated. Every value is passed as a parameter.
+func buildSearchStreamsQuery(arg SearchStreamsParams) (string, []any) {
+	args := []any{}
+	param := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprint("$", len(args))
+	}
+	// The window comes first so that the game filter can bound the segments by it too,
+	// which lets Postgres find them with stream_segments(game_id, start_time) for short windows.
+	since, until := param(arg.Since), param(arg.Until)
+	conditions := []string{}
+	if arg.LanguageAtStart.Valid {
+		conditions = append(conditions, "language_at_start = "+param(arg.LanguageAtStart.String))
+	}
+	if arg.GameID.Valid {
+		conditions = append(conditions, `EXISTS (
+    SELECT
+      1
+    FROM
+      stream_segments
+    WHERE
+      stream_segments.stream_id = streams.stream_id AND
+      stream_segments.start_time = streams.start_time AND
+      stream_segments.game_id = `+param(arg.GameID.String)+` AND
+      stream_segments.start_time >= `+since+` AND
+      stream_segments.start_time < `+until+")")
+	}
+	if arg.Public.Valid {
+		conditions = append(conditions, "public = "+param(arg.Public.Bool))
+	}
+	if arg.IsMatureAtStart.Valid {
+		conditions = append(conditions, "is_mature_at_start = "+param(arg.IsMatureAtStart.Bool))
+	}
+	if arg.MinDurationSeconds.Valid {
+		conditions = append(conditions, "hls_duration_seconds >= "+param(arg.MinDurationSeconds.Float64))
+	}
+	if arg.MaxDurationSeconds.Valid {
+		conditions = append(conditions, "hls_duration_seconds <= "+param(arg.MaxDurationSeconds.Float64))
+	}
+	if arg.MinViews.Valid {
+		conditions = append(conditions, "max_views >= "+param(arg.MinViews.Int64))
+	}
+	conditions = append(conditions,
+		"streams.start_time >= "+since,
+		"streams.start_time < "+until,
+		fmt.Sprint("(max_views, id) < (", param(arg.CursorMaxViews), "::BIGINT, ", param(arg.CursorID), "::UUID)"),
+	)
+	query := fmt.Sprint(`SELECT
+  `, searchStreamsColumns, `
+FROM
+  streams
+WHERE
+  `, strings.Join(conditions, " AND\n  "), `
+ORDER BY
+  max_views DESC, id DESC
+LIMIT `, param(arg.Limit))
+	return query, args
+}
+
+// Returns the streams matching every filter that is set ordered by (max_views, id) DESC.
+func (p *Postgres) SearchStreams(ctx context.Context, arg SearchStreamsParams) ([]*SearchStreamsRow, error) {
+	query, args := buildSearchStreamsQuery(arg)
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*SearchStreamsRow
+	for rows.Next() {
+		var i SearchStreamsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.MaxViews,
+			&i.StartTime,
+			&i.StreamerID,
+			&i.StreamID,
+			&i.StreamerLoginAtStart,
+			&i.GameNameAtStart,
+			&i.LanguageAtStart,
+			&i.TitleAtStart,
+			&i.IsMatureAtStart,
+			&i.GameIDAtStart,
+			&i.BytesFound,
+			&i.Public,
+			&i.HlsDurationSeconds,
+			&i.BoxArtUrlAtStart,
+			&i.ProfileImageUrlAtStart,
+			&i.ThumbnailUrl,
+			&i.HlsGone,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,64 @@
+package vodstore
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/auoie/twitch-vods/timewindow"
+	"github.com/google/uuid"
+)
+
+func TestBuildSearchStreamsQuery(t *testing.T) {
+	query, args := buildSearchStreamsQuery(SearchStreamsParams{
+		Since:          timewindow.All.Since,
+		Until:          timewindow.All.Until,
+		CursorMaxViews: math.MaxInt64,
+		CursorID:       uuid.Max,
+		Limit:          10,
+	})
+	// Without filters only the window, the cursor and the limit are parameters.
+	assertEqual(t, len(args), 5)
+	assertEqual(t, strings.Contains(query, "public ="), false)
+	assertEqual(t, strings.Contains(query, "LIMIT $5"), true)
+
+	query, args = buildSearchStreamsQuery(SearchStreamsParams{
+		LanguageAtStart:    sql.NullString{String: "en' OR 1=1 --", Valid: true},
+		GameID:             sql.NullString{String: "509658", Valid: true},
+		Public:             sql.NullBool{Bool: true, Valid: true},
+		IsMatureAtStart:    sql.NullBool{Bool: false, Valid: true},
+		MinDurationSeconds: sql.NullFloat64{Float64: 60, Valid: true},
+		MaxDurationSeconds: sql.NullFloat64{Float64: 3600, Valid: true},
+		MinViews:           sql.NullInt64{Int64: 100, Valid: true},
+		Since:              timewindow.All.Since,
+		Until:              timewindow.All.Until,
+		CursorMaxViews:     math.MaxInt64,
+		CursorID:           uuid.Max,
+		Limit:              10,
+	})
+	assertEqual(t, len(args), 12)
+	// Values are passed as parameters and never end up in the query.
+	assertEqual(t, strings.Contains(query, "OR 1=1"), false)
+	assertEqual(t, args[2].(string), "en' OR 1=1 --")
+	for _, condition := range []string{
+		"streams.start_time >= $1",
+		"streams.start_time < $2",
+		"language_at_start = $3",
+		"stream_segments.game_id = $4 AND",
+		// The segments of the game are bounded by the window of the streams.
+		"stream_segments.start_time >= $1 AND",
+		"stream_segments.start_time < $2)",
+		"public = $5",
+		"is_mature_at_start = $6",
+		"hls_duration_seconds >= $7",
+		"hls_duration_seconds <= $8",
+		"max_views >= $9",
+		"(max_views, id) < ($10::BIGINT, $11::UUID)",
+		"LIMIT $12",
+	} {
+		if !strings.Contains(query, condition) {
+			t.Fatalf("query is missing %q:\n%s", condition, query)
+		}
+	}
+}
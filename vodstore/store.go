@@ -0,0 +1,92 @@
+package vodstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/auoie/twitch-vods/sqlvods"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Store is everything the scraper and the string API need from the VOD database.
+// The method set is the sqlc generated Querier, so any new query in ./sqlc/queries.sql
+// has to be implemented by both the Postgres store and the in-memory store.
+type Store interface {
+	sqlvods.Querier
+	// Runs the writes for one poll of Twitch Helix in a single transaction.
+	WritePolledStreams(ctx context.Context, arg WritePolledStreamsParams) error
+	// Lists streams by views, filtered by any combination of SearchStreamsParams.
+	SearchStreams(ctx context.Context, arg SearchStreamsParams) ([]*SearchStreamsRow, error)
+}
+
+// The writes made after every poll of Twitch Helix.
+// Old rows are deleted separately by the scraper's retention job.
+type WritePolledStreamsParams struct {
+	Streams   sqlvods.UpsertManyStreamsParams
+	Segments  sqlvods.UpsertManyStreamSegmentsParams
+	Streamers sqlvods.UpsertManyStreamersParams
+	// viewer series of the streams that finished since the last poll
+	ViewerSeries sqlvods.UpdateManyViewerSeriesParams
+}
+
+func writePolledStreams(ctx context.Context, queries sqlvods.Querier, arg WritePolledStreamsParams) error {
+	err := queries.UpsertManyStreams(ctx, arg.Streams)
+	if err != nil {
+		return err
+	}
+	err = queries.UpsertManyStreamSegments(ctx, arg.Segments)
+	if err != nil {
+		return err
+	}
+	err = queries.UpsertManyStreamers(ctx, arg.Streamers)
+	if err != nil {
+		return err
+	}
+	if len(arg.ViewerSeries.StreamIDArr) == 0 {
+		return nil
+	}
+	return queries.UpdateManyViewerSeries(ctx, arg.ViewerSeries)
+}
+
+// Reports whether err is worth retrying, i.e. the transaction lost a serialization conflict or a deadlock,
+// or the connection failed. Nothing was committed in either case, so the whole transaction can be run again.
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// serialization_failure and deadlock_detected
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// Failing to connect wraps the net.Error.
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return pgconn.SafeToRetry(err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Postgres is the production Store. It is the sqlc queries on top of a pgx connection pool.
+type Postgres struct {
+	*sqlvods.Queries
+	pool *pgxpool.Pool
+}
+
+var _ Store = (*Postgres)(nil)
+
+// The pool is owned by the caller and should be closed by the caller.
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{Queries: sqlvods.New(pool), pool: pool}
+}
+
+// The transaction is rolled back if any write fails.
+func (p *Postgres) WritePolledStreams(ctx context.Context, arg WritePolledStreamsParams) error {
+	return p.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		return writePolledStreams(ctx, p.Queries.WithTx(tx), arg)
+	})
+}